@@ -232,8 +232,8 @@ func runSession(proxy *proxy.Proxy, wg *sync.WaitGroup, round int, seq int) {
 		if Cmd&CallMongo != 0 {
 			mgQuery, _ := bson.Marshal(bson.M{"snsid": "100003391571259"})
 			mgFields, _ := bson.Marshal(bson.M{})
-			result, _, err = client.MgFindOne(ctx, "default", "idmap",
-				0, mgQuery, mgFields)
+			result, _, _, err = client.MgFindOne(ctx, "default", "idmap",
+				0, mgQuery, mgFields, "")
 			if err != nil {
 				report.incCallErr()
 				log.Printf("session{round^%d seq^%d mg_findOne} %v", round, seq, err)
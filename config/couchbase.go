@@ -3,14 +3,39 @@ package config
 import (
 	conf "github.com/funkygao/jsconf"
 	log "github.com/funkygao/log4go"
+	"time"
 )
 
 type ConfigCouchbase struct {
 	Servers []string
+
+	// for REST calls and streaming conns to the cluster
+	ConnectTimeout time.Duration
+	IoTimeout      time.Duration
+
+	// bucket stats watchdog, 0 means disabled
+	StatsInterval time.Duration
+
+	// failed bucket opening and broken streaming conns are retried with
+	// exponential backoff between these
+	RetryBackoff    time.Duration
+	RetryMaxBackoff time.Duration
+
+	// writes with durability requirement block at most DurabilityTimeout
+	DurabilityTimeout      time.Duration
+	DurabilityPollInterval time.Duration
 }
 
 func (this *ConfigCouchbase) LoadConfig(cf *conf.Conf) {
 	this.Servers = cf.StringList("servers", nil)
+	this.ConnectTimeout = cf.Duration("connect_timeout", 4*time.Second)
+	this.IoTimeout = cf.Duration("io_timeout", 10*time.Second)
+	this.StatsInterval = cf.Duration("stats_interval", time.Minute)
+	this.RetryBackoff = cf.Duration("retry_backoff", time.Second)
+	this.RetryMaxBackoff = cf.Duration("retry_max_backoff", time.Minute)
+	this.DurabilityTimeout = cf.Duration("durability_timeout", 5*time.Second)
+	this.DurabilityPollInterval = cf.Duration("durability_poll_interval",
+		20*time.Millisecond)
 	log.Debug("couchbase conf: %+v", *this)
 }
 
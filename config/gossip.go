@@ -0,0 +1,57 @@
+package config
+
+import (
+	conf "github.com/funkygao/jsconf"
+	log "github.com/funkygao/log4go"
+	"time"
+)
+
+// ConfigGossip is for SWIM style membership and state dissemination
+// among fae nodes over udp.
+type ConfigGossip struct {
+	ListenAddr    string
+	AdvertiseAddr string   // ip auto filled if ListenAddr is like ":9003"
+	Seeds         []string // gossip addr of any other nodes
+
+	// failure detection
+	ProbeInterval  time.Duration
+	ProbeTimeout   time.Duration
+	IndirectChecks int
+	SuspectTimeout time.Duration // suspect declared dead unless refuted
+	DeadReclaim    time.Duration // dead nodes forgotten after
+
+	// dissemination
+	GossipInterval time.Duration
+	GossipNodes    int
+	RetransmitMult int // updates piggybacked mult*log(n+1) times
+	SyncInterval   time.Duration
+	MaxPacketSize  int
+
+	// local state published for other nodes
+	StateInterval time.Duration
+}
+
+func (this *ConfigGossip) LoadConfig(cf *conf.Conf) {
+	this.ListenAddr = cf.String("listen_addr", "")
+	if this.ListenAddr != "" {
+		this.AdvertiseAddr = resolveSelfAddr(this.ListenAddr)
+	}
+	this.Seeds = cf.StringList("seeds", nil)
+	this.ProbeInterval = cf.Duration("probe_interval", time.Second)
+	this.ProbeTimeout = cf.Duration("probe_timeout", time.Millisecond*500)
+	this.IndirectChecks = cf.Int("indirect_checks", 3)
+	this.SuspectTimeout = cf.Duration("suspect_timeout", time.Second*5)
+	this.DeadReclaim = cf.Duration("dead_reclaim", time.Minute)
+	this.GossipInterval = cf.Duration("gossip_interval", time.Millisecond*200)
+	this.GossipNodes = cf.Int("gossip_nodes", 3)
+	this.RetransmitMult = cf.Int("retransmit_mult", 4)
+	this.SyncInterval = cf.Duration("sync_interval", time.Second*30)
+	this.MaxPacketSize = cf.Int("max_packet_size", 1400)
+	this.StateInterval = cf.Duration("state_interval", time.Second*5)
+
+	log.Debug("gossip conf: %+v", *this)
+}
+
+func (this *ConfigGossip) Enabled() bool {
+	return this.ListenAddr != ""
+}
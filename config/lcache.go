@@ -7,10 +7,19 @@ import (
 
 type ConfigLcache struct {
 	MaxItems int
+
+	// values bigger than this will be zlib compressed, 0 means never compress
+	CompressThreshold int
+	// hard limit of value size after compression, 0 means unlimited
+	MaxValueSize          int
+	BigValueLogSampleRate int
 }
 
 func (this *ConfigLcache) LoadConfig(cf *conf.Conf) {
 	this.MaxItems = cf.Int("max_items", 1<<30)
+	this.CompressThreshold = cf.Int("compress_threshold", 0)
+	this.MaxValueSize = cf.Int("max_value_size", 0)
+	this.BigValueLogSampleRate = cf.Int("big_value_log_sample_rate", 100)
 
 	log.Debug("lcache conf: %+v", *this)
 }
@@ -39,6 +39,12 @@ type ConfigMemcache struct {
 	ReplicaN              int
 	Breaker               ConfigBreaker
 	Servers               map[string]*ConfigMemcacheServer // key is host:port(addr)
+
+	// values bigger than this will be zlib compressed, 0 means never compress
+	CompressThreshold int
+	// hard limit of value size after compression, 0 means unlimited
+	MaxValueSize          int
+	BigValueLogSampleRate int
 }
 
 func (this *ConfigMemcache) ServerList() []string {
@@ -79,6 +85,9 @@ func (this *ConfigMemcache) LoadConfig(cf *conf.Conf) {
 	this.MaxIdleConnsPerServer = cf.Int("max_idle_conns_per_server", 3)
 	this.MaxConnsPerServer = cf.Int("max_conns_per_server",
 		this.MaxIdleConnsPerServer*10)
+	this.CompressThreshold = cf.Int("compress_threshold", 0)
+	this.MaxValueSize = cf.Int("max_value_size", 1000<<10) // memcached item limit is 1MB
+	this.BigValueLogSampleRate = cf.Int("big_value_log_sample_rate", 100)
 	for i := 0; i < len(cf.List("servers", nil)); i++ {
 		section, err := cf.Section(fmt.Sprintf("servers[%d]", i))
 		if err != nil {
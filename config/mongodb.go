@@ -4,6 +4,8 @@ import (
 	"fmt"
 	conf "github.com/funkygao/jsconf"
 	log "github.com/funkygao/log4go"
+	"reflect"
+	"strings"
 	"time"
 )
 
@@ -15,6 +17,8 @@ type ConfigMongodbServer struct {
 	Pass         string
 	DbName       string
 	ReplicaSet   string
+	Hosts        []string // host:port of replica set members, default Host:Port
+	ReadPref     string   // default read preference of this pool
 	ShardBaseNum int
 
 	uri string // cache of op result
@@ -29,17 +33,24 @@ func (this *ConfigMongodbServer) loadConfig(section *conf.Conf) {
 	this.User = section.String("user", "")
 	this.Pass = section.String("pass", "")
 	this.ReplicaSet = section.String("replicaSet", "")
-	if this.Host == "" ||
-		this.Port == "" ||
+	this.Hosts = section.StringList("hosts", nil)
+	if len(this.Hosts) == 0 && this.Host != "" && this.Port != "" {
+		this.Hosts = []string{this.Host + ":" + this.Port}
+	}
+	this.ReadPref = section.String("read_pref", this.ReadPref)
+	if len(this.Hosts) == 0 ||
 		this.Pool == "" ||
 		this.DbName == "" {
 		panic("required field missing")
 	}
+	if !ValidMongoReadPref(this.ReadPref) {
+		panic("invalid mongodb read_pref: " + this.ReadPref)
+	}
 
 	// http://docs.mongodb.org/manual/reference/connection-string/
-	this.uri = "mongodb://" + this.Host + ":" + this.Port + "/"
+	this.uri = "mongodb://" + strings.Join(this.Hosts, ",") + "/"
 	if this.DbName != "" {
-		this.uri += this.DbName + "/"
+		this.uri += this.DbName
 	}
 	if this.ReplicaSet != "" {
 		this.uri += "?replicaSet=" + this.ReplicaSet
@@ -47,10 +58,42 @@ func (this *ConfigMongodbServer) loadConfig(section *conf.Conf) {
 
 }
 
+// read preferences of mongodb, mapped to the closest mgo consistency mode
+const (
+	MongoReadPrimary          = "primary"
+	MongoReadPrimaryPreferred = "primaryPreferred"
+	MongoReadSecondary        = "secondary"
+	MongoReadNearest          = "nearest"
+	MongoReadMonotonic        = "monotonic" // secondary reads until the 1st write
+)
+
+func ValidMongoReadPref(pref string) bool {
+	switch pref {
+	case MongoReadPrimary, MongoReadPrimaryPreferred, MongoReadSecondary,
+		MongoReadNearest, MongoReadMonotonic:
+		return true
+	}
+
+	return false
+}
+
 func (this *ConfigMongodbServer) Uri() string {
 	return this.uri
 }
 
+// Explicit shard map used by shard_strategy "map".
+type ConfigMongodbShardMap struct {
+	Vbuckets int      // 0 means ranges are of shardId, else of shardId%Vbuckets
+	Ranges   []string // pool:min-max:serverPool[:moving]
+	EtcdPath string   // if set, ranges are the children of it and watched
+}
+
+func (this *ConfigMongodbShardMap) loadConfig(cf *conf.Conf) {
+	this.Vbuckets = cf.Int("vbuckets", 0)
+	this.Ranges = cf.StringList("ranges", nil)
+	this.EtcdPath = cf.String("etcd_path", "")
+}
+
 type ConfigMongodb struct {
 	DebugProtocol         bool
 	DebugHeartbeat        bool
@@ -61,10 +104,22 @@ type ConfigMongodb struct {
 	MaxIdleConnsPerServer int
 	MaxConnsPerServer     int
 	HeartbeatInterval     int
+	ReadPref              string // default read preference of all pools
+	MaxResultDocs         int    // cap of aggregate and distinct result
 	Breaker               ConfigBreaker
+	ShardMap              ConfigMongodbShardMap
 	Servers               map[string]*ConfigMongodbServer // key is pool
 }
 
+// OnlyShardMapChanged tells whether the shard map ranges can be hot
+// reloaded without recreating the mongodb client.
+func (this *ConfigMongodb) OnlyShardMapChanged(that *ConfigMongodb) bool {
+	c1, c2 := *this, *that
+	c1.ShardMap.Ranges, c2.ShardMap.Ranges = nil, nil
+	return reflect.DeepEqual(c1, c2) &&
+		!reflect.DeepEqual(this.ShardMap.Ranges, that.ShardMap.Ranges)
+}
+
 func (this *ConfigMongodb) Enabled() bool {
 	return len(this.Servers) > 0
 }
@@ -80,10 +135,16 @@ func (this *ConfigMongodb) LoadConfig(cf *conf.Conf) {
 	this.MaxConnsPerServer = cf.Int("max_conns_per_server",
 		this.MaxIdleConnsPerServer*5)
 	this.HeartbeatInterval = cf.Int("heartbeat_interval", 120)
+	this.ReadPref = cf.String("read_pref", MongoReadMonotonic)
+	this.MaxResultDocs = cf.Int("max_result_docs", 10000)
 	section, err := cf.Section("breaker")
 	if err == nil {
 		this.Breaker.loadConfig(section)
 	}
+	section, err = cf.Section("shard_map")
+	if err == nil {
+		this.ShardMap.loadConfig(section)
+	}
 	this.Servers = make(map[string]*ConfigMongodbServer)
 	for i := 0; i < len(cf.List("servers", nil)); i++ {
 		section, err := cf.Section(fmt.Sprintf("servers[%d]", i))
@@ -93,6 +154,7 @@ func (this *ConfigMongodb) LoadConfig(cf *conf.Conf) {
 
 		server := new(ConfigMongodbServer)
 		server.ShardBaseNum = this.ShardBaseNum
+		server.ReadPref = this.ReadPref
 		server.loadConfig(section)
 		this.Servers[server.Pool] = server
 	}
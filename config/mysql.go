@@ -9,6 +9,10 @@ import (
 	log "github.com/funkygao/log4go"
 )
 
+// db cache store is shared with mongodb, which falls back to it when
+// mysql section is absent.
+const DefaultCacheStoreMemMaxItems = 10 << 20
+
 type ConfigMysqlServer struct {
 	Pool    string `json:"pool"`
 	Host    string `json:"host"`
@@ -103,7 +107,8 @@ func (this *ConfigMysql) LoadConfig(cf *conf.Conf) {
 	this.CachePrepareStmtMaxItems = cf.Int("cache_prepare_stmt_max_items", 0)
 	this.HeartbeatInterval = cf.Int("heartbeat_interval", 120)
 	this.CacheStore = cf.String("cache_store", "mem")
-	this.CacheStoreMemMaxItems = cf.Int("cache_store_mem_max_items", 10<<20)
+	this.CacheStoreMemMaxItems = cf.Int("cache_store_mem_max_items",
+		DefaultCacheStoreMemMaxItems)
 	this.CacheStoreRedisPool = cf.String("cache_store_redis_pool", "db_cache")
 	this.CacheKeyHash = cf.Bool("cache_key_hash", false)
 	this.DefaultLookupTable = cf.String("default_lookup_table", "")
@@ -4,11 +4,24 @@ import (
 	"github.com/funkygao/golib/ip"
 	conf "github.com/funkygao/jsconf"
 	log "github.com/funkygao/log4go"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type ConfigProxy struct {
+	// etcd | static | file | gossip
+	Discovery             string
+	StaticPeers           []string // for static discovery, self inclusive
+	DiscoveryFile         string   // for file discovery, a peer per line
+	DiscoveryFileInterval time.Duration
+
+	// pool: a blocking conn per outstanding call
+	// mux: calls pipelined over MuxConns shared conns, peers MUST be new
+	// enough to accept mux conns
+	PeerTransport string
+	MuxConns      int
+
 	PoolCapacity       int
 	IdleTimeout        time.Duration
 	IoTimeout          time.Duration
@@ -17,10 +30,33 @@ type ConfigProxy struct {
 	SelfAddr           string
 	TcpNoDelay         bool
 	BufferSize         int
+
+	// standard | consistent
+	// all peers in the cluster MUST use the same selector and weights
+	PeerSelector       string
+	ConsistentReplicas int            // virtual nodes per unit of weight
+	PeerWeights        map[string]int // key is peer addr, 1 if absent
+
+	// peers are pinged every HealthCheckInterval, 0 to disable
+	HealthCheckInterval time.Duration
+	// a peer is ejected from selection after so many consecutive io
+	// errors or timeouts, for EjectBackoff doubled on each failed probe
+	EjectConsecutiveFailures int
+	EjectBackoff             time.Duration
+	EjectMaxBackoff          time.Duration
+	// of remote peers, a single ejection is always allowed
+	MaxEjectionPercent int
+	// keys of ejected peer go to: next(peer on the ring) | local
+	EjectedFallback string
 }
 
 func NewDefaultProxy() *ConfigProxy {
 	return &ConfigProxy{
+		Discovery:             "etcd",
+		DiscoveryFileInterval: time.Second,
+		PeerTransport:         "pool",
+		MuxConns:              2,
+
 		PoolCapacity:       10,
 		IdleTimeout:        0,
 		SelfAddr:           ":0",
@@ -29,13 +65,44 @@ func NewDefaultProxy() *ConfigProxy {
 		DiagnosticInterval: time.Second * 5,
 		TcpNoDelay:         true,
 		BufferSize:         4 << 10,
+		PeerSelector:       "standard",
+		ConsistentReplicas: 160,
+		PeerWeights:        make(map[string]int),
+
+		HealthCheckInterval:      time.Second * 5,
+		EjectConsecutiveFailures: 5,
+		EjectBackoff:             time.Second * 10,
+		EjectMaxBackoff:          time.Minute * 5,
+		MaxEjectionPercent:       50,
+		EjectedFallback:          "next",
 	}
 }
 
+// NewStandaloneProxy is for faed without proxy section, it is the only
+// peer of the cluster.
+func NewStandaloneProxy(selfAddr string) *ConfigProxy {
+	cf := NewDefaultProxy()
+	cf.Discovery = "static"
+	cf.SelfAddr = resolveSelfAddr(selfAddr)
+	return cf
+}
+
 func (this *ConfigProxy) LoadConfig(selfAddr string, cf *conf.Conf) {
 	if selfAddr == "" {
 		panic("proxy self addr unknown")
 	}
+	this.Discovery = cf.String("discovery", "etcd")
+	this.StaticPeers = cf.StringList("static_peers", nil)
+	this.DiscoveryFile = cf.String("discovery_file", "")
+	this.DiscoveryFileInterval = cf.Duration("discovery_file_interval", time.Second)
+	if this.Discovery == "file" && this.DiscoveryFile == "" {
+		panic("proxy discovery_file required")
+	}
+	this.PeerTransport = cf.String("peer_transport", "pool")
+	this.MuxConns = cf.Int("mux_conns", 2)
+	if this.MuxConns < 1 {
+		this.MuxConns = 1
+	}
 	this.PoolCapacity = cf.Int("pool_capacity", 10)
 	this.IdleTimeout = cf.Duration("idle_timeout", 0)
 	this.IoTimeout = cf.Duration("io_timeout", time.Second*10)
@@ -43,8 +110,35 @@ func (this *ConfigProxy) LoadConfig(selfAddr string, cf *conf.Conf) {
 	this.DiagnosticInterval = cf.Duration("diagnostic_interval", time.Second*5)
 	this.TcpNoDelay = cf.Bool("tcp_nodelay", true)
 	this.BufferSize = cf.Int("buffer_size", 4<<10)
-	this.SelfAddr = selfAddr
-	parts := strings.SplitN(this.SelfAddr, ":", 2)
+	this.PeerSelector = cf.String("peer_selector", "standard")
+	this.ConsistentReplicas = cf.Int("consistent_replicas", 160)
+	this.PeerWeights = make(map[string]int)
+	for _, spec := range cf.StringList("peer_weights", nil) {
+		// addr=weight
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 {
+			panic("invalid peer weight: " + spec)
+		}
+		weight, err := strconv.Atoi(parts[1])
+		if err != nil || weight < 0 {
+			panic("invalid peer weight: " + spec)
+		}
+
+		this.PeerWeights[parts[0]] = weight
+	}
+	this.HealthCheckInterval = cf.Duration("health_check_interval", time.Second*5)
+	this.EjectConsecutiveFailures = cf.Int("eject_consecutive_failures", 5)
+	this.EjectBackoff = cf.Duration("eject_backoff", time.Second*10)
+	this.EjectMaxBackoff = cf.Duration("eject_max_backoff", time.Minute*5)
+	this.MaxEjectionPercent = cf.Int("max_ejection_percent", 50)
+	this.EjectedFallback = cf.String("ejected_fallback", "next")
+	this.SelfAddr = resolveSelfAddr(selfAddr)
+
+	log.Debug("proxy conf: %+v", *this)
+}
+
+func resolveSelfAddr(selfAddr string) string {
+	parts := strings.SplitN(selfAddr, ":", 2)
 	if parts[0] == "" {
 		// auto get local ip when self_addr like ":9001"
 		ips, _ := ip.LocalIpv4Addrs()
@@ -52,10 +146,10 @@ func (this *ConfigProxy) LoadConfig(selfAddr string, cf *conf.Conf) {
 			panic("cannot get local ip address")
 		}
 
-		this.SelfAddr = ips[0] + ":" + parts[1]
+		return ips[0] + ":" + parts[1]
 	}
 
-	log.Debug("proxy conf: %+v", *this)
+	return selfAddr
 }
 
 func (this *ConfigProxy) Enabled() bool {
@@ -0,0 +1,37 @@
+package config
+
+import (
+	conf "github.com/funkygao/jsconf"
+	log "github.com/funkygao/log4go"
+	"time"
+)
+
+type ConfigPubsub struct {
+	Pool     string   // redis pool to subscribe and publish
+	Channels []string // SUBSCRIBE
+	Patterns []string // PSUBSCRIBE
+
+	// max buffered messages of each subscriber on each channel/pattern
+	BufferSize     int
+	MaxSubscribers int
+	// subscriber that has not polled for so long is dropped with its buffers
+	SubscriberIdleTimeout time.Duration
+	// upper bound of long poll wait requested by client
+	MaxPollWait time.Duration
+}
+
+func (this *ConfigPubsub) LoadConfig(cf *conf.Conf) {
+	this.Pool = cf.String("pool", "default")
+	this.Channels = cf.StringList("channels", nil)
+	this.Patterns = cf.StringList("patterns", nil)
+	this.BufferSize = cf.Int("buffer_size", 100)
+	this.MaxSubscribers = cf.Int("max_subscribers", 10<<10)
+	this.SubscriberIdleTimeout = cf.Duration("subscriber_idle_timeout", 5*time.Minute)
+	this.MaxPollWait = cf.Duration("max_poll_wait", 30*time.Second)
+
+	log.Debug("pubsub conf: %+v", *this)
+}
+
+func (this *ConfigPubsub) Enabled() bool {
+	return len(this.Channels) > 0 || len(this.Patterns) > 0
+}
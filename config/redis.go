@@ -7,8 +7,11 @@ import (
 	"time"
 )
 
+// A redis shard: a master with optional replicas.
 type ConfigRedisServer struct {
-	Addr        string // host:port
+	Addr        string   // host:port of master
+	Name        string   // stable across failover, also sentinel master name
+	Replicas    []string // host:port of replicas
 	MaxIdle     int
 	MaxActive   int
 	IdleTimeout time.Duration
@@ -19,6 +22,8 @@ func (this *ConfigRedisServer) loadConfig(cf *conf.Conf) {
 	if this.Addr == "" {
 		panic("Empty redis server addr")
 	}
+	this.Name = cf.String("name", this.Addr)
+	this.Replicas = cf.StringList("replicas", nil)
 	this.MaxIdle = cf.Int("max_idle", 10)
 	this.MaxActive = cf.Int("max_active", this.MaxIdle*2)
 	this.IdleTimeout = cf.Duration("idle_timeout", 10*time.Minute)
@@ -26,16 +31,37 @@ func (this *ConfigRedisServer) loadConfig(cf *conf.Conf) {
 
 type ConfigRedis struct {
 	Breaker ConfigBreaker
-	Servers map[string]map[string]*ConfigRedisServer // pool:serverAddr:ConfigRedisServer
+	Servers map[string]map[string]*ConfigRedisServer // pool:shardName:ConfigRedisServer
+
+	// max wait for a free conn when max_active exhausted
+	BorrowTimeout time.Duration
+	// conns idle longer than this will be PINGed on borrow
+	HealthCheckIdle time.Duration
+
+	// sentinels to discover current master of each shard by shard name
+	// empty means master never changes unless config reloaded
+	Sentinels        []string
+	SentinelInterval time.Duration
+
+	// pools in redis cluster mode, whose servers are seed nodes
+	ClusterPools        map[string]bool
+	ClusterMaxRedirects int
 }
 
 func (this *ConfigRedis) LoadConfig(cf *conf.Conf) {
+	this.BorrowTimeout = cf.Duration("borrow_timeout", time.Second)
+	this.HealthCheckIdle = cf.Duration("health_check_idle", time.Minute)
+	this.Sentinels = cf.StringList("sentinels", nil)
+	this.SentinelInterval = cf.Duration("sentinel_interval", 2*time.Second)
+	this.ClusterMaxRedirects = cf.Int("cluster_max_redirects", 5)
+
 	section, err := cf.Section("breaker")
 	if err == nil {
 		this.Breaker.loadConfig(section)
 	}
 
 	this.Servers = make(map[string]map[string]*ConfigRedisServer)
+	this.ClusterPools = make(map[string]bool)
 	for i := 0; i < len(cf.List("pools", nil)); i++ {
 		section, err := cf.Section(fmt.Sprintf("pools[%d]", i))
 		if err != nil {
@@ -48,6 +74,9 @@ func (this *ConfigRedis) LoadConfig(cf *conf.Conf) {
 		}
 
 		this.Servers[pool] = make(map[string]*ConfigRedisServer)
+		if section.Bool("cluster", false) {
+			this.ClusterPools[pool] = true
+		}
 
 		// get servers in each pool
 		for j := 0; j < len(section.List("servers", nil)); j++ {
@@ -58,17 +87,18 @@ func (this *ConfigRedis) LoadConfig(cf *conf.Conf) {
 
 			redisServer := new(ConfigRedisServer)
 			redisServer.loadConfig(server)
-			this.Servers[pool][redisServer.Addr] = redisServer
+			this.Servers[pool][redisServer.Name] = redisServer
 		}
 	}
 
 	log.Debug("redis conf: %+v", *this)
 }
 
+// shard names of a pool
 func (this *ConfigRedis) PoolServers(pool string) []string {
 	r := make([]string, 0)
-	for addr, _ := range this.Servers[pool] {
-		r = append(r, addr)
+	for name, _ := range this.Servers[pool] {
+		r = append(r, name)
 	}
 	return r
 }
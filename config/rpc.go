@@ -17,6 +17,7 @@ type ConfigRpc struct {
 	PreforkMode            bool
 	MaxOutstandingSessions int
 	HostMaxCallPerMinute   int
+	MuxMaxOutstandingCalls int // per peer mux session
 }
 
 func (this *ConfigRpc) LoadConfig(section *conf.Conf) {
@@ -34,6 +35,7 @@ func (this *ConfigRpc) LoadConfig(section *conf.Conf) {
 	this.PreforkMode = section.Bool("prefork_mode", false)
 	this.MaxOutstandingSessions = section.Int("max_outstanding_sessions", 20000)
 	this.HostMaxCallPerMinute = section.Int("host_max_call_per_minute", 100*60)
+	this.MuxMaxOutstandingCalls = section.Int("mux_max_outstanding_calls", 1000)
 
 	log.Debug("rpc conf: %+v", *this)
 }
@@ -15,6 +15,35 @@ type ConfigServant struct {
 	ProfilerRate        int
 	SessionMaxItems     int // LRU cache volumn
 
+	// per call limit of a materialized result set, 0 means unlimited
+	MaxResultRows  int
+	MaxResultBytes int
+
+	// server side cursors for large result sets
+	CursorBatchSize     int
+	CursorMaxBatchSize  int
+	CursorMaxItems      int
+	CursorMaxMysqlItems int // each mysql cursor pins a conn till closed
+	CursorIdleTimeout   time.Duration
+
+	// zk coordination on etcd_servers
+	ZkSessionTimeout time.Duration
+	// ephemeral nodes of a session that has no call for so long are deleted
+	ZkEphemeralIdleTimeout time.Duration
+	ZkMaxEphemerals        int
+	// upper bound of zk_watch wait requested by client
+	ZkMaxWatchWait time.Duration
+
+	// leader election on zk, candidates renew lease within ttl
+	ElectionRoot   string
+	ElectionMaxTtl time.Duration
+
+	// service registry on zk, endpoints renew lease within ttl
+	RegistryRoot   string
+	RegistryMaxTtl time.Duration
+	// services not looked up for so long are no longer cached and watched
+	RegistryIdleTimeout time.Duration
+
 	Mongodb   *ConfigMongodb
 	Memcache  *ConfigMemcache
 	Lcache    *ConfigLcache
@@ -23,6 +52,8 @@ type ConfigServant struct {
 	Redis     *ConfigRedis // TODO
 	Couchbase *ConfigCouchbase
 	Lock      *ConfigLock
+	Pubsub    *ConfigPubsub
+	Gossip    *ConfigGossip
 }
 
 func (this *ConfigServant) LoadConfig(selfAddr string, cf *conf.Conf) {
@@ -32,6 +63,23 @@ func (this *ConfigServant) LoadConfig(selfAddr string, cf *conf.Conf) {
 	this.StatsOutputInterval = cf.Duration("stats_output_interval", 10*time.Minute)
 	this.ProfilerMaxBodySize = cf.Int("profiler_max_body_size", 1<<10)
 	this.ProfilerRate = cf.Int("profiler_rate", 1) // default 1/1000
+	this.MaxResultRows = cf.Int("max_result_rows", 0)
+	this.MaxResultBytes = cf.Int("max_result_bytes", 0)
+	this.CursorBatchSize = cf.Int("cursor_batch_size", 100)
+	this.CursorMaxBatchSize = cf.Int("cursor_max_batch_size", 1000)
+	this.CursorMaxItems = cf.Int("cursor_max_items", 1000)
+	this.CursorIdleTimeout = cf.Duration("cursor_idle_timeout", time.Minute)
+	this.ZkSessionTimeout = cf.Duration("zk_session_timeout", 30*time.Second)
+	this.ZkEphemeralIdleTimeout = cf.Duration("zk_ephemeral_idle_timeout",
+		time.Minute)
+	this.ZkMaxEphemerals = cf.Int("zk_max_ephemerals", 10000)
+	this.ZkMaxWatchWait = cf.Duration("zk_max_watch_wait", 30*time.Second)
+	this.ElectionRoot = cf.String("election_root", "/fae/election")
+	this.ElectionMaxTtl = cf.Duration("election_max_ttl", 5*time.Minute)
+	this.RegistryRoot = cf.String("registry_root", "/fae/services")
+	this.RegistryMaxTtl = cf.Duration("registry_max_ttl", 5*time.Minute)
+	this.RegistryIdleTimeout = cf.Duration("registry_idle_timeout",
+		10*time.Minute)
 
 	// mongodb section
 	this.Mongodb = new(ConfigMongodb)
@@ -45,6 +93,9 @@ func (this *ConfigServant) LoadConfig(selfAddr string, cf *conf.Conf) {
 	if err == nil {
 		this.Mysql.LoadConfig(section)
 	}
+	// by default cursors can take at most half the conns of a mysql server
+	this.CursorMaxMysqlItems = cf.Int("cursor_max_mysql_items",
+		this.Mysql.MaxConnsPerServer/2)
 
 	this.Redis = new(ConfigRedis)
 	section, err = cf.Section("redis")
@@ -78,6 +129,9 @@ func (this *ConfigServant) LoadConfig(selfAddr string, cf *conf.Conf) {
 	section, err = cf.Section("proxy")
 	if err == nil {
 		this.Proxy.LoadConfig(selfAddr, section)
+	} else if selfAddr != "" {
+		log.Warn("no proxy section, running standalone")
+		this.Proxy = NewStandaloneProxy(selfAddr)
 	}
 
 	this.Lock = new(ConfigLock)
@@ -86,5 +140,17 @@ func (this *ConfigServant) LoadConfig(selfAddr string, cf *conf.Conf) {
 		this.Lock.LoadConfig(section)
 	}
 
+	this.Pubsub = new(ConfigPubsub)
+	section, err = cf.Section("pubsub")
+	if err == nil {
+		this.Pubsub.LoadConfig(section)
+	}
+
+	this.Gossip = new(ConfigGossip)
+	section, err = cf.Section("gossip")
+	if err == nil {
+		this.Gossip.LoadConfig(section)
+	}
+
 	log.Debug("servants conf: %+v", *this)
 }
@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"bufio"
+	"github.com/funkygao/fae/config"
+	"github.com/funkygao/fae/servant/proxy"
+	log "github.com/funkygao/log4go"
+	"github.com/funkygao/thrift/lib/go/thrift"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// peekedConn is a conn whose leading bytes have been sniffed.
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (this *peekedConn) Read(p []byte) (int, error) {
+	return this.r.Read(p)
+}
+
+// sniffMux tells whether the session is a peer mux conn by its leading
+// bytes, the returned conn replays bytes consumed by sniffing.
+func (this *TFunServer) sniffMux(tcpClient *net.TCPConn) (net.Conn, bool) {
+	conn := &peekedConn{Conn: tcpClient,
+		r: bufio.NewReaderSize(tcpClient, config.Engine.Rpc.BufferSize)}
+	if config.Engine.Rpc.IoTimeout > 0 {
+		tcpClient.SetReadDeadline(time.Now().Add(config.Engine.Rpc.IoTimeout))
+	}
+
+	magic, err := conn.r.Peek(len(proxy.MuxMagic))
+	if err != nil || string(magic) != proxy.MuxMagic {
+		return conn, false
+	}
+
+	io.ReadFull(conn.r, magic) // buffered already
+	return conn, true
+}
+
+// handleMuxSession serves calls of a peer mux conn concurrently, each
+// reply goes back as soon as its call is done.
+func (this *TFunServer) handleMuxSession(client thrift.TTransport,
+	tcpClient *net.TCPConn, conn net.Conn) {
+	var (
+		calls           int64
+		errs            int64
+		t1              = time.Now()
+		currentSessionN = atomic.AddInt64(&this.activeSessionN, 1)
+		remoteAddr      = tcpClient.RemoteAddr().String()
+		processor       = this.processorFactory.GetProcessor(client)
+		wmutex          sync.Mutex
+		w               = bufio.NewWriterSize(conn, config.Engine.Rpc.BufferSize)
+		tokens          = make(chan bool, config.Engine.Rpc.MuxMaxOutstandingCalls)
+		wg              sync.WaitGroup
+	)
+
+	atomic.AddInt64(&this.cumSessions, 1)
+	log.Debug("mux session[%s]#%d open", remoteAddr, currentSessionN)
+
+	for {
+		if config.Engine.Rpc.SessionTimeout > 0 {
+			tcpClient.SetReadDeadline(time.Now().Add(config.Engine.Rpc.SessionTimeout))
+		} else {
+			tcpClient.SetReadDeadline(time.Time{})
+		}
+
+		seq, payload, err := proxy.ReadMuxFrame(conn)
+		if err != nil {
+			if err != io.EOF {
+				log.Error("mux transport[%s]: %s", remoteAddr, err)
+				atomic.AddInt64(&errs, 1)
+				this.saveCallError(err)
+			}
+			break
+		}
+
+		tokens <- true
+		wg.Add(1)
+		go func(seq int32, payload []byte) {
+			defer func() {
+				<-tokens
+				wg.Done()
+			}()
+
+			t0 := time.Now()
+			reply, ex := this.processMux(processor, payload)
+			atomic.AddInt64(&calls, 1)
+			this.stats.CallLatencies.Update(time.Since(t0).Nanoseconds() / 1e6)
+			this.stats.CallPerSecond.Mark(1)
+			if ex != nil {
+				// reply carries the TApplicationException
+				atomic.AddInt64(&errs, 1)
+				this.saveCallError(ex)
+				log.Error("caller[%s]: %s", remoteAddr, ex.Error())
+			}
+
+			wmutex.Lock()
+			if config.Engine.Rpc.IoTimeout > 0 {
+				tcpClient.SetWriteDeadline(time.Now().Add(config.Engine.Rpc.IoTimeout))
+			}
+			err := proxy.WriteMuxFrame(w, seq, reply)
+			if err == nil {
+				err = w.Flush()
+			}
+			wmutex.Unlock()
+
+			if err != nil {
+				// the read loop quits on the closed conn
+				log.Error("mux transport[%s]: %s", remoteAddr, err)
+				conn.Close()
+			}
+		}(seq, payload)
+	}
+
+	wg.Wait()
+	conn.Close()
+
+	atomic.AddInt64(&this.cumCalls, calls)
+	atomic.AddInt64(&this.cumCallErrs, errs)
+	this.stats.CallPerSession.Update(calls)
+
+	currentSessionN = atomic.AddInt64(&this.activeSessionN, -1) + 1
+	log.Trace("mux session[%s]#%d %d calls in %s, errs:%d", remoteAddr,
+		currentSessionN, calls, time.Since(t1), errs)
+}
+
+// processMux runs a single call, mux conns always speak binary protocol
+// just as peers in pool mode do.
+func (this *TFunServer) processMux(processor thrift.TProcessor,
+	payload []byte) ([]byte, thrift.TException) {
+	in := thrift.NewTMemoryBufferLen(len(payload))
+	in.Write(payload)
+	out := thrift.NewTMemoryBuffer()
+
+	protocolFactory := thrift.NewTBinaryProtocolFactoryDefault()
+	_, ex := processor.Process(protocolFactory.GetProtocol(in),
+		protocolFactory.GetProtocol(out))
+	return out.Bytes(), ex
+}
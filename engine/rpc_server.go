@@ -172,12 +172,21 @@ func (this *TFunServer) showStats(interval time.Duration) {
 }
 
 func (this *TFunServer) handleSession(client thrift.TTransport) {
+	tcpClient := client.(*thrift.TSocket).Conn().(*net.TCPConn)
+	conn, muxed := this.sniffMux(tcpClient)
+	if muxed {
+		this.handleMuxSession(client, tcpClient, conn)
+		return
+	}
+
+	client = thrift.NewTSocketFromConnTimeout(conn,
+		config.Engine.Rpc.SessionTimeout)
+
 	var (
 		calls           int64 // #calls within this session
 		errs            int64 // #errs within this session
 		t1              = time.Now()
 		currentSessionN = atomic.AddInt64(&this.activeSessionN, 1)
-		tcpClient       = client.(*thrift.TSocket).Conn().(*net.TCPConn)
 		remoteAddr      = tcpClient.RemoteAddr().String()
 		processor       = this.processorFactory.GetProcessor(client)
 		inputTransport  = this.inputTransportFactory.GetTransport(client)
@@ -2,7 +2,10 @@ package servant
 
 import (
 	"github.com/funkygao/assert"
+	"github.com/funkygao/fae/servant/gen-go/fun/rpc"
+	"github.com/funkygao/redigo/redis"
 	"testing"
+	"time"
 )
 
 func TestGolangStringCmp(t *testing.T) {
@@ -11,3 +14,127 @@ func TestGolangStringCmp(t *testing.T) {
 	assert.Equal(t, false, "1" > "1")
 	assert.Equal(t, true, "22" > "2")
 }
+
+func TestRedisReply(t *testing.T) {
+	r := redisReply([]interface{}{
+		[]byte("0"),
+		[]interface{}{[]byte("key1"), nil},
+		int64(12),
+		"OK",
+		redis.Error("WRONGTYPE Operation against a key"),
+	})
+	assert.Equal(t, 5, len(r.Elements))
+	assert.Equal(t, "0", string(r.Elements[0].Bulk))
+	assert.Equal(t, 2, len(r.Elements[1].Elements))
+	assert.Equal(t, true, *r.Elements[1].Elements[1].IsNil)
+	assert.Equal(t, int64(12), *r.Elements[2].Integer)
+	assert.Equal(t, "OK", *r.Elements[3].Status)
+	assert.Equal(t, "WRONGTYPE Operation against a key", *r.Elements[4].ErrorMsg)
+}
+
+type fakeCursor struct {
+	closed int
+}
+
+func (this *fakeCursor) Close() error {
+	this.closed++
+	return nil
+}
+
+func TestCursorRegistry(t *testing.T) {
+	reg := newCursorRegistry(1, 1, time.Minute)
+	c := &fakeCursor{}
+	entry, err := reg.open(1, c, false)
+	assert.Equal(t, nil, err)
+	reg.release(entry)
+
+	_, err = reg.open(1, &fakeCursor{}, false)
+	assert.Equal(t, ErrTooManyCursors, err)
+
+	// bound to the session that opened it
+	_, err = reg.get(2, entry.id)
+	assert.Equal(t, ErrCursorNotFound, err)
+
+	entry, err = reg.get(1, entry.id)
+	assert.Equal(t, nil, err)
+	reg.remove(entry)
+	assert.Equal(t, 1, c.closed)
+
+	_, err = reg.get(1, entry.id)
+	assert.Equal(t, ErrCursorNotFound, err)
+}
+
+func TestCursorRegistryPinned(t *testing.T) {
+	reg := newCursorRegistry(10, 1, time.Minute)
+	entry, err := reg.open(1, &fakeCursor{}, true)
+	assert.Equal(t, nil, err)
+	reg.release(entry)
+
+	_, err = reg.open(1, &fakeCursor{}, true)
+	assert.Equal(t, ErrTooManyCursors, err)
+	entry2, err := reg.open(1, &fakeCursor{}, false)
+	assert.Equal(t, nil, err)
+	reg.release(entry2)
+
+	// slot is freed once closed
+	entry, _ = reg.get(1, entry.id)
+	reg.remove(entry)
+	entry, err = reg.open(1, &fakeCursor{}, true)
+	assert.Equal(t, nil, err)
+	reg.release(entry)
+}
+
+func TestResultLimit(t *testing.T) {
+	l := &resultLimit{maxRows: 2, maxBytes: 10}
+	assert.Equal(t, nil, l.addRow([]string{"abc", "de"}))
+	assert.Equal(t, ErrResultTooLarge, l.addRow([]string{"abcdef"}))
+
+	l = &resultLimit{maxRows: 2}
+	assert.Equal(t, nil, l.add(1<<20))
+	assert.Equal(t, nil, l.add(1<<20))
+	assert.Equal(t, ErrResultTooLarge, l.add(1))
+}
+
+func TestEphemeralRegistry(t *testing.T) {
+	removed := make(chan string, 10)
+	reg := newEphemeralRegistry(2, time.Minute, func(path string,
+		czxid int64) error {
+		assert.Equal(t, int64(11), czxid)
+		removed <- path
+		return nil
+	})
+
+	s1 := &session{ctx: &rpc.Context{Rid: 1}, lastCall: time.Now().UnixNano()}
+	s2 := &session{ctx: &rpc.Context{Rid: 2}, lastCall: time.Now().UnixNano()}
+	assert.Equal(t, true, reg.reserve())
+	reg.add(s1, "/a", 10)
+	reg.release()
+
+	// a slot being created counts
+	assert.Equal(t, true, reg.reserve())
+	assert.Equal(t, false, reg.reserve())
+	reg.add(s2, "/b", 12)
+	reg.release()
+	assert.Equal(t, false, reg.reserve())
+
+	// deleted by client
+	reg.forget("/b")
+	assert.Equal(t, true, reg.reserve())
+	reg.release()
+	assert.Equal(t, 1, len(reg.owners))
+
+	// deleted by others and recreated by s2, no longer of s1
+	reg.add(s2, "/a", 11)
+	assert.Equal(t, 1, len(reg.owners))
+	assert.Equal(t, int64(2), reg.paths["/a"])
+
+	// still active
+	reg.expireIdle()
+	assert.Equal(t, 1, len(reg.paths))
+
+	s2.lastCall = time.Now().Add(-2 * time.Minute).UnixNano()
+	reg.expireIdle()
+	assert.Equal(t, "/a", <-removed)
+	assert.Equal(t, 0, len(reg.paths))
+	assert.Equal(t, 0, len(reg.owners))
+}
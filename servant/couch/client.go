@@ -1,37 +1,67 @@
 package couch
 
 import (
+	"github.com/couchbase/gomemcached/client"
 	"github.com/funkygao/couchbase"
+	"github.com/funkygao/fae/config"
 	log "github.com/funkygao/log4go"
 	"math/rand"
+	"strings"
 	"sync"
 	"time"
 )
 
 // Couchbase is designed to be a drop-in replacement for an existing memcached server, while
 // adding persistence, replication, failover and dynamic cluster reconfiguration.
-// TODO bucket.GetStats watchdog
 type Client struct {
-	mutex   sync.Mutex
-	pool    couchbase.Pool
-	buckets map[string]*couchbase.Bucket
+	conf     *config.ConfigCouchbase
+	baseUrl  string // the node we connected to, streaming conns go there too
+	poolName string
+
+	mutex    sync.Mutex
+	client   couchbase.Client
+	pool     couchbase.Pool
+	buckets  map[string]*couchbase.Bucket
+	failures map[string]*bucketFailure // buckets failed to open
+	opening  map[string]chan struct{}  // closed once the open attempt is done
+
+	statsLock sync.RWMutex
+	stats     map[string]map[string]map[string]string // bucket/node/stat
+
+	observersLock sync.Mutex
+	observers     map[string]chan *memcached.Client // key is bucket/addr
+
+	quit chan struct{}
+}
+
+// A bucket that failed to open is not retried till retryAt, so that a
+// missing bucket or a dead cluster won't hammer the REST API.
+type bucketFailure struct {
+	err     error
+	retries int
+	retryAt time.Time
 }
 
 // Till Couchbase 2.x releases, pool is a placeholder that doesn't have any special meaning
 // Also note that no decisions have been made about what Couchbase will do with pools
-func New(baseUrls []string, pool string) (this *Client, err error) {
+func New(cf *config.ConfigCouchbase, pool string) (this *Client, err error) {
 	var (
-		c couchbase.Client
-		p couchbase.Pool
-		e error
+		c       couchbase.Client
+		p       couchbase.Pool
+		e       error
+		nodeUrl string
 	)
 
+	// REST calls of the couchbase lib all go through this
+	couchbase.HTTPClient = newHttpClient(cf.ConnectTimeout, cf.IoTimeout)
+
+	baseUrls := cf.Servers
 	rand.Seed(time.Now().UTC().UnixNano())
 	for _, i := range rand.Perm(len(baseUrls)) { // client side load balance
 		// connect to couchbase cluster: any node in the cluster is ok
 		// internally: GET /pools
-		nodeUrl := baseUrls[i]
-		c, e = couchbase.Connect(nodeUrl) // TODO timeout
+		nodeUrl = baseUrls[i]
+		c, e = couchbase.Connect(nodeUrl)
 		if e == nil {
 			break
 		}
@@ -47,15 +77,29 @@ func New(baseUrls []string, pool string) (this *Client, err error) {
 
 	// internally: GET /pools/default, then GET /pools/default/buckets
 	// get the vBucketServerMap and nodes ip:port in cluster
-	// TODO connct to streamingUri, cluster updates are fetched from that conn
+	// later cluster updates of each bucket are fetched from its streamingUri
 	p, e = c.GetPool(pool)
 	if e != nil {
 		return nil, e
 	}
 
 	this = new(Client)
+	this.conf = cf
+	this.baseUrl = strings.TrimRight(nodeUrl, "/")
+	this.poolName = pool
+	this.client = c
 	this.pool = p
 	this.buckets = make(map[string]*couchbase.Bucket)
+	this.failures = make(map[string]*bucketFailure)
+	this.opening = make(map[string]chan struct{})
+	this.stats = make(map[string]map[string]map[string]string)
+	this.observers = make(map[string]chan *memcached.Client)
+	this.quit = make(chan struct{})
+
+	if cf.StatsInterval > 0 {
+		go this.runWatchdog()
+	}
+
 	return
 }
 
@@ -64,16 +108,124 @@ func New(baseUrls []string, pool string) (this *Client, err error) {
 // Couchbase Server cluster
 // Bucket can be treated as database in mysql
 // The limit of the number of buckets that can be configured within a cluster is 10
+//
+// REST calls to open a bucket are made without holding the mutex, and
+// concurrent callers of the same bucket wait for a single attempt.
 func (this *Client) GetBucket(bucket string) (*couchbase.Bucket, error) {
+	this.mutex.Lock()
+	for {
+		b, present := this.buckets[bucket]
+		if present {
+			this.mutex.Unlock()
+			return b, nil
+		}
+
+		if failure, failed := this.failures[bucket]; failed &&
+			time.Now().Before(failure.retryAt) {
+			// fail fast during backoff
+			this.mutex.Unlock()
+			return nil, failure.err
+		}
+
+		opening, present := this.opening[bucket]
+		if !present {
+			break
+		}
+
+		this.mutex.Unlock()
+		<-opening
+		this.mutex.Lock()
+	}
+
+	done := make(chan struct{})
+	this.opening[bucket] = done
+	_, failed := this.failures[bucket]
+	pool := this.pool
+	this.mutex.Unlock()
+
+	if failed {
+		// the bucket might be created after we fetched the pool
+		if p, err := this.client.GetPool(this.poolName); err == nil {
+			pool = p
+			this.mutex.Lock()
+			this.pool = p
+			this.mutex.Unlock()
+		}
+	}
+
+	b, err := pool.GetBucket(bucket)
+
 	this.mutex.Lock()
 	defer this.mutex.Unlock()
 
-	b, present := this.buckets[bucket]
-	if present {
-		return b, nil
+	delete(this.opening, bucket)
+	close(done)
+
+	if err != nil {
+		failure, failed := this.failures[bucket]
+		if !failed {
+			failure = &bucketFailure{}
+			this.failures[bucket] = failure
+		}
+
+		wait := backoff(failure.retries, this.conf.RetryBackoff,
+			this.conf.RetryMaxBackoff)
+		failure.err = err
+		failure.retries++
+		failure.retryAt = time.Now().Add(wait)
+
+		log.Warn("couchbase[%s] open fail, retry in %s: %s", bucket, wait, err)
+		return nil, err
 	}
 
-	b, e := this.pool.GetBucket(bucket)
+	delete(this.failures, bucket)
 	this.buckets[bucket] = b
-	return b, e
+	go this.followBucket(b)
+
+	log.Info("couchbase[%s] opened", bucket)
+	return b, nil
+}
+
+// Close stops streaming and watchdog goroutines and idle observe conns.
+// Opened buckets keep working till the client is garbage collected.
+func (this *Client) Close() {
+	close(this.quit)
+
+	this.observersLock.Lock()
+	defer this.observersLock.Unlock()
+	for key, idle := range this.observers {
+		delete(this.observers, key)
+	drain:
+		for {
+			select {
+			case mc := <-idle:
+				mc.Close()
+			default:
+				break drain
+			}
+		}
+	}
+}
+
+func (this *Client) closed() bool {
+	select {
+	case <-this.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff returns the wait before retry n(0 based), which doubles on
+// each retry and is capped by max.
+func backoff(n int, base, max time.Duration) time.Duration {
+	wait := base
+	for i := 0; i < n && wait < max; i++ {
+		wait *= 2
+	}
+
+	if wait > max {
+		wait = max
+	}
+	return wait
 }
@@ -0,0 +1,130 @@
+package couch
+
+import (
+	"fmt"
+	"github.com/funkygao/assert"
+	"github.com/funkygao/fae/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const standInBucket = `{"name":"default","bucketType":"membase","authType":"sasl",
+"uri":"/pools/default/buckets/default",
+"streamingUri":"/pools/default/bucketsStreaming/default",
+"nodes":[],
+"vBucketServerMap":{"hashAlgorithm":"CRC","numReplicas":0,
+"serverList":["127.0.0.1:11210"],"vBucketMap":[[0],[0]]}}`
+
+// a local stand-in of couchbase REST API that serves pool JSON
+func newStandIn(bucketReqs *int32) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pools", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"pools":[{"name":"default","uri":"/pools/default"}]}`)
+	})
+	mux.HandleFunc("/pools/default", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"nodes":[],"buckets":{"uri":"/pools/default/buckets"}}`)
+	})
+	mux.HandleFunc("/pools/default/buckets", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(bucketReqs, 1)
+		fmt.Fprint(w, "["+standInBucket+"]")
+	})
+	mux.HandleFunc("/pools/default/buckets/default", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, standInBucket)
+	})
+	mux.HandleFunc("/pools/default/bucketsStreaming/default", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, standInBucket+"\n\n\n\n")
+		w.(http.Flusher).Flush()
+		<-time.After(time.Second)
+	})
+	return httptest.NewServer(mux)
+}
+
+func newTestClient(t *testing.T, url string) *Client {
+	cf := &config.ConfigCouchbase{Servers: []string{url + "/"},
+		ConnectTimeout: time.Second, IoTimeout: time.Second,
+		RetryBackoff: time.Hour, RetryMaxBackoff: time.Hour}
+	c, err := New(cf, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestNewAgainstStandIn(t *testing.T) {
+	var bucketReqs int32
+	s := newStandIn(&bucketReqs)
+	defer s.Close()
+
+	c := newTestClient(t, s.URL)
+	defer c.Close()
+	assert.Equal(t, s.URL, c.baseUrl)
+
+	b, err := c.GetBucket("default")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "default", b.Name)
+	assert.Equal(t, []string{"127.0.0.1:11210"}, b.VBServerMap().ServerList)
+}
+
+func TestGetBucketBackoff(t *testing.T) {
+	var bucketReqs int32
+	s := newStandIn(&bucketReqs)
+	defer s.Close()
+
+	c := newTestClient(t, s.URL)
+	defer c.Close()
+	reqs := atomic.LoadInt32(&bucketReqs)
+
+	_, err := c.GetBucket("nonexist")
+	assert.NotEqual(t, nil, err)
+	_, present := c.buckets["nonexist"]
+	assert.Equal(t, false, present) // failure never cached as a bucket
+
+	// within backoff: fail fast without hitting the cluster
+	_, err = c.GetBucket("nonexist")
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, reqs, atomic.LoadInt32(&bucketReqs))
+	assert.Equal(t, 1, c.failures["nonexist"].retries)
+
+	// after backoff: the pool is fetched again
+	c.failures["nonexist"].retryAt = time.Now()
+	_, err = c.GetBucket("nonexist")
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, reqs+1, atomic.LoadInt32(&bucketReqs))
+	assert.Equal(t, 2, c.failures["nonexist"].retries)
+}
+
+func TestReadStreaming(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"rev":1}`+"\n\n\n\n")
+		w.(http.Flusher).Flush()
+		fmt.Fprint(w, "\n\n\n\n") // heartbeat
+		fmt.Fprint(w, `{"rev":`+"\n"+`2}`+"\n\n\n\n")
+		fmt.Fprint(w, `{"rev":3`) // broken
+	}))
+	defer s.Close()
+
+	res, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	docs := make([]string, 0)
+	err = readStreaming(res.Body, func(doc []byte) {
+		docs = append(docs, strings.Replace(string(doc), "\n", "", -1))
+	})
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, []string{`{"rev":1}`, `{"rev":2}`}, docs)
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, backoff(0, time.Second, time.Minute))
+	assert.Equal(t, 2*time.Second, backoff(1, time.Second, time.Minute))
+	assert.Equal(t, 32*time.Second, backoff(5, time.Second, time.Minute))
+	assert.Equal(t, time.Minute, backoff(6, time.Second, time.Minute))
+	assert.Equal(t, time.Minute, backoff(100, time.Second, time.Minute))
+}
@@ -0,0 +1,148 @@
+package couch
+
+import (
+	"github.com/couchbase/gomemcached/client"
+	"github.com/funkygao/couchbase"
+	log "github.com/funkygao/log4go"
+	"time"
+)
+
+// idle observe conns kept per node
+const maxIdleObservers = 4
+
+// Durability requirement of a write, zero value means none.
+type Durability struct {
+	PersistTo   int // number of nodes including master persisted to disk
+	ReplicateTo int // number of replicas received the mutation
+}
+
+func (this Durability) required() bool {
+	return this.PersistTo > 0 || this.ReplicateTo > 0
+}
+
+// waitDurable polls OBSERVE on master and replicas of the key's vbucket
+// until the mutation identified by cas meets d.
+//
+// If the key is mutated again by others meanwhile, cas never matches and
+// it ends with ErrDurabilityTimeout.
+func (this *Client) waitDurable(b *couchbase.Bucket, key string,
+	cas uint64, d Durability) error {
+	if !d.required() {
+		return nil
+	}
+
+	if d.ReplicateTo > b.Replicas || d.PersistTo > b.Replicas+1 {
+		return ErrInvalidDurability
+	}
+
+	var (
+		vb       = b.VBHash(key)
+		vbm      = b.VBServerMap()
+		nodes    = vbm.VBucketMap[vb] // master followed by replicas
+		deadline = time.Now().Add(this.conf.DurabilityTimeout)
+	)
+	for {
+		persisted, replicated := 0, 0
+		for i, idx := range nodes {
+			if idx < 0 {
+				// replica not assigned yet
+				continue
+			}
+
+			res, err := this.observe(b, vbm.ServerList[idx], uint16(vb), key)
+			if err != nil || res.Cas != cas {
+				continue
+			}
+
+			if res.Status == memcached.ObservedPersisted {
+				persisted++
+			}
+			if i > 0 {
+				replicated++
+			}
+		}
+
+		if persisted >= d.PersistTo && replicated >= d.ReplicateTo {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			log.Warn("couchbase[%s] %s durability %+v timeout: persisted^%d replicated^%d",
+				b.Name, key, d, persisted, replicated)
+			return ErrDurabilityTimeout
+		}
+
+		time.Sleep(this.conf.DurabilityPollInterval)
+	}
+}
+
+func (this *Client) observe(b *couchbase.Bucket, addr string, vb uint16,
+	key string) (result memcached.ObserveResult, err error) {
+	mc, err := this.getObserver(b, addr)
+	if err != nil {
+		return
+	}
+
+	result, err = mc.Observe(vb, key)
+	if err != nil {
+		mc.Close()
+		return
+	}
+
+	this.putObserver(b, addr, mc)
+	return
+}
+
+// observe conns are dialed by ourselves because conn pool of the bucket
+// is only for vbucket masters.
+func (this *Client) getObserver(b *couchbase.Bucket,
+	addr string) (*memcached.Client, error) {
+	this.observersLock.Lock()
+	idle, present := this.observers[b.Name+"/"+addr]
+	this.observersLock.Unlock()
+
+	if present {
+		select {
+		case mc := <-idle:
+			return mc, nil
+		default:
+		}
+	}
+
+	mc, err := memcached.Connect("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.Name != "default" {
+		if _, err = mc.Auth(b.Name, b.Password); err != nil {
+			mc.Close()
+			return nil, err
+		}
+	}
+
+	return mc, nil
+}
+
+func (this *Client) putObserver(b *couchbase.Bucket, addr string,
+	mc *memcached.Client) {
+	if this.closed() {
+		mc.Close()
+		return
+	}
+
+	key := b.Name + "/" + addr
+	this.observersLock.Lock()
+	idle, present := this.observers[key]
+	if !present {
+		idle = make(chan *memcached.Client, maxIdleObservers)
+		this.observers[key] = idle
+	}
+	this.observersLock.Unlock()
+
+	select {
+	case idle <- mc:
+	default:
+		mc.Close()
+	}
+}
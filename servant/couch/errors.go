@@ -0,0 +1,35 @@
+package couch
+
+import (
+	"errors"
+	"github.com/couchbase/gomemcached"
+)
+
+var (
+	ErrNotFound          = errors.New("couchbase: key not found")
+	ErrKeyExists         = errors.New("couchbase: key exists or cas mismatch")
+	ErrNotStored         = errors.New("couchbase: not stored")
+	ErrDurabilityTimeout = errors.New("couchbase: durability not met in time")
+	ErrInvalidDurability = errors.New("couchbase: durability exceeds replicas")
+	ErrInvalidCas        = errors.New("couchbase: cas must be positive")
+	ErrInvalidCounter    = errors.New("couchbase: counter initial must not be negative")
+)
+
+// mapError converts memcached status of a response into errors that
+// callers can tell apart.
+func mapError(err error) error {
+	if res, ok := err.(*gomemcached.MCResponse); ok {
+		switch res.Status {
+		case gomemcached.KEY_ENOENT:
+			return ErrNotFound
+
+		case gomemcached.KEY_EEXISTS:
+			return ErrKeyExists
+
+		case gomemcached.NOT_STORED:
+			return ErrNotStored
+		}
+	}
+
+	return err
+}
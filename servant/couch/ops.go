@@ -0,0 +1,171 @@
+package couch
+
+import (
+	"encoding/binary"
+	"github.com/couchbase/gomemcached"
+	"github.com/couchbase/gomemcached/client"
+	"sync"
+)
+
+// max outstanding writes of a single SetMulti
+const setMultiConcurrency = 16
+
+// Ops below talk memcached binary protocol directly on the vbucket
+// master, so that cas of each mutation is known and durability can be
+// observed.
+
+// Store does SET, ADD or REPLACE of raw val and returns cas of the
+// mutation. A non-zero cas makes it a compare and swap.
+func (this *Client) Store(bucket string, op gomemcached.CommandCode,
+	key string, val []byte, exp int, cas uint64,
+	d Durability) (newCas uint64, err error) {
+	b, err := this.GetBucket(bucket)
+	if err != nil {
+		return
+	}
+
+	err = b.Do(key, func(mc *memcached.Client, vb uint16) error {
+		req := &gomemcached.MCRequest{
+			Opcode:  op,
+			VBucket: vb,
+			Key:     []byte(key),
+			Cas:     cas,
+			Extras:  make([]byte, 8), // flags is always 0, same as SetRaw
+			Body:    val,
+		}
+		binary.BigEndian.PutUint32(req.Extras[4:], uint32(exp))
+
+		res, e := mc.Send(req)
+		if e != nil {
+			return e
+		}
+
+		newCas = res.Cas
+		return nil
+	})
+	if err != nil {
+		return 0, mapError(err)
+	}
+
+	err = this.waitDurable(b, key, newCas, d)
+	return
+}
+
+// Counter does INCREMENT or DECREMENT, the item is created with initial
+// if absent. Decrement never goes below 0.
+func (this *Client) Counter(bucket string, op gomemcached.CommandCode,
+	key string, delta uint64, initial uint64, exp int,
+	d Durability) (val uint64, err error) {
+	b, err := this.GetBucket(bucket)
+	if err != nil {
+		return
+	}
+
+	var cas uint64
+	err = b.Do(key, func(mc *memcached.Client, vb uint16) error {
+		req := &gomemcached.MCRequest{
+			Opcode:  op,
+			VBucket: vb,
+			Key:     []byte(key),
+			Extras:  make([]byte, 8+8+4),
+		}
+		binary.BigEndian.PutUint64(req.Extras[:8], delta)
+		binary.BigEndian.PutUint64(req.Extras[8:16], initial)
+		binary.BigEndian.PutUint32(req.Extras[16:], uint32(exp))
+
+		res, e := mc.Send(req)
+		if e != nil {
+			return e
+		}
+
+		if len(res.Body) == 8 {
+			val = binary.BigEndian.Uint64(res.Body)
+		}
+		cas = res.Cas
+		return nil
+	})
+	if err != nil {
+		return 0, mapError(err)
+	}
+
+	err = this.waitDurable(b, key, cas, d)
+	return
+}
+
+// Touch resets expiry of an existing item.
+func (this *Client) Touch(bucket string, key string, exp int) error {
+	b, err := this.GetBucket(bucket)
+	if err != nil {
+		return err
+	}
+
+	err = b.Do(key, func(mc *memcached.Client, vb uint16) error {
+		req := &gomemcached.MCRequest{
+			Opcode:  gomemcached.TOUCH,
+			VBucket: vb,
+			Key:     []byte(key),
+			Extras:  make([]byte, 4),
+		}
+		binary.BigEndian.PutUint32(req.Extras, uint32(exp))
+
+		_, e := mc.Send(req)
+		return e
+	})
+	return mapError(err)
+}
+
+// GetsCas returns raw val with its cas for a later Store with cas.
+func (this *Client) GetsCas(bucket string, key string) (val []byte,
+	cas uint64, err error) {
+	b, err := this.GetBucket(bucket)
+	if err != nil {
+		return
+	}
+
+	err = b.Do(key, func(mc *memcached.Client, vb uint16) error {
+		res, e := mc.Send(&gomemcached.MCRequest{
+			Opcode:  gomemcached.GET,
+			VBucket: vb,
+			Key:     []byte(key),
+		})
+		if e != nil {
+			return e
+		}
+
+		val, cas = res.Body, res.Cas
+		return nil
+	})
+	err = mapError(err)
+	return
+}
+
+// SetMulti stores kvs concurrently and returns errors of failed keys.
+func (this *Client) SetMulti(bucket string, kvs map[string][]byte,
+	exp int, d Durability) (errs map[string]error) {
+	var (
+		lock     sync.Mutex
+		wg       sync.WaitGroup
+		throttle = make(chan struct{}, setMultiConcurrency)
+	)
+	errs = make(map[string]error)
+	for key, val := range kvs {
+		wg.Add(1)
+		throttle <- struct{}{}
+		go func(key string, val []byte) {
+			defer func() {
+				<-throttle
+				wg.Done()
+			}()
+
+			if _, err := this.Store(bucket, gomemcached.SET, key, val,
+				exp, 0, d); err != nil {
+				lock.Lock()
+				errs[key] = err
+				lock.Unlock()
+			}
+		}(key, val)
+	}
+
+	wg.Wait()
+	return
+}
@@ -0,0 +1,154 @@
+package couch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/funkygao/couchbase"
+	log "github.com/funkygao/log4go"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+// A streaming response of couchbase REST API never ends, the server
+// pushes the whole config again whenever it changes, e,g. on rebalance
+// or failover. Each config doc is followed by 4 newlines.
+var streamingSeparator = []byte("\n\n\n\n")
+
+func newHttpClient(connectTimeout, ioTimeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   ioTimeout,
+		Transport: newHttpTransport(connectTimeout, ioTimeout),
+	}
+}
+
+// streaming conns are long lived, so only connect and response header
+// are bounded.
+func newHttpTransport(connectTimeout, ioTimeout time.Duration) *http.Transport {
+	return &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.DialTimeout(network, addr, connectTimeout)
+		},
+		ResponseHeaderTimeout: ioTimeout,
+	}
+}
+
+// readStreaming calls fn with each non empty config doc read from r till
+// r fails or ends.
+func readStreaming(r io.Reader, fn func(doc []byte)) error {
+	var (
+		br  = bufio.NewReader(r)
+		buf bytes.Buffer
+	)
+	for {
+		line, err := br.ReadBytes('\n')
+		buf.Write(line)
+		if bytes.HasSuffix(buf.Bytes(), streamingSeparator) {
+			if doc := bytes.TrimSpace(buf.Bytes()); len(doc) > 0 {
+				fn(doc)
+			}
+			buf.Reset()
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// followBucket keeps a streaming conn to the bucket's streamingUri and
+// refreshes the bucket whenever its vbucket map changes, till client
+// is closed.
+func (this *Client) followBucket(b *couchbase.Bucket) {
+	var (
+		url       = this.baseUrl + b.StreamingURI
+		transport = newHttpTransport(this.conf.ConnectTimeout,
+			this.conf.IoTimeout)
+		client  = &http.Client{Transport: transport}
+		retries int
+	)
+	for {
+		err := this.stream(client, url, b, func(doc []byte) {
+			retries = 0
+			this.applyBucketConfig(b, doc)
+		})
+		if this.closed() {
+			return
+		}
+
+		wait := backoff(retries, this.conf.RetryBackoff,
+			this.conf.RetryMaxBackoff)
+		retries++
+		log.Warn("couchbase[%s] streaming broken, retry in %s: %v",
+			b.Name, wait, err)
+
+		select {
+		case <-this.quit:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (this *Client) stream(client *http.Client, url string,
+	b *couchbase.Bucket, fn func(doc []byte)) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if b.Name != "default" {
+		req.SetBasicAuth(b.Name, b.Password)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("couchbase: streaming %s: %s", url, res.Status)
+	}
+
+	// the body read blocks forever, closing it is the only way out
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-this.quit:
+			res.Body.Close()
+		case <-done:
+		}
+	}()
+
+	log.Debug("couchbase[%s] streaming from %s", b.Name, url)
+	return readStreaming(res.Body, fn)
+}
+
+func (this *Client) applyBucketConfig(b *couchbase.Bucket, doc []byte) {
+	var cf struct {
+		VBSMJson couchbase.VBucketServerMap `json:"vBucketServerMap"`
+	}
+	if err := json.Unmarshal(doc, &cf); err != nil {
+		log.Error("couchbase[%s] streaming: %s", b.Name, err)
+		return
+	}
+
+	// the 1st doc after (re)connect is mostly what we already have
+	if reflect.DeepEqual(cf.VBSMJson, *b.VBServerMap()) {
+		return
+	}
+
+	// let the couchbase lib rebuild vbucket map and conn pools
+	if err := b.Refresh(); err != nil {
+		log.Error("couchbase[%s] refresh: %s", b.Name, err)
+		return
+	}
+
+	log.Info("couchbase[%s] vbucket map changed, servers: %+v", b.Name,
+		b.VBServerMap().ServerList)
+}
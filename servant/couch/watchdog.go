@@ -0,0 +1,85 @@
+package couch
+
+import (
+	log "github.com/funkygao/log4go"
+	"time"
+)
+
+// stats kept from the watchdog for each node
+var watchdogStats = []string{"curr_items", "mem_used", "ep_queue_size",
+	"cmd_get", "cmd_set", "get_misses", "curr_connections"}
+
+// runWatchdog polls stats of opened buckets and warns on nodes that fail
+// to report, which are mostly down or partitioned from us.
+func (this *Client) runWatchdog() {
+	ticker := time.NewTicker(this.conf.StatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.quit:
+			return
+
+		case <-ticker.C:
+			this.mutex.Lock()
+			buckets := make([]string, 0, len(this.buckets))
+			for name := range this.buckets {
+				buckets = append(buckets, name)
+			}
+			this.mutex.Unlock()
+
+			for _, name := range buckets {
+				this.checkBucket(name)
+			}
+		}
+	}
+}
+
+func (this *Client) checkBucket(name string) {
+	b, err := this.GetBucket(name)
+	if err != nil {
+		return
+	}
+
+	all := b.GetStats("")
+	stats := make(map[string]map[string]string, len(all))
+	for _, node := range b.VBServerMap().ServerList {
+		nodeStats, present := all[node]
+		if !present {
+			log.Warn("couchbase[%s] node %s reports no stats", name, node)
+			continue
+		}
+
+		stats[node] = make(map[string]string, len(watchdogStats))
+		for _, k := range watchdogStats {
+			stats[node][k] = nodeStats[k]
+		}
+	}
+
+	this.statsLock.Lock()
+	this.stats[name] = stats
+	this.statsLock.Unlock()
+}
+
+// StatsMap returns latest stats of opened buckets and buckets failed to open.
+func (this *Client) StatsMap() map[string]interface{} {
+	r := make(map[string]interface{})
+
+	this.statsLock.RLock()
+	for name, stats := range this.stats {
+		r[name] = stats
+	}
+	this.statsLock.RUnlock()
+
+	this.mutex.Lock()
+	for name, failure := range this.failures {
+		r[name] = map[string]interface{}{
+			"error":    failure.err.Error(),
+			"retries":  failure.retries,
+			"retry_at": failure.retryAt.String(),
+		}
+	}
+	this.mutex.Unlock()
+
+	return r
+}
@@ -0,0 +1,315 @@
+package servant
+
+import (
+	sql_ "database/sql"
+	"github.com/funkygao/fae/servant/mongo"
+	log "github.com/funkygao/log4go"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"sync"
+	"time"
+)
+
+// A server side cursor that holds a db conn until closed.
+type cursor interface {
+	Close() error
+}
+
+type cursorEntry struct {
+	sync.Mutex // serialize fetches of the same cursor
+
+	id       int64
+	rid      int64 // the session it is bound to
+	cursor   cursor
+	pinsConn bool // holds a conn of a capped db pool
+	lastUsed time.Time
+	closed   bool
+}
+
+type cursorRegistry struct {
+	sync.Mutex
+
+	lastId      int64
+	maxItems    int
+	maxPinned   int // cap of cursors that pin a conn
+	pinned      int
+	idleTimeout time.Duration
+	items       map[int64]*cursorEntry
+}
+
+func newCursorRegistry(maxItems, maxPinned int,
+	idleTimeout time.Duration) *cursorRegistry {
+	this := &cursorRegistry{
+		maxItems:    maxItems,
+		maxPinned:   maxPinned,
+		idleTimeout: idleTimeout,
+		items:       make(map[int64]*cursorEntry),
+	}
+	go this.runJanitor()
+	return this
+}
+
+// open registers a cursor and returns its locked entry, the caller
+// MUST release or remove it.
+// pinsConn cursors are capped separately, so that they never starve the
+// conn pool of the db.
+func (this *cursorRegistry) open(rid int64, c cursor,
+	pinsConn bool) (*cursorEntry, error) {
+	this.Lock()
+	defer this.Unlock()
+
+	if len(this.items) >= this.maxItems ||
+		(pinsConn && this.pinned >= this.maxPinned) {
+		return nil, ErrTooManyCursors
+	}
+
+	this.lastId++
+	entry := &cursorEntry{id: this.lastId, rid: rid, cursor: c,
+		pinsConn: pinsConn, lastUsed: time.Now()}
+	entry.Lock()
+	this.items[entry.id] = entry
+	if pinsConn {
+		this.pinned++
+	}
+	return entry, nil
+}
+
+// forget MUST be called with the lock held.
+// An entry in use can be expired meanwhile, so it is forgotten only once.
+func (this *cursorRegistry) forget(entry *cursorEntry) {
+	if _, present := this.items[entry.id]; !present {
+		return
+	}
+
+	delete(this.items, entry.id)
+	if entry.pinsConn {
+		this.pinned--
+	}
+}
+
+// get returns the locked entry of a cursor opened within the same
+// session, the caller MUST release or remove it.
+func (this *cursorRegistry) get(rid int64, id int64) (*cursorEntry, error) {
+	this.Lock()
+	entry, present := this.items[id]
+	if !present || entry.rid != rid {
+		this.Unlock()
+		return nil, ErrCursorNotFound
+	}
+	entry.lastUsed = time.Now()
+	this.Unlock()
+
+	entry.Lock()
+	if entry.closed {
+		// expired while we are waiting for the lock
+		entry.Unlock()
+		return nil, ErrCursorNotFound
+	}
+
+	return entry, nil
+}
+
+func (this *cursorRegistry) release(entry *cursorEntry) {
+	this.Lock()
+	entry.lastUsed = time.Now()
+	this.Unlock()
+
+	entry.Unlock()
+}
+
+// remove closes the cursor and forgets it.
+func (this *cursorRegistry) remove(entry *cursorEntry) {
+	this.Lock()
+	this.forget(entry)
+	this.Unlock()
+
+	entry.close()
+	entry.Unlock()
+}
+
+func (this *cursorRegistry) runJanitor() {
+	interval := this.idleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for _ = range ticker.C {
+		var expired []*cursorEntry
+		this.Lock()
+		for _, entry := range this.items {
+			if time.Since(entry.lastUsed) > this.idleTimeout {
+				this.forget(entry)
+				expired = append(expired, entry)
+			}
+		}
+		this.Unlock()
+
+		for _, entry := range expired {
+			log.Warn("cursor[%d] of rid^%d expired", entry.id, entry.rid)
+
+			// a fetch might be in progress
+			go func(entry *cursorEntry) {
+				entry.Lock()
+				entry.close()
+				entry.Unlock()
+			}(entry)
+		}
+	}
+}
+
+func (this *cursorRegistry) stats() map[string]interface{} {
+	this.Lock()
+	defer this.Unlock()
+
+	return map[string]interface{}{
+		"open":       len(this.items),
+		"max":        this.maxItems,
+		"pinned":     this.pinned,
+		"max_pinned": this.maxPinned,
+	}
+}
+
+func (this *cursorEntry) close() {
+	if this.closed {
+		return
+	}
+
+	this.closed = true
+	if err := this.cursor.Close(); err != nil {
+		log.Error("cursor[%d] close: %s", this.id, err)
+	}
+}
+
+type mongoCursor struct {
+	sess *mongo.Session
+	iter *mgo.Iter
+}
+
+// next fetches at most n docs, stops early once maxBytes reached.
+// more is false if the cursor is exhausted.
+func (this *mongoCursor) next(n int, maxBytes int) (docs [][]byte,
+	more bool, err error) {
+	docs = make([][]byte, 0, n)
+	bytes := 0
+	for len(docs) < n && (maxBytes <= 0 || bytes < maxBytes) {
+		var doc bson.M
+		if !this.iter.Next(&doc) {
+			return docs, false, this.iter.Err()
+		}
+
+		docs = append(docs, mongo.MarshalOut(doc))
+		bytes += len(docs[len(docs)-1])
+	}
+
+	return docs, true, nil
+}
+
+func (this *mongoCursor) Close() (err error) {
+	err = this.iter.Close()
+	this.sess.Recyle(&err)
+	return
+}
+
+type mysqlCursor struct {
+	rows     *sql_.Rows
+	cols     []string
+	scanArgs []interface{}
+	raw      []sql_.RawBytes
+}
+
+func newMysqlCursor(rows *sql_.Rows) (*mysqlCursor, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	this := &mysqlCursor{rows: rows, cols: cols}
+	this.raw = make([]sql_.RawBytes, len(cols))
+	this.scanArgs = make([]interface{}, len(cols))
+	for i, _ := range cols {
+		this.scanArgs[i] = &this.raw[i]
+	}
+
+	return this, nil
+}
+
+// next is the same as mongoCursor.next.
+func (this *mysqlCursor) next(n int, maxBytes int) (rows [][]string,
+	more bool, err error) {
+	rows = make([][]string, 0, n)
+	bytes := 0
+	for len(rows) < n && (maxBytes <= 0 || bytes < maxBytes) {
+		if !this.rows.Next() {
+			return rows, false, this.rows.Err()
+		}
+
+		if err = this.rows.Scan(this.scanArgs...); err != nil {
+			return
+		}
+
+		rowValues := make([]string, len(this.cols))
+		for i, raw := range this.raw {
+			if raw == nil {
+				rowValues[i] = "NULL"
+			} else {
+				rowValues[i] = string(raw)
+			}
+			bytes += len(raw)
+		}
+
+		rows = append(rows, rowValues)
+	}
+
+	return rows, true, nil
+}
+
+func (this *mysqlCursor) Close() error {
+	return this.rows.Close()
+}
+
+// resultLimit guards a single call against materializing a huge result.
+type resultLimit struct {
+	maxRows, maxBytes int
+	rows, bytes       int
+}
+
+func (this *FunServantImpl) newResultLimit() *resultLimit {
+	return &resultLimit{maxRows: this.conf.MaxResultRows,
+		maxBytes: this.conf.MaxResultBytes}
+}
+
+// add accounts a row of the result.
+func (this *resultLimit) add(bytes int) error {
+	this.rows++
+	this.bytes += bytes
+	if (this.maxRows > 0 && this.rows > this.maxRows) ||
+		(this.maxBytes > 0 && this.bytes > this.maxBytes) {
+		return ErrResultTooLarge
+	}
+
+	return nil
+}
+
+func (this *resultLimit) addRow(row []string) error {
+	bytes := 0
+	for _, col := range row {
+		bytes += len(col)
+	}
+	return this.add(bytes)
+}
+
+// cursorBatchSize normalizes batch size requested by client.
+func (this *FunServantImpl) cursorBatchSize(batchSize int32) int {
+	switch {
+	case batchSize <= 0:
+		return this.conf.CursorBatchSize
+
+	case int(batchSize) > this.conf.CursorMaxBatchSize:
+		return this.conf.CursorMaxBatchSize
+	}
+
+	return int(batchSize)
+}
@@ -0,0 +1,272 @@
+// Package election elects a leader among candidates of the same name,
+// e,g. cron jobs racing on every web box.
+//
+// Each candidacy is an ephemeral sequential zk node under root/name
+// whose data is the candidate id, the lowest node is the leader.
+// Candidates are short lived RPC clients, so faed holds the nodes for
+// them under a lease: a candidate stays leader only while it renews the
+// lease by campaigning again within ttl.
+package election
+
+import (
+	"errors"
+	"github.com/funkygao/fae/servant/zk"
+	log "github.com/funkygao/log4go"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const nodePrefix = "c_"
+
+var ErrInvalidName = errors.New("election: invalid name or candidate")
+
+// The subset of zk that election needs.
+type Store interface {
+	CreateAll(path string) error
+	Create(path string, data []byte, flags int32) (string, error)
+	Get(path string) ([]byte, int32, error)
+	Exists(path string) (bool, int32, error)
+	Children(path string) ([]string, error)
+	Delete(path string, version int32) error
+}
+
+type candidacy struct {
+	path    string // the ephemeral sequential node
+	expires time.Time
+}
+
+type Election struct {
+	store  Store
+	root   string
+	maxTtl time.Duration
+
+	mutex      sync.Mutex
+	candidates map[string]map[string]*candidacy // name:candidateId:candidacy
+}
+
+func New(store Store, root string, maxTtl time.Duration) *Election {
+	this := &Election{
+		store:      store,
+		root:       strings.TrimRight(root, "/"),
+		maxTtl:     maxTtl,
+		candidates: make(map[string]map[string]*candidacy),
+	}
+	go this.runJanitor()
+	return this
+}
+
+// Campaign joins the election or renews the lease of the candidate, and
+// tells whether it is the leader now.
+// ttl is capped by maxTtl.
+func (this *Election) Campaign(name, candidateId string,
+	ttl time.Duration) (leader bool, err error) {
+	if !validName(name) || candidateId == "" {
+		return false, ErrInvalidName
+	}
+	if ttl <= 0 || ttl > this.maxTtl {
+		ttl = this.maxTtl
+	}
+
+	// zk round trips are out of the lock, not to serialize campaigns
+	this.mutex.Lock()
+	c := this.candidates[name][candidateId]
+	this.mutex.Unlock()
+
+	present := c != nil
+	if present {
+		// ephemerals are gone with an expired zk session
+		if present, _, err = this.store.Exists(c.path); err != nil {
+			return
+		}
+	}
+
+	if !present {
+		if c, err = this.join(name, candidateId, c, ttl); err != nil {
+			return
+		}
+	}
+
+	this.mutex.Lock()
+	c.expires = time.Now().Add(ttl)
+	this.mutex.Unlock()
+
+	current, err := this.Leader(name)
+	return current == candidateId, err
+}
+
+// join creates the candidacy node, stale is the candidacy whose node is
+// gone if any.
+func (this *Election) join(name, candidateId string, stale *candidacy,
+	ttl time.Duration) (*candidacy, error) {
+	dir := this.dir(name)
+	if err := this.store.CreateAll(dir); err != nil {
+		return nil, err
+	}
+
+	path, err := this.store.Create(dir+"/"+nodePrefix, []byte(candidateId),
+		zk.FlagEphemeral|zk.FlagSequence)
+	if err != nil {
+		return nil, err
+	}
+
+	this.mutex.Lock()
+	if c := this.candidates[name][candidateId]; c != nil && c != stale {
+		this.mutex.Unlock()
+
+		// joined by a concurrent campaign meanwhile
+		if err = this.remove(path); err != nil {
+			log.Error("election[%s]: %s", path, err)
+		}
+		return c, nil
+	}
+
+	c := &candidacy{path: path, expires: time.Now().Add(ttl)}
+	if this.candidates[name] == nil {
+		this.candidates[name] = make(map[string]*candidacy)
+	}
+	this.candidates[name][candidateId] = c
+	this.mutex.Unlock()
+
+	log.Debug("election[%s] candidate %s joined: %s", name, candidateId, path)
+	return c, nil
+}
+
+// Resign gives up the candidacy, if it is the leader the next candidate
+// takes over.
+func (this *Election) Resign(name, candidateId string) (bool, error) {
+	this.mutex.Lock()
+	c, present := this.candidates[name][candidateId]
+	if present {
+		this.forget(name, candidateId)
+	}
+	this.mutex.Unlock()
+
+	if !present {
+		return false, nil
+	}
+
+	log.Debug("election[%s] candidate %s resigned", name, candidateId)
+	return true, this.remove(c.path)
+}
+
+// Leader returns the candidate id of the current leader, empty if none.
+func (this *Election) Leader(name string) (string, error) {
+	if !validName(name) {
+		return "", ErrInvalidName
+	}
+
+	dir := this.dir(name)
+	for {
+		children, err := this.store.Children(dir)
+		if err == zk.ErrNoNode || len(children) == 0 {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		// same prefix followed by zero padded sequence
+		sort.Strings(children)
+		data, _, err := this.store.Get(dir + "/" + children[0])
+		if err == zk.ErrNoNode {
+			// resigned meanwhile
+			continue
+		}
+
+		return string(data), err
+	}
+}
+
+// Leaders returns leaders of all elections, including those whose
+// candidates campaign through other faeds.
+func (this *Election) Leaders() (map[string]string, error) {
+	names, err := this.store.Children(this.root)
+	if err == zk.ErrNoNode {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	leaders := make(map[string]string, len(names))
+	for _, name := range names {
+		if leaders[name], err = this.Leader(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return leaders, nil
+}
+
+// Candidates returns lease expiry of local candidates.
+func (this *Election) Candidates() map[string]map[string]string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	r := make(map[string]map[string]string, len(this.candidates))
+	for name, candidates := range this.candidates {
+		r[name] = make(map[string]string, len(candidates))
+		for id, c := range candidates {
+			r[name][id] = c.expires.String()
+		}
+	}
+	return r
+}
+
+func (this *Election) runJanitor() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for _ = range ticker.C {
+		this.expireLeases()
+	}
+}
+
+func (this *Election) expireLeases() {
+	var expired []string
+	now := time.Now()
+	this.mutex.Lock()
+	for name, candidates := range this.candidates {
+		for id, c := range candidates {
+			if now.After(c.expires) {
+				log.Warn("election[%s] candidate %s lease expired", name, id)
+
+				this.forget(name, id)
+				expired = append(expired, c.path)
+			}
+		}
+	}
+	this.mutex.Unlock()
+
+	for _, path := range expired {
+		if err := this.remove(path); err != nil {
+			log.Error("election[%s]: %s", path, err)
+		}
+	}
+}
+
+// forget MUST be called with mutex held.
+func (this *Election) forget(name, candidateId string) {
+	delete(this.candidates[name], candidateId)
+	if len(this.candidates[name]) == 0 {
+		delete(this.candidates, name)
+	}
+}
+
+func (this *Election) remove(path string) error {
+	err := this.store.Delete(path, zk.NoVersion)
+	if err == zk.ErrNoNode {
+		err = nil
+	}
+	return err
+}
+
+func (this *Election) dir(name string) string {
+	return this.root + "/" + name
+}
+
+func validName(name string) bool {
+	return name != "" && !strings.Contains(name, "/")
+}
@@ -0,0 +1,81 @@
+package election
+
+import (
+	"github.com/funkygao/assert"
+	"github.com/funkygao/fae/servant/zk"
+	"github.com/funkygao/fae/servant/zk/zktest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCampaign(t *testing.T) {
+	store := zktest.NewStore()
+	e := New(store, "/fae/election/", time.Minute)
+
+	leader, err := e.Campaign("cron", "web1", time.Minute)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, leader)
+
+	leader, _ = e.Campaign("cron", "web2", time.Minute)
+	assert.Equal(t, false, leader)
+
+	// renew keeps the leadership
+	leader, _ = e.Campaign("cron", "web1", time.Minute)
+	assert.Equal(t, true, leader)
+	leaders, _ := e.Leaders()
+	assert.Equal(t, map[string]string{"cron": "web1"}, leaders)
+
+	resigned, err := e.Resign("cron", "web1")
+	assert.Equal(t, true, resigned)
+	assert.Equal(t, nil, err)
+	current, _ := e.Leader("cron")
+	assert.Equal(t, "web2", current)
+
+	resigned, _ = e.Resign("cron", "web1")
+	assert.Equal(t, false, resigned)
+
+	_, err = e.Campaign("a/b", "web1", time.Minute)
+	assert.Equal(t, ErrInvalidName, err)
+}
+
+func TestLeaseExpire(t *testing.T) {
+	store := zktest.NewStore()
+	e := New(store, "/fae/election", time.Minute)
+
+	e.Campaign("cron", "web1", time.Minute)
+	e.Campaign("cron", "web2", time.Minute)
+	e.candidates["cron"]["web1"].expires = time.Now().Add(-time.Second)
+	e.expireLeases()
+
+	current, _ := e.Leader("cron")
+	assert.Equal(t, "web2", current)
+	assert.Equal(t, 1, len(e.Candidates()["cron"]))
+
+	// node lost with zk session is recreated on renew
+	store.Delete(e.candidates["cron"]["web2"].path, zk.NoVersion)
+	leader, _ := e.Campaign("cron", "web2", time.Minute)
+	assert.Equal(t, true, leader)
+}
+
+func TestConcurrentCampaign(t *testing.T) {
+	store := zktest.NewStore()
+	e := New(store, "/fae/election", time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			leader, err := e.Campaign("cron", "web1", time.Minute)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, true, leader)
+		}()
+	}
+	wg.Wait()
+
+	// a single candidacy node survives
+	children, _ := store.Children("/fae/election/cron")
+	assert.Equal(t, 1, len(children))
+	assert.Equal(t, 1, len(e.Candidates()["cron"]))
+}
@@ -0,0 +1,167 @@
+package servant
+
+import (
+	log "github.com/funkygao/log4go"
+	"sync"
+	"time"
+)
+
+// Ephemeral zk nodes created on behalf of RPC sessions.
+//
+// zk ties ephemerals to the zk session of fae itself, which outlives
+// RPC sessions, so they are deleted once their session has made no call
+// for idleTimeout. A session evicted from the session cache never gets
+// new calls, so its ephemerals go the same way.
+//
+// Nodes are recorded with their czxid, so that a node recreated at the
+// same path by others is never deleted on expiry.
+type ephemeralOwner struct {
+	sess  *session
+	paths map[string]int64 // path:czxid
+}
+
+type ephemeralRegistry struct {
+	sync.Mutex
+
+	maxItems    int
+	idleTimeout time.Duration
+	owners      map[int64]*ephemeralOwner // key is rid
+	paths       map[string]int64          // path:rid
+	reserved    int                       // slots of nodes being created
+	remove      func(path string, czxid int64) error
+}
+
+func newEphemeralRegistry(maxItems int, idleTimeout time.Duration,
+	remove func(path string, czxid int64) error) *ephemeralRegistry {
+	this := &ephemeralRegistry{
+		maxItems:    maxItems,
+		idleTimeout: idleTimeout,
+		owners:      make(map[int64]*ephemeralOwner),
+		paths:       make(map[string]int64),
+		remove:      remove,
+	}
+	go this.runJanitor()
+	return this
+}
+
+// reserve takes a slot before creating an ephemeral node, so that
+// concurrent creates never exceed maxItems. The caller MUST release it
+// once the node is added or failed to create.
+func (this *ephemeralRegistry) reserve() bool {
+	this.Lock()
+	defer this.Unlock()
+
+	if len(this.paths)+this.reserved >= this.maxItems {
+		return false
+	}
+
+	this.reserved++
+	return true
+}
+
+func (this *ephemeralRegistry) release() {
+	this.Lock()
+	this.reserved--
+	this.Unlock()
+}
+
+func (this *ephemeralRegistry) add(sess *session, path string, czxid int64) {
+	this.Lock()
+	defer this.Unlock()
+
+	// the node was deleted by others and recreated by this session
+	this.unlink(path)
+
+	rid := sess.ctx.Rid
+	owner, present := this.owners[rid]
+	if !present || owner.sess != sess {
+		// a rid reused after its session was evicted is a new owner
+		if present {
+			this.expire(rid, owner)
+		}
+		owner = &ephemeralOwner{sess: sess, paths: make(map[string]int64)}
+		this.owners[rid] = owner
+	}
+
+	owner.paths[path] = czxid
+	this.paths[path] = rid
+}
+
+// forget is called when the node is deleted by client.
+func (this *ephemeralRegistry) forget(path string) {
+	this.Lock()
+	defer this.Unlock()
+
+	this.unlink(path)
+}
+
+// unlink drops the path from its owner. Caller MUST hold the lock.
+func (this *ephemeralRegistry) unlink(path string) {
+	rid, present := this.paths[path]
+	if !present {
+		return
+	}
+
+	delete(this.paths, path)
+	owner := this.owners[rid]
+	delete(owner.paths, path)
+	if len(owner.paths) == 0 {
+		delete(this.owners, rid)
+	}
+}
+
+// expire forgets nodes of the owner and deletes them in background.
+// Caller MUST hold the lock.
+func (this *ephemeralRegistry) expire(rid int64, owner *ephemeralOwner) {
+	delete(this.owners, rid)
+	paths := make(map[string]int64, len(owner.paths))
+	for path, czxid := range owner.paths {
+		delete(this.paths, path)
+		paths[path] = czxid
+	}
+
+	go func() {
+		for path, czxid := range paths {
+			if err := this.remove(path, czxid); err != nil {
+				log.Error("zk ephemeral[%s] of rid^%d: %s", path, rid, err)
+			}
+		}
+	}()
+}
+
+func (this *ephemeralRegistry) expireIdle() {
+	this.Lock()
+	defer this.Unlock()
+
+	for rid, owner := range this.owners {
+		if owner.sess.idle() > this.idleTimeout {
+			log.Debug("zk ephemerals of rid^%d expired: %d", rid,
+				len(owner.paths))
+			this.expire(rid, owner)
+		}
+	}
+}
+
+func (this *ephemeralRegistry) runJanitor() {
+	interval := this.idleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for _ = range ticker.C {
+		this.expireIdle()
+	}
+}
+
+func (this *ephemeralRegistry) stats() map[string]interface{} {
+	this.Lock()
+	defer this.Unlock()
+
+	return map[string]interface{}{
+		"sessions": len(this.owners),
+		"nodes":    len(this.paths),
+	}
+}
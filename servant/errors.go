@@ -10,4 +10,9 @@ var (
 	ErrServantNotStarted = errors.New("Svt: not started")
 	ErrMyMergeInvalidRow = errors.New("Svt: row not found")
 	ErrProxyNotFound     = errors.New("Svt: proxy not found")
+	ErrResultTooLarge    = errors.New("Svt: result too large, use cursor instead")
+	ErrCursorNotFound    = errors.New("Svt: cursor not found or expired")
+	ErrTooManyCursors    = errors.New("Svt: too many open cursors")
+	ErrCursorNotSelect   = errors.New("Svt: cursor requires SELECT")
+	ErrTooManyEphemerals = errors.New("Svt: too many zk ephemeral nodes")
 )
@@ -0,0 +1,98 @@
+package gossip
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// broadcast is an update piggybacked on outgoing messages for a limited
+// times.
+type broadcast struct {
+	key       string // a newer broadcast of the same key replaces older
+	size      int    // encoded
+	member    *memberUpdate
+	state     *stateUpdate
+	event     *Event
+	transmits int
+}
+
+func newBroadcast(key string, update interface{}) *broadcast {
+	this := &broadcast{key: key}
+	switch u := update.(type) {
+	case *memberUpdate:
+		this.member = u
+	case *stateUpdate:
+		this.state = u
+	case *Event:
+		this.event = u
+	}
+
+	body, _ := json.Marshal(update)
+	this.size = len(body) + 1 // the separating comma
+	return this
+}
+
+// broadcastQueue is not goroutine safe, it is guarded by Gossip mutex.
+type broadcastQueue struct {
+	items []*broadcast
+}
+
+func (this *broadcastQueue) queue(b *broadcast) {
+	for i, item := range this.items {
+		if item.key == b.key {
+			this.items[i] = b
+			return
+		}
+	}
+
+	this.items = append(this.items, b)
+}
+
+// pick fills msg with broadcasts least transmitted first within budget
+// bytes, those transmitted limit times are dropped.
+func (this *broadcastQueue) pick(msg *message, budget int, limit int) {
+	sort.Sort(byTransmits(this.items))
+
+	kept := this.items[:0]
+	for _, b := range this.items {
+		if b.size <= budget {
+			budget -= b.size
+			b.transmits++
+			switch {
+			case b.member != nil:
+				msg.Members = append(msg.Members, b.member)
+			case b.state != nil:
+				msg.States = append(msg.States, b.state)
+			case b.event != nil:
+				msg.Events = append(msg.Events, b.event)
+			}
+		}
+
+		if b.transmits < limit {
+			kept = append(kept, b)
+		}
+	}
+
+	for i := len(kept); i < len(this.items); i++ {
+		this.items[i] = nil
+	}
+	this.items = kept
+}
+
+func (this *broadcastQueue) len() int {
+	return len(this.items)
+}
+
+type byTransmits []*broadcast
+
+func (this byTransmits) Len() int {
+	return len(this)
+}
+
+func (this byTransmits) Less(i, j int) bool {
+	return this[i].transmits < this[j].transmits
+}
+
+func (this byTransmits) Swap(i, j int) {
+	this[i], this[j] = this[j], this[i]
+}
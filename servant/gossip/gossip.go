@@ -0,0 +1,534 @@
+// Package gossip is SWIM style membership and state dissemination among
+// fae nodes.
+//
+// Each node probes a random peer per probe interval, asking others to
+// probe it indirectly if no ack arrives in time. A node failing both is
+// suspected and declared dead after suspect timeout unless it refutes
+// with a higher incarnation. Membership changes, replicated states and
+// events piggyback on probes and periodic gossip, and a periodic push
+// pull sync repairs whatever is lost.
+package gossip
+
+import (
+	"encoding/json"
+	"github.com/funkygao/fae/config"
+	log "github.com/funkygao/log4go"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Gossip struct {
+	cf   *config.ConfigGossip
+	name string // unique in the cluster, fae rpc addr
+	conn *net.UDPConn
+	quit chan struct{}
+
+	mutex      sync.Mutex
+	leaving    bool
+	members    map[string]*member // key is name, self inclusive
+	probeList  []string
+	broadcasts *broadcastQueue
+	seq        uint32
+	acks       map[uint32]func() // key is seq
+	watchers   []chan bool
+
+	version  int64                              // of local states and events
+	states   map[string]map[string]*stateUpdate // node:key:state
+	seen     map[string]time.Time               // events seen
+	handlers map[string][]func(Event)           // key is topic
+	events   chan Event
+}
+
+func New(cf *config.ConfigGossip, name string) (*Gossip, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", cf.ListenAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := cf.AdvertiseAddr
+	if addr == "" {
+		addr = conn.LocalAddr().String()
+	}
+
+	this := &Gossip{
+		cf:         cf,
+		name:       name,
+		conn:       conn,
+		quit:       make(chan struct{}),
+		members:    make(map[string]*member),
+		broadcasts: &broadcastQueue{},
+		acks:       make(map[uint32]func()),
+		states:     make(map[string]map[string]*stateUpdate),
+		seen:       make(map[string]time.Time),
+		handlers:   make(map[string][]func(Event)),
+		events:     make(chan Event, 1000),
+	}
+	this.members[name] = &member{
+		memberUpdate: memberUpdate{Name: name, Addr: addr, State: stateAlive},
+		since:        time.Now(),
+	}
+	this.states[name] = make(map[string]*stateUpdate)
+
+	go this.receive()
+	go this.runProbe()
+	go this.runGossip()
+	go this.runSync()
+	go this.dispatch()
+
+	log.Info("gossip node[%s] at %s", name, addr)
+	return this, nil
+}
+
+// Join pushes our view to the seeds and pulls theirs. It is retried
+// while we know no other live node.
+func (this *Gossip) Join(seeds []string) error {
+	var lastErr error
+	self := this.Addr()
+	for _, seed := range seeds {
+		if seed == self {
+			continue
+		}
+
+		if err := this.sendAll(seed, this.fullView(msgSync)); err != nil {
+			log.Error("gossip join %s: %s", seed, err)
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
+
+// Leave tells others we are gone instead of letting them detect it, and
+// shuts down.
+func (this *Gossip) Leave() {
+	this.mutex.Lock()
+	this.leaving = true
+	self := this.members[this.name]
+	dead := self.memberUpdate
+	dead.State = stateDead
+	this.deadNode(&dead)
+	this.mutex.Unlock()
+
+	// a few gossip rounds to spread the news
+	time.Sleep(this.cf.GossipInterval * 3)
+	this.Close()
+}
+
+func (this *Gossip) Close() {
+	close(this.quit)
+	this.conn.Close()
+}
+
+func (this *Gossip) Name() string {
+	return this.name
+}
+
+// Addr is the advertised gossip addr.
+func (this *Gossip) Addr() string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.members[this.name].Addr
+}
+
+func (this *Gossip) Members() []Member {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	r := make([]Member, 0, len(this.members))
+	for _, m := range this.members {
+		r = append(r, Member{Name: m.Name, Addr: m.Addr,
+			State: m.State.String(), Incarnation: m.Incarnation,
+			Since: m.since})
+	}
+	sort.Sort(byName(r))
+	return r
+}
+
+// LiveNodes returns names of nodes not dead, suspected ones inclusive.
+func (this *Gossip) LiveNodes() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	r := make([]string, 0, len(this.members))
+	for name, m := range this.members {
+		if m.State != stateDead {
+			r = append(r, name)
+		}
+	}
+	sort.Strings(r)
+	return r
+}
+
+// Watch sends live nodes on each membership change till closed, so that
+// gossip works as peers discovery of proxy.
+func (this *Gossip) Watch(peersChan chan<- []string) {
+	changed := make(chan bool, 1)
+	changed <- true
+	this.mutex.Lock()
+	this.watchers = append(this.watchers, changed)
+	this.mutex.Unlock()
+
+	for {
+		select {
+		case <-this.quit:
+			return
+
+		case <-changed:
+			peersChan <- this.LiveNodes()
+		}
+	}
+}
+
+// notifyWatchers MUST be called with mutex held.
+func (this *Gossip) notifyWatchers() {
+	for _, changed := range this.watchers {
+		select {
+		case changed <- true:
+		default:
+			// pending already
+		}
+	}
+}
+
+func (this *Gossip) Stats() map[string]interface{} {
+	members := this.Members()
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	states := make(map[string]map[string]string)
+	for node, kv := range this.states {
+		states[node] = make(map[string]string)
+		for key, s := range kv {
+			states[node][key] = s.Value
+		}
+	}
+
+	return map[string]interface{}{
+		"members":     members,
+		"states":      states,
+		"broadcasts":  this.broadcasts.len(),
+		"incarnation": this.members[this.name].Incarnation,
+	}
+}
+
+func (this *Gossip) receive() {
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := this.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-this.quit:
+				return
+			default:
+			}
+
+			log.Error("gossip: %s", err)
+			continue
+		}
+
+		msg := &message{}
+		if err = json.Unmarshal(buf[:n], msg); err != nil {
+			log.Error("gossip from %s: %s", from, err)
+			continue
+		}
+
+		this.handle(msg, from.String())
+	}
+}
+
+func (this *Gossip) handle(msg *message, from string) {
+	this.mutex.Lock()
+	for _, u := range msg.Members {
+		this.applyMember(u)
+	}
+	for _, s := range msg.States {
+		this.applyState(s)
+	}
+	for _, e := range msg.Events {
+		this.applyEvent(e)
+	}
+	this.mutex.Unlock()
+
+	switch msg.Type {
+	case msgPing:
+		if msg.Target == this.name {
+			this.send(from, &message{Type: msgAck, Seq: msg.Seq})
+		}
+
+	case msgPingReq:
+		requester, seq := from, msg.Seq
+		relay := this.expectAck(func() {
+			this.send(requester, &message{Type: msgAck, Seq: seq})
+		})
+		time.AfterFunc(this.cf.ProbeTimeout, func() {
+			this.forgetAck(relay)
+		})
+		this.send(msg.TargetAddr, &message{Type: msgPing, Seq: relay,
+			Target: msg.Target})
+
+	case msgAck:
+		this.mutex.Lock()
+		handler, present := this.acks[msg.Seq]
+		delete(this.acks, msg.Seq)
+		this.mutex.Unlock()
+
+		if present {
+			handler()
+		}
+
+	case msgSync:
+		this.sendAll(from, this.fullView(msgSyncAck))
+	}
+}
+
+func (this *Gossip) expectAck(handler func()) uint32 {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.seq++
+	this.acks[this.seq] = handler
+	return this.seq
+}
+
+func (this *Gossip) forgetAck(seq uint32) {
+	this.mutex.Lock()
+	delete(this.acks, seq)
+	this.mutex.Unlock()
+}
+
+func (this *Gossip) runProbe() {
+	ticker := time.NewTicker(this.cf.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.quit:
+			return
+
+		case <-ticker.C:
+			this.probe()
+
+			this.mutex.Lock()
+			this.reapDead()
+			this.forgetEvents()
+			this.mutex.Unlock()
+		}
+	}
+}
+
+// probe checks the next node directly, then indirectly, suspects it if
+// neither works.
+func (this *Gossip) probe() {
+	target, present := this.nextProbeTarget()
+	if !present {
+		return
+	}
+
+	acked := make(chan bool, 1)
+	seq := this.expectAck(func() {
+		acked <- true
+	})
+	defer this.forgetAck(seq)
+
+	this.send(target.Addr, &message{Type: msgPing, Seq: seq,
+		Target: target.Name})
+	select {
+	case <-acked:
+		return
+	case <-time.After(this.cf.ProbeTimeout):
+	}
+
+	for _, m := range this.randomMembers(this.cf.IndirectChecks, target.Name) {
+		this.send(m.Addr, &message{Type: msgPingReq, Seq: seq,
+			Target: target.Name, TargetAddr: target.Addr})
+	}
+
+	wait := this.cf.ProbeInterval - this.cf.ProbeTimeout
+	if wait < this.cf.ProbeTimeout {
+		wait = this.cf.ProbeTimeout
+	}
+	select {
+	case <-acked:
+		return
+	case <-time.After(wait):
+	}
+
+	log.Debug("gossip node[%s] probe failed", target.Name)
+
+	this.mutex.Lock()
+	this.suspectNode(&memberUpdate{Name: target.Name, Addr: target.Addr,
+		Incarnation: target.Incarnation, State: stateSuspect})
+	this.mutex.Unlock()
+}
+
+// nextProbeTarget walks nodes not dead in a shuffled round robin.
+func (this *Gossip) nextProbeTarget() (memberUpdate, bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for retry := 0; retry < 2; retry++ {
+		for len(this.probeList) > 0 {
+			name := this.probeList[0]
+			this.probeList = this.probeList[1:]
+			if m, present := this.members[name]; present &&
+				m.State != stateDead {
+				return m.memberUpdate, true
+			}
+		}
+
+		for name, m := range this.members {
+			if name != this.name && m.State != stateDead {
+				this.probeList = append(this.probeList, name)
+			}
+		}
+		for i := range this.probeList {
+			j := rand.Intn(i + 1)
+			this.probeList[i], this.probeList[j] = this.probeList[j], this.probeList[i]
+		}
+	}
+
+	return memberUpdate{}, false
+}
+
+// randomMembers picks at most n nodes not dead, self and the excluded
+// not inclusive.
+func (this *Gossip) randomMembers(n int, exclude string) []memberUpdate {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	candidates := make([]memberUpdate, 0, len(this.members))
+	for name, m := range this.members {
+		if name != this.name && name != exclude && m.State != stateDead {
+			candidates = append(candidates, m.memberUpdate)
+		}
+	}
+
+	for i := range candidates {
+		j := rand.Intn(i + 1)
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	}
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates
+}
+
+func (this *Gossip) runGossip() {
+	ticker := time.NewTicker(this.cf.GossipInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.quit:
+			return
+
+		case <-ticker.C:
+			this.mutex.Lock()
+			pending := this.broadcasts.len()
+			this.mutex.Unlock()
+			if pending == 0 {
+				continue
+			}
+
+			for _, m := range this.randomMembers(this.cf.GossipNodes, "") {
+				this.send(m.Addr, &message{Type: msgGossip})
+			}
+		}
+	}
+}
+
+// runSync does push pull with a random node, or the seeds if we are
+// alone.
+func (this *Gossip) runSync() {
+	ticker := time.NewTicker(this.cf.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.quit:
+			return
+
+		case <-ticker.C:
+			peers := this.randomMembers(1, "")
+			if len(peers) == 0 {
+				this.Join(this.cf.Seeds)
+				continue
+			}
+
+			this.sendAll(peers[0].Addr, this.fullView(msgSync))
+		}
+	}
+}
+
+// fullView splits the full view into messages within MaxPacketSize. Only
+// the 1st one is of msgType, the rest are sync-acks which ask for no
+// reply. An update larger than MaxPacketSize goes alone.
+func (this *Gossip) fullView(msgType string) []*message {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	// `,"Members":[]` and `,"States":[]`
+	const fieldsSize = 25
+	empty, _ := json.Marshal(&message{Type: msgSyncAck, From: this.name})
+	base := len(empty) + fieldsSize
+
+	var (
+		msgs = []*message{&message{Type: msgType}}
+		size = base
+	)
+	fit := func(update interface{}) *message {
+		body, _ := json.Marshal(update)
+		n := len(body) + 1 // comma
+		msg := msgs[len(msgs)-1]
+		if size+n > this.cf.MaxPacketSize && size > base {
+			msg = &message{Type: msgSyncAck}
+			msgs = append(msgs, msg)
+			size = base
+		}
+		size += n
+		return msg
+	}
+
+	for _, m := range this.members {
+		u := m.memberUpdate
+		msg := fit(&u)
+		msg.Members = append(msg.Members, &u)
+	}
+	for _, states := range this.states {
+		for _, s := range states {
+			msg := fit(s)
+			msg.States = append(msg.States, s)
+		}
+	}
+	return msgs
+}
+
+// retransmitLimit MUST be called with mutex held.
+func (this *Gossip) retransmitLimit() int {
+	n := float64(len(this.members) + 1)
+	return this.cf.RetransmitMult * int(math.Ceil(math.Log10(n)))
+}
+
+type byName []Member
+
+func (this byName) Len() int {
+	return len(this)
+}
+
+func (this byName) Less(i, j int) bool {
+	return this[i].Name < this[j].Name
+}
+
+func (this byName) Swap(i, j int) {
+	this[i], this[j] = this[j], this[i]
+}
@@ -0,0 +1,256 @@
+package gossip
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/funkygao/assert"
+	"github.com/funkygao/fae/config"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testConfig() *config.ConfigGossip {
+	return &config.ConfigGossip{
+		ListenAddr:     "127.0.0.1:0",
+		ProbeInterval:  time.Millisecond * 50,
+		ProbeTimeout:   time.Millisecond * 20,
+		IndirectChecks: 2,
+		SuspectTimeout: time.Millisecond * 300,
+		DeadReclaim:    time.Minute,
+		GossipInterval: time.Millisecond * 20,
+		GossipNodes:    3,
+		RetransmitMult: 4,
+		SyncInterval:   time.Millisecond * 500,
+		MaxPacketSize:  1400,
+	}
+}
+
+// startCluster starts n nodes on localhost, all joining the 1st one.
+func startCluster(t *testing.T, n int) []*Gossip {
+	nodes := make([]*Gossip, n)
+	for i := 0; i < n; i++ {
+		node, err := New(testConfig(), fmt.Sprintf("127.0.0.1:%d", 9001+i))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i > 0 {
+			node.Join([]string{nodes[0].Addr()})
+		}
+		nodes[i] = node
+	}
+
+	waitFor(t, "convergence", func() bool {
+		for _, node := range nodes {
+			if len(node.LiveNodes()) != n {
+				return false
+			}
+		}
+		return true
+	})
+	return nodes
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	t.Fatalf("timeout waiting for %s", what)
+}
+
+func TestBroadcastQueue(t *testing.T) {
+	q := &broadcastQueue{}
+	q.queue(newBroadcast("m/a", &memberUpdate{Name: "a", Incarnation: 1}))
+	q.queue(newBroadcast("m/b", &memberUpdate{Name: "b"}))
+	q.queue(newBroadcast("m/a", &memberUpdate{Name: "a", Incarnation: 2}))
+	assert.Equal(t, 2, q.len())
+
+	msg := &message{}
+	q.pick(msg, 1400, 2)
+	assert.Equal(t, 2, len(msg.Members))
+	for _, u := range msg.Members {
+		if u.Name == "a" {
+			assert.Equal(t, uint32(2), u.Incarnation)
+		}
+	}
+
+	// budget for a single update only
+	msg = &message{}
+	q.pick(msg, q.items[0].size, 2)
+	assert.Equal(t, 1, len(msg.Members))
+	assert.Equal(t, 1, q.len())
+
+	msg = &message{}
+	q.pick(msg, 1400, 2)
+	assert.Equal(t, 1, len(msg.Members))
+	assert.Equal(t, 0, q.len())
+}
+
+func TestFullViewChunked(t *testing.T) {
+	cf := testConfig()
+	cf.MaxPacketSize = 512
+	node, err := New(cf, "127.0.0.1:9098")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+
+	for i := 0; i < 50; i++ {
+		node.SetState(fmt.Sprintf("key%d", i), "some value of the key")
+	}
+
+	msgs := node.fullView(msgSync)
+	assert.Equal(t, true, len(msgs) > 1)
+	assert.Equal(t, msgSync, msgs[0].Type)
+	states := 0
+	for i, msg := range msgs {
+		if i > 0 {
+			assert.Equal(t, msgSyncAck, msg.Type)
+		}
+
+		msg.From = node.Name()
+		body, _ := json.Marshal(msg)
+		assert.Equal(t, true, len(body) <= cf.MaxPacketSize)
+		states += len(msg.States)
+	}
+	assert.Equal(t, 50, states)
+}
+
+func TestGossipFailureDetection(t *testing.T) {
+	nodes := startCluster(t, 4)
+	defer func() {
+		for _, node := range nodes[:3] {
+			node.Close()
+		}
+	}()
+
+	peersChan := make(chan []string, 10)
+	go nodes[0].Watch(peersChan)
+	assert.Equal(t, 4, len(<-peersChan))
+
+	// crash without leaving
+	nodes[3].Close()
+	t0 := time.Now()
+	waitFor(t, "dead node detected", func() bool {
+		for _, node := range nodes[:3] {
+			if len(node.LiveNodes()) != 3 {
+				return false
+			}
+		}
+		return true
+	})
+	t.Logf("dead node detected in %s", time.Since(t0))
+
+	var peers []string
+	for len(peers) != 3 {
+		peers = <-peersChan
+	}
+	for _, peer := range peers {
+		assert.NotEqual(t, nodes[3].Name(), peer)
+	}
+}
+
+func TestGossipLeave(t *testing.T) {
+	nodes := startCluster(t, 3)
+	defer nodes[0].Close()
+	defer nodes[1].Close()
+
+	nodes[2].Leave()
+	waitFor(t, "left node", func() bool {
+		return len(nodes[0].LiveNodes()) == 2 &&
+			len(nodes[1].LiveNodes()) == 2
+	})
+}
+
+func TestGossipRefute(t *testing.T) {
+	nodes := startCluster(t, 3)
+	defer func() {
+		for _, node := range nodes {
+			node.Close()
+		}
+	}()
+
+	name := nodes[1].Name()
+	nodes[0].mutex.Lock()
+	m := nodes[0].members[name].memberUpdate
+	m.State = stateSuspect
+	nodes[0].suspectNode(&m)
+	nodes[0].mutex.Unlock()
+
+	waitFor(t, "refuted", func() bool {
+		for _, member := range nodes[0].Members() {
+			if member.Name == name {
+				return member.State == "alive" &&
+					member.Incarnation > m.Incarnation
+			}
+		}
+		return false
+	})
+	assert.Equal(t, 3, len(nodes[2].LiveNodes()))
+}
+
+func TestGossipStateAndEvent(t *testing.T) {
+	nodes := startCluster(t, 4)
+	defer func() {
+		for _, node := range nodes {
+			node.Close()
+		}
+	}()
+
+	nodes[1].SetState("load", "10")
+	nodes[1].SetState("load", "20")
+	nodes[2].SetState("load", "5")
+	waitFor(t, "states", func() bool {
+		for _, node := range nodes {
+			if v, _ := node.State(nodes[1].Name(), "load"); v != "20" {
+				return false
+			}
+			if len(node.States("load")) != 2 {
+				return false
+			}
+		}
+		return true
+	})
+
+	var received int32
+	for _, node := range nodes {
+		node.Subscribe("lc.invalidate", func(e Event) {
+			assert.Equal(t, "user:1", e.Payload)
+			atomic.AddInt32(&received, 1)
+		})
+	}
+	nodes[0].Publish("lc.invalidate", "user:1")
+
+	waitFor(t, "events", func() bool {
+		return atomic.LoadInt32(&received) == 3
+	})
+	time.Sleep(time.Millisecond * 200)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&received)) // at most once
+}
+
+func TestGossipLateJoinerSync(t *testing.T) {
+	nodes := startCluster(t, 2)
+	defer nodes[0].Close()
+	defer nodes[1].Close()
+
+	nodes[0].SetState("breaker.mysql", `{"db1":false}`)
+	time.Sleep(time.Millisecond * 300) // broadcasts exhausted
+
+	late, err := New(testConfig(), "127.0.0.1:9099")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer late.Close()
+
+	late.Join([]string{nodes[1].Addr()})
+	waitFor(t, "late joiner", func() bool {
+		v, _ := late.State(nodes[0].Name(), "breaker.mysql")
+		return v == `{"db1":false}` && len(late.LiveNodes()) == 3
+	})
+}
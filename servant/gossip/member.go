@@ -0,0 +1,176 @@
+package gossip
+
+import (
+	log "github.com/funkygao/log4go"
+	"time"
+)
+
+type memberState int
+
+const (
+	stateAlive memberState = iota
+	stateSuspect
+	stateDead
+)
+
+func (this memberState) String() string {
+	switch this {
+	case stateAlive:
+		return "alive"
+	case stateSuspect:
+		return "suspect"
+	default:
+		return "dead"
+	}
+}
+
+type member struct {
+	memberUpdate
+	since time.Time // of current state
+}
+
+// Member is a node of the cluster seen by this node.
+type Member struct {
+	Name        string
+	Addr        string
+	State       string
+	Incarnation uint32
+	Since       time.Time
+}
+
+// Rules of SWIM: an update of a node overrides what we know only if its
+// incarnation is higher, or equal but with a worse state. Only the node
+// itself bumps its incarnation, to refute being suspected.
+//
+// All of the following MUST be called with mutex held.
+
+func (this *Gossip) applyMember(u *memberUpdate) {
+	switch u.State {
+	case stateAlive:
+		this.aliveNode(u)
+	case stateSuspect:
+		this.suspectNode(u)
+	default:
+		this.deadNode(u)
+	}
+}
+
+func (this *Gossip) aliveNode(u *memberUpdate) {
+	if u.Name == this.name {
+		self := this.members[this.name]
+		if u.Incarnation > self.Incarnation || u.Addr != self.Addr {
+			// what others know of our previous life
+			this.refute(u.Incarnation)
+		}
+		return
+	}
+
+	m, present := this.members[u.Name]
+	if !present {
+		log.Info("gossip node[%s] joined at %s", u.Name, u.Addr)
+
+		this.members[u.Name] = &member{memberUpdate: *u, since: time.Now()}
+		this.queueMember(u)
+		this.notifyWatchers()
+		return
+	}
+
+	if u.Incarnation <= m.Incarnation {
+		return
+	}
+
+	if m.State != stateAlive {
+		log.Info("gossip node[%s] alive again", u.Name)
+	}
+
+	wasDead := m.State == stateDead
+	m.memberUpdate = *u
+	m.since = time.Now()
+	this.queueMember(u)
+	if wasDead {
+		this.notifyWatchers()
+	}
+}
+
+func (this *Gossip) suspectNode(u *memberUpdate) {
+	m, present := this.members[u.Name]
+	if !present || u.Incarnation < m.Incarnation ||
+		(u.Incarnation == m.Incarnation && m.State != stateAlive) {
+		return
+	}
+
+	if u.Name == this.name {
+		this.refute(u.Incarnation)
+		return
+	}
+
+	log.Warn("gossip node[%s] suspected", u.Name)
+
+	m.Incarnation = u.Incarnation
+	m.State = stateSuspect
+	m.since = time.Now()
+	this.queueMember(&m.memberUpdate)
+
+	incarnation := u.Incarnation
+	time.AfterFunc(this.cf.SuspectTimeout, func() {
+		this.mutex.Lock()
+		defer this.mutex.Unlock()
+
+		if this.members[m.Name] == m && m.State == stateSuspect &&
+			m.Incarnation == incarnation {
+			dead := m.memberUpdate
+			dead.State = stateDead
+			this.deadNode(&dead)
+		}
+	})
+}
+
+func (this *Gossip) deadNode(u *memberUpdate) {
+	m, present := this.members[u.Name]
+	if !present || u.Incarnation < m.Incarnation || m.State == stateDead {
+		return
+	}
+
+	if u.Name == this.name && !this.leaving {
+		this.refute(u.Incarnation)
+		return
+	}
+
+	log.Warn("gossip node[%s] dead", u.Name)
+
+	m.Incarnation = u.Incarnation
+	m.State = stateDead
+	m.since = time.Now()
+	this.queueMember(&m.memberUpdate)
+	this.notifyWatchers()
+}
+
+// refute tells others we are alive with a higher incarnation.
+func (this *Gossip) refute(incarnation uint32) {
+	self := this.members[this.name]
+	if incarnation >= self.Incarnation {
+		self.Incarnation = incarnation + 1
+	}
+
+	log.Warn("gossip refuting suspicion with incarnation %d",
+		self.Incarnation)
+	this.queueMember(&self.memberUpdate)
+}
+
+func (this *Gossip) queueMember(u *memberUpdate) {
+	copied := *u
+	this.broadcasts.queue(newBroadcast("m/"+u.Name, &copied))
+}
+
+// reapDead forgets nodes dead for long with their states.
+func (this *Gossip) reapDead() {
+	for name, m := range this.members {
+		if m.State == stateDead && name != this.name &&
+			time.Since(m.since) > this.cf.DeadReclaim {
+			log.Debug("gossip node[%s] reaped", name)
+
+			delete(this.members, name)
+			delete(this.states, name)
+		}
+	}
+}
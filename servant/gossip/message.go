@@ -0,0 +1,94 @@
+package gossip
+
+import (
+	"encoding/json"
+	"net"
+)
+
+// Each udp packet is a json encoded message. Updates of members, states
+// and events piggyback on any of them except sync ones, which carry the
+// full view of the sender instead, split into packets of MaxPacketSize.
+const (
+	msgPing    = "ping"
+	msgPingReq = "ping-req"
+	msgAck     = "ack"
+	msgGossip  = "gossip"
+	msgSync    = "sync"
+	msgSyncAck = "sync-ack"
+)
+
+type message struct {
+	Type       string
+	Seq        uint32 `json:",omitempty"`
+	From       string // name of sender
+	Target     string `json:",omitempty"` // node to ping
+	TargetAddr string `json:",omitempty"` // of ping-req
+
+	Members []*memberUpdate `json:",omitempty"`
+	States  []*stateUpdate  `json:",omitempty"`
+	Events  []*Event        `json:",omitempty"`
+}
+
+func (this *message) piggyback() bool {
+	return this.Type != msgSync && this.Type != msgSyncAck
+}
+
+type memberUpdate struct {
+	Name        string
+	Addr        string // gossip addr
+	Incarnation uint32
+	State       memberState
+}
+
+type stateUpdate struct {
+	Node    string
+	Key     string
+	Value   string
+	Version int64 // of the key, assigned by the node
+}
+
+// Event is fired on a node and delivered at most once to subscribers
+// of the topic on every other node.
+type Event struct {
+	Origin  string
+	Id      int64
+	Topic   string
+	Payload string
+}
+
+// sendAll sends messages one by one, stops on the 1st error.
+func (this *Gossip) sendAll(addr string, msgs []*message) error {
+	for _, msg := range msgs {
+		if err := this.send(addr, msg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// send fills piggybacked updates if any and sends the message.
+func (this *Gossip) send(addr string, msg *message) error {
+	msg.From = this.name
+
+	this.mutex.Lock()
+	if msg.piggyback() && this.broadcasts.len() > 0 {
+		base, _ := json.Marshal(msg)
+		this.broadcasts.pick(msg, this.cf.MaxPacketSize-len(base),
+			this.retransmitLimit())
+	}
+	this.mutex.Unlock()
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	_, err = this.conn.WriteToUDP(body, udpAddr)
+	return err
+}
@@ -0,0 +1,165 @@
+package gossip
+
+import (
+	log "github.com/funkygao/log4go"
+	"strconv"
+	"time"
+)
+
+// SetState publishes a key of local state, e,g. load of the node, to
+// all other nodes. Unchanged value is not published again.
+func (this *Gossip) SetState(key, value string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if cur, present := this.states[this.name][key]; present &&
+		cur.Value == value {
+		return
+	}
+
+	s := &stateUpdate{Node: this.name, Key: key, Value: value,
+		Version: this.nextVersion()}
+	this.states[this.name][key] = s
+	this.broadcasts.queue(newBroadcast("s/"+this.name+"/"+key, s))
+}
+
+// State returns value of the key published by the node.
+func (this *Gossip) State(node, key string) (string, bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	s, present := this.states[node][key]
+	if !present {
+		return "", false
+	}
+	return s.Value, true
+}
+
+// States returns value of the key published by each live node, self
+// inclusive.
+func (this *Gossip) States(key string) map[string]string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	r := make(map[string]string)
+	for node, states := range this.states {
+		if m, present := this.members[node]; !present ||
+			m.State == stateDead {
+			continue
+		}
+
+		if s, present := states[key]; present {
+			r[node] = s.Value
+		}
+	}
+	return r
+}
+
+// Publish fires an event to subscribers of the topic on other nodes.
+func (this *Gossip) Publish(topic, payload string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	e := &Event{Origin: this.name, Id: this.nextVersion(), Topic: topic,
+		Payload: payload}
+	key := eventKey(e)
+	this.seen[key] = time.Now()
+	this.broadcasts.queue(newBroadcast(key, e))
+}
+
+// Subscribe registers handler of events of the topic, handlers are
+// called one by one in a single goroutine.
+func (this *Gossip) Subscribe(topic string, handler func(Event)) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.handlers[topic] = append(this.handlers[topic], handler)
+}
+
+// nextVersion is time based so that it keeps growing across restarts.
+// Caller MUST hold the mutex.
+func (this *Gossip) nextVersion() int64 {
+	v := time.Now().UnixNano()
+	if v <= this.version {
+		v = this.version + 1
+	}
+	this.version = v
+	return v
+}
+
+// applyState MUST be called with mutex held.
+func (this *Gossip) applyState(s *stateUpdate) {
+	if s.Node == this.name {
+		// from our previous life, keep versions growing
+		if s.Version > this.version {
+			this.version = s.Version
+		}
+		return
+	}
+
+	states, present := this.states[s.Node]
+	if !present {
+		states = make(map[string]*stateUpdate)
+		this.states[s.Node] = states
+	}
+
+	if cur, present := states[s.Key]; present && cur.Version >= s.Version {
+		return
+	}
+
+	states[s.Key] = s
+	this.broadcasts.queue(newBroadcast("s/"+s.Node+"/"+s.Key, s))
+}
+
+// applyEvent MUST be called with mutex held.
+func (this *Gossip) applyEvent(e *Event) {
+	key := eventKey(e)
+	if _, present := this.seen[key]; present {
+		return
+	}
+
+	this.seen[key] = time.Now()
+	this.broadcasts.queue(newBroadcast(key, e))
+	if len(this.handlers[e.Topic]) == 0 {
+		return
+	}
+
+	select {
+	case this.events <- *e:
+	default:
+		log.Warn("gossip event dropped: %+v", *e)
+	}
+}
+
+// dispatch runs event handlers out of the receiving path.
+func (this *Gossip) dispatch() {
+	for {
+		select {
+		case <-this.quit:
+			return
+
+		case e := <-this.events:
+			this.mutex.Lock()
+			handlers := this.handlers[e.Topic]
+			this.mutex.Unlock()
+
+			for _, handler := range handlers {
+				handler(e)
+			}
+		}
+	}
+}
+
+// forgetEvents drops seen events older than dead reclaim, by then they
+// are no longer disseminated.
+func (this *Gossip) forgetEvents() {
+	for key, t := range this.seen {
+		if time.Since(t) > this.cf.DeadReclaim {
+			delete(this.seen, key)
+		}
+	}
+}
+
+func eventKey(e *Event) string {
+	return "e/" + e.Origin + "/" + strconv.FormatInt(e.Id, 10)
+}
@@ -0,0 +1,71 @@
+package servant
+
+import (
+	"encoding/json"
+	"github.com/funkygao/fae/servant/gossip"
+	log "github.com/funkygao/log4go"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	gossipTopicLcInvalidate = "lc.invalidate"
+
+	// breaker states are json of server:open
+	gossipStateLoad            = "load"
+	gossipStateBreakerMysql    = "breaker.mysql"
+	gossipStateBreakerRedis    = "breaker.redis"
+	gossipStateBreakerMongo    = "breaker.mongo"
+	gossipStateBreakerMemcache = "breaker.memcache"
+)
+
+// runGossipState publishes local state to other fae nodes periodically.
+func (this *FunServantImpl) runGossipState() {
+	ticker := time.NewTicker(this.conf.Gossip.StateInterval)
+	defer ticker.Stop()
+
+	for _ = range ticker.C {
+		this.publishGossipState()
+	}
+}
+
+func (this *FunServantImpl) publishGossipState() {
+	load, _ := json.Marshal(map[string]interface{}{
+		"goroutines": runtime.NumGoroutine(),
+		"sessions":   this.sessions.Len(),
+		"peer.from":  atomic.LoadInt64(&svtStats.callsFromPeer),
+		"peer.to":    atomic.LoadInt64(&svtStats.callsToPeer),
+	})
+	this.gs.SetState(gossipStateLoad, string(load))
+
+	if this.my != nil {
+		this.publishBreakers(gossipStateBreakerMysql, this.my.BreakerStates())
+	}
+	if this.rd != nil {
+		this.publishBreakers(gossipStateBreakerRedis, this.rd.BreakerStates())
+	}
+	if this.mg != nil {
+		this.publishBreakers(gossipStateBreakerMongo, this.mg.BreakerStates())
+	}
+	if this.mc != nil {
+		this.publishBreakers(gossipStateBreakerMemcache,
+			this.mc.BreakerStates())
+	}
+}
+
+func (this *FunServantImpl) publishBreakers(key string,
+	states map[string]bool) {
+	breakers, _ := json.Marshal(states)
+	this.gs.SetState(key, string(breakers))
+}
+
+// onLcInvalidate drops the lcache key deleted on another fae node.
+func (this *FunServantImpl) onLcInvalidate(e gossip.Event) {
+	if this.lc == nil {
+		return
+	}
+
+	log.Debug("lc[%s] invalidated by %s", e.Payload, e.Origin)
+	this.lc.Del(e.Payload)
+}
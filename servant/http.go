@@ -2,6 +2,7 @@ package servant
 
 import (
 	"fmt"
+	"github.com/funkygao/fae/servant/mongo"
 	"github.com/funkygao/golib/server"
 	"github.com/gorilla/mux"
 	"net/http"
@@ -19,16 +20,41 @@ func (this *FunServantImpl) handleHttpQuery(w http.ResponseWriter, req *http.Req
 	case "stat", "stats":
 		if this.mg != nil {
 			output["mongo"] = this.mg.FreeConnMap()
+			modes := make(map[string]string)
+			for pool, mode := range this.mg.Modes() {
+				modes[pool] = mode.String()
+			}
+			output["mongo.mode"] = modes
+			output["mongo.members"] = this.mg.Members()
+			if shardMap := this.mg.ShardMap(); shardMap != nil {
+				output["mongo.shardmap"] = shardMap
+			}
 		}
 		if this.mc != nil {
 			output["memcache"] = this.mc.FreeConnMap()
 		}
+		if this.cb != nil {
+			output["couchbase"] = this.cb.StatsMap()
+		}
 		if this.lc != nil {
 			output["lcache"] = this.lc.Len()
 		}
+		if this.rd != nil {
+			output["redis"] = this.rd.StatsMap()
+		}
+		if this.ps != nil {
+			output["pubsub"] = this.ps.Stats()
+		}
 		if this.proxy != nil {
 			output["proxy"] = this.proxy.StatsMap()
 		}
+		output["cursor"] = this.cursors.stats()
+		if this.ephemerals != nil {
+			output["zk.ephemerals"] = this.ephemerals.stats()
+		}
+		if this.gs != nil {
+			output["gossip"] = this.gs.Stats()
+		}
 
 		calls := make(map[string]interface{})
 		for _, key := range svtStats.calls.Keys() {
@@ -37,6 +63,33 @@ func (this *FunServantImpl) handleHttpQuery(w http.ResponseWriter, req *http.Req
 		output["rpc.call"] = calls
 		output["runtime"] = this.Runtime()
 
+	case "election":
+		if this.el == nil {
+			return nil, ErrServantNotStarted
+		}
+
+		leaders, err := this.el.Leaders()
+		if err != nil {
+			return nil, err
+		}
+		output["leaders"] = leaders
+		output["candidates"] = this.el.Candidates()
+
+	case "registry":
+		if this.reg == nil {
+			return nil, ErrServantNotStarted
+		}
+
+		output["services"] = this.reg.Services()
+
+	case "gossip":
+		if this.gs == nil {
+			return nil, ErrServantNotStarted
+		}
+
+		output["members"] = this.gs.Members()
+		output["load"] = this.gs.States(gossipStateLoad)
+
 	case "conf":
 		output["conf"] = *this.conf
 
@@ -44,6 +97,10 @@ func (this *FunServantImpl) handleHttpQuery(w http.ResponseWriter, req *http.Req
 		output["uris"] = []string{
 			"/svt/stat",
 			"/svt/conf",
+			"/svt/election",
+			"/svt/registry",
+			"/svt/gossip",
+			"PUT /svt/mongo/{pool}/{normal|readonly|degraded}",
 		}
 
 	default:
@@ -52,3 +109,25 @@ func (this *FunServantImpl) handleHttpQuery(w http.ResponseWriter, req *http.Req
 
 	return output, nil
 }
+
+func (this *FunServantImpl) handleMongoMode(w http.ResponseWriter, req *http.Request,
+	params map[string]interface{}) (interface{}, error) {
+	if this.mg == nil {
+		return nil, ErrServantNotStarted
+	}
+
+	vars := mux.Vars(req)
+	mode, err := mongo.ParseMode(vars["mode"])
+	if err != nil {
+		return nil, err
+	}
+
+	if err = this.mg.SetMode(vars["pool"], mode); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"pool": vars["pool"],
+		"mode": mode.String(),
+	}, nil
+}
@@ -0,0 +1,50 @@
+package memcache
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+)
+
+// FlagCompressed is the reserved bit of Item.Flags that marks a zlib
+// compressed value. It is transparent to RPC clients and must not be
+// used by them.
+const FlagCompressed uint32 = 1 << 30
+
+// ValueTooLargeError is returned when an item value exceeds the
+// configured max_value_size even after compression.
+type ValueTooLargeError struct {
+	Key   string
+	Size  int
+	Limit int
+}
+
+func (this *ValueTooLargeError) Error() string {
+	return fmt.Sprintf("memcache: value of key[%s] too large: %d > %d bytes",
+		this.Key, this.Size, this.Limit)
+}
+
+func Compress(val []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	if _, err := w.Write(val); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func Decompress(val []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(val))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,51 @@
+package memcache
+
+import (
+	"bytes"
+	"github.com/funkygao/assert"
+	"github.com/funkygao/fae/config"
+	"testing"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	val := bytes.Repeat([]byte("a:3:{i:0;s:5:\"hello\";}"), 1000)
+	compressed, err := Compress(val)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, len(compressed) < len(val))
+
+	uncompressed, err := Decompress(compressed)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, val, uncompressed)
+}
+
+func TestClientPoolEncodeDecode(t *testing.T) {
+	pool := &ClientPool{conf: &config.ConfigMemcache{
+		CompressThreshold: 100, MaxValueSize: 1 << 10}}
+
+	// small value kept as is
+	item := &Item{Key: "small", Value: []byte("hello"), Flags: 3}
+	assert.Equal(t, nil, pool.encode("default", item))
+	assert.Equal(t, uint32(3), item.Flags)
+
+	// big but compressible value
+	val := bytes.Repeat([]byte("x"), 10<<10)
+	item = &Item{Key: "big", Value: val, Flags: 3}
+	assert.Equal(t, nil, pool.encode("default", item))
+	assert.Equal(t, FlagCompressed|3, item.Flags)
+	assert.Equal(t, nil, pool.decode(item))
+	assert.Equal(t, uint32(3), item.Flags)
+	assert.Equal(t, val, item.Value)
+
+	// reserved flag bit
+	item = &Item{Key: "reserved", Value: []byte("hello"), Flags: FlagCompressed}
+	assert.Equal(t, ErrReservedFlag, pool.encode("default", item))
+}
+
+func TestClientPoolValueTooLarge(t *testing.T) {
+	pool := &ClientPool{conf: &config.ConfigMemcache{MaxValueSize: 10}}
+	item := &Item{Key: "huge", Value: bytes.Repeat([]byte("x"), 11)}
+	err := pool.encode("default", item)
+	_, ok := err.(*ValueTooLargeError)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "memcache: value of key[huge] too large: 11 > 10 bytes", err.Error())
+}
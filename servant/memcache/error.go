@@ -15,6 +15,7 @@ var (
 	ErrNoServers    = errors.New("memcache: no servers configured or available")
 	ErrCircuitOpen  = errors.New("memcache: circuit open")
 	ErrInvalidPool  = errors.New("memcache: invalid pool name")
+	ErrReservedFlag = errors.New("memcache: flags use reserved compression bit")
 )
 
 // ConnectTimeoutError is the error type used when it takes
@@ -84,6 +84,18 @@ func (this *Client) FreeConnMap() map[string][]*conn {
 	return ret
 }
 
+// BreakerStates tells whether circuit breaker of each server that has
+// been called is open, key is the server addr.
+func (this *Client) BreakerStates() map[string]bool {
+	this.lk.Lock()
+	defer this.lk.Unlock()
+	ret := make(map[string]bool, len(this.breakers))
+	for addr, b := range this.breakers {
+		ret[addr.String()] = b.Open()
+	}
+	return ret
+}
+
 func (this *Client) putFreeConn(addr net.Addr, cn *conn) {
 	this.lk.Lock()
 	defer this.lk.Unlock()
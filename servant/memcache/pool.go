@@ -2,10 +2,24 @@ package memcache
 
 import (
 	"github.com/funkygao/fae/config"
+	"github.com/funkygao/golib/sampling"
 	log "github.com/funkygao/log4go"
+	"github.com/funkygao/metrics"
 	"time"
 )
 
+// key is reason: compressed | rejected
+var bigValues = map[string]metrics.Counter{
+	"compressed": metrics.NewCounter(),
+	"rejected":   metrics.NewCounter(),
+}
+
+func init() {
+	for reason, counter := range bigValues {
+		metrics.Register("mc.bigvalue."+reason, counter)
+	}
+}
+
 type ClientPool struct {
 	conf    *config.ConfigMemcache
 	clients map[string]*Client // key is pool name
@@ -29,6 +43,18 @@ func (this *ClientPool) FreeConnMap() map[string]map[string][]*conn {
 	return ret
 }
 
+// BreakerStates merges breakers of all pools, a server shared by pools
+// is open if it is open in any of them.
+func (this *ClientPool) BreakerStates() map[string]bool {
+	ret := make(map[string]bool)
+	for _, client := range this.clients {
+		for addr, open := range client.BreakerStates() {
+			ret[addr] = ret[addr] || open
+		}
+	}
+	return ret
+}
+
 func (this *ClientPool) Warmup() {
 	t1 := time.Now()
 	for _, client := range this.clients {
@@ -39,33 +65,66 @@ func (this *ClientPool) Warmup() {
 		time.Since(t1), this.FreeConnMap())
 }
 
+func (this *ClientPool) BigValueStats() map[string]int64 {
+	r := make(map[string]int64, len(bigValues))
+	for reason, counter := range bigValues {
+		r[reason] = counter.Count()
+	}
+	return r
+}
+
 func (this *ClientPool) Get(pool string, key string) (item *Item, err error) {
-	if client, ok := this.clients[pool]; ok {
-		return client.Get(key)
+	client, ok := this.clients[pool]
+	if !ok {
+		return nil, ErrInvalidPool
+	}
+
+	item, err = client.Get(key)
+	if err == nil {
+		err = this.decode(item)
 	}
-	return nil, ErrInvalidPool
+	return
 }
 
 func (this *ClientPool) GetMulti(pool string,
 	keys []string) (map[string]*Item, error) {
-	if client, ok := this.clients[pool]; ok {
-		return client.GetMulti(keys)
+	client, ok := this.clients[pool]
+	if !ok {
+		return nil, ErrInvalidPool
 	}
-	return nil, ErrInvalidPool
+
+	items, err := client.GetMulti(keys)
+	for key, item := range items {
+		if e := this.decode(item); e != nil {
+			// corrupted value is treated as a miss
+			delete(items, key)
+		}
+	}
+	return items, err
 }
 
 func (this *ClientPool) Set(pool string, item *Item) error {
-	if client, ok := this.clients[pool]; ok {
-		return client.Set(item)
+	client, ok := this.clients[pool]
+	if !ok {
+		return ErrInvalidPool
 	}
-	return ErrInvalidPool
+
+	if err := this.encode(pool, item); err != nil {
+		return err
+	}
+	return client.Set(item)
 }
 
 func (this *ClientPool) Add(pool string, item *Item) error {
-	if client, ok := this.clients[pool]; ok {
-		return client.Add(item)
+	client, ok := this.clients[pool]
+	if !ok {
+		return ErrInvalidPool
 	}
-	return ErrInvalidPool
+
+	if err := this.encode(pool, item); err != nil {
+		return err
+	}
+	return client.Add(item)
 }
 
 func (this *ClientPool) Increment(pool string, key string,
@@ -87,3 +146,61 @@ func (this *ClientPool) Delete(pool string, key string) error {
 	}
 	return ErrInvalidPool
 }
+
+// encode compresses item value in place if it exceeds the compress threshold
+// and rejects it if still too large.
+func (this *ClientPool) encode(pool string, item *Item) error {
+	if item.Flags&FlagCompressed != 0 {
+		return ErrReservedFlag
+	}
+
+	size := len(item.Value)
+	if this.conf.CompressThreshold > 0 && size > this.conf.CompressThreshold {
+		compressed, err := Compress(item.Value)
+		if err != nil {
+			return err
+		}
+
+		// incompressible data is stored as is
+		if len(compressed) < size {
+			item.Value = compressed
+			item.Flags |= FlagCompressed
+			this.onBigValue(pool, item.Key, size, len(item.Value), "compressed")
+		}
+	}
+
+	if this.conf.MaxValueSize > 0 && len(item.Value) > this.conf.MaxValueSize {
+		this.onBigValue(pool, item.Key, size, len(item.Value), "rejected")
+		return &ValueTooLargeError{Key: item.Key, Size: len(item.Value),
+			Limit: this.conf.MaxValueSize}
+	}
+
+	return nil
+}
+
+// decode uncompresses item value in place and clears the reserved flag.
+func (this *ClientPool) decode(item *Item) error {
+	if item.Flags&FlagCompressed == 0 {
+		return nil
+	}
+
+	val, err := Decompress(item.Value)
+	if err != nil {
+		log.Error("memcache key[%s] decompress: %s", item.Key, err)
+		return err
+	}
+
+	item.Value = val
+	item.Flags &^= FlagCompressed
+	return nil
+}
+
+func (this *ClientPool) onBigValue(pool, key string, rawSize, size int,
+	reason string) {
+	bigValues[reason].Inc(1)
+
+	if sampling.SampleRateSatisfied(this.conf.BigValueLogSampleRate) {
+		log.Warn("memcache[%s] big value {key^%s raw^%d size^%d}: %s",
+			pool, key, rawSize, size, reason)
+	}
+}
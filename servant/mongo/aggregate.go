@@ -0,0 +1,62 @@
+package mongo
+
+import (
+	"labix.org/v2/mgo/bson"
+)
+
+// Aggregate runs an aggregation pipeline and returns at most
+// MaxResultDocs docs, or ErrTooManyResults if there are more.
+//
+// The aggregate cmd is issued directly instead of mgo Pipe for
+// allowDiskUse, and only the 1st batch is fetched: a result that doesn't
+// fit in it is rejected rather than returned partially.
+// Stages are bson.D as field order within a stage matters.
+func (this *Session) Aggregate(table string, pipeline []bson.D,
+	allowDisk bool) (docs []bson.M, err error) {
+	maxDocs := this.client.conf.MaxResultDocs
+	cmd := bson.D{
+		{"aggregate", table},
+		{"pipeline", pipeline},
+		{"allowDiskUse", allowDisk},
+		// 1 more doc to tell if the cap is exceeded
+		{"cursor", bson.M{"batchSize": maxDocs + 1}},
+	}
+
+	var result struct {
+		Cursor struct {
+			Id         int64    `bson:"id"`
+			FirstBatch []bson.M `bson:"firstBatch"`
+		} `bson:"cursor"`
+	}
+	if err = this.DB().Run(cmd, &result); err != nil {
+		return
+	}
+
+	if result.Cursor.Id != 0 {
+		// docs left on server: either beyond the cap or the batch was cut
+		// short by the reply size limit, the result would be partial
+		this.DB().Run(bson.D{{"killCursors", table},
+			{"cursors", []int64{result.Cursor.Id}}}, nil)
+		return nil, ErrTooManyResults
+	}
+
+	if len(result.Cursor.FirstBatch) > maxDocs {
+		return nil, ErrTooManyResults
+	}
+
+	return result.Cursor.FirstBatch, nil
+}
+
+// Distinct returns distinct values of key among docs matching query.
+func (this *Session) Distinct(table string, key string,
+	query bson.M) (values []interface{}, err error) {
+	if err = this.DB().C(table).Find(query).Distinct(key, &values); err != nil {
+		return
+	}
+
+	if len(values) > this.client.conf.MaxResultDocs {
+		return nil, ErrTooManyResults
+	}
+
+	return
+}
@@ -1,6 +1,8 @@
 package mongo
 
 import (
+	"github.com/funkygao/assert"
+	"labix.org/v2/mgo/bson"
 	"math/rand"
 	"testing"
 )
@@ -56,3 +58,10 @@ func deleteSession(session *mockSession) {
 	}
 
 }
+
+func TestUnmarshalInDKeepsOrder(t *testing.T) {
+	stage, _ := bson.Marshal(bson.D{{"$sort", bson.D{{"b", 1}, {"a", -1}}}})
+	d, err := UnmarshalInD(stage)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, bson.D{{"$sort", bson.D{{"b", 1}, {"a", -1}}}}, d)
+}
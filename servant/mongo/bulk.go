@@ -0,0 +1,206 @@
+package mongo
+
+import (
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+)
+
+// kinds of WriteOp
+const (
+	OpInsert = "insert"
+	OpUpdate = "update"
+	OpUpsert = "upsert"
+	OpDelete = "delete"
+)
+
+// max ops in a single write cmd accepted by mongod
+const maxWriteBatchSize = 1000
+
+// error codes of WriteResult besides mongodb server error codes
+const (
+	ErrCodeClient  = -1 // network or bad param
+	ErrCodeSkipped = -2 // not executed because an earlier op failed in ordered mode
+)
+
+// A single op within BulkWrite.
+type WriteOp struct {
+	Kind   string
+	Doc    bson.M // insert
+	Query  bson.M // update, upsert, delete
+	Change bson.M // update, upsert
+	Multi  bool   // update or delete all matching docs
+}
+
+// Result of a single op within BulkWrite.
+type WriteResult struct {
+	Matched    int
+	Modified   int
+	UpsertedId interface{}
+	ErrCode    int // 0 means ok
+	ErrMsg     string
+}
+
+func (this *WriteResult) fail(code int, msg string) {
+	this.ErrCode = code
+	this.ErrMsg = msg
+}
+
+type writeUpserted struct {
+	Index int         `bson:"index"`
+	Id    interface{} `bson:"_id"`
+}
+
+type writeError struct {
+	Index  int    `bson:"index"`
+	Code   int    `bson:"code"`
+	ErrMsg string `bson:"errmsg"`
+}
+
+// reply of insert/update/delete write commands
+type writeCmdResult struct {
+	N                 int             `bson:"n"`
+	NModified         int             `bson:"nModified"`
+	Upserted          []writeUpserted `bson:"upserted"`
+	WriteErrors       []writeError    `bson:"writeErrors"`
+	WriteConcernError *writeError     `bson:"writeConcernError"`
+}
+
+// BulkWrite runs a mixed list of ops with write commands and returns
+// per-op results in the same order of ops.
+//
+// Consecutive inserts are sent as a single cmd, while each update and
+// delete is a cmd of its own to get its exact matched/modified count.
+// In ordered mode, ops after the 1st failure are skipped.
+//
+// err is only for network errors, after which the rest ops are marked
+// as failed.
+func (this *Session) BulkWrite(table string, ops []WriteOp,
+	ordered bool) (results []WriteResult, err error) {
+	results = make([]WriteResult, len(ops))
+	failed := false
+	for i := 0; i < len(ops); {
+		if failed && ordered {
+			skip(results[i:])
+			return
+		}
+
+		op := ops[i]
+		var (
+			cmd bson.D
+			n   = 1 // number of ops in this cmd
+		)
+		switch op.Kind {
+		case OpInsert:
+			docs := make([]bson.M, 0)
+			for i+len(docs) < len(ops) && ops[i+len(docs)].Kind == OpInsert &&
+				len(docs) < maxWriteBatchSize {
+				docs = append(docs, ops[i+len(docs)].Doc)
+			}
+			n = len(docs)
+			cmd = bson.D{{"insert", table}, {"documents", docs}, {"ordered", ordered}}
+
+		case OpUpdate, OpUpsert:
+			cmd = bson.D{{"update", table}, {"updates", []bson.M{{
+				"q":      op.Query,
+				"u":      op.Change,
+				"upsert": op.Kind == OpUpsert,
+				"multi":  op.Multi,
+			}}}}
+
+		case OpDelete:
+			limit := 1
+			if op.Multi {
+				limit = 0
+			}
+			cmd = bson.D{{"delete", table}, {"deletes", []bson.M{{
+				"q":     op.Query,
+				"limit": limit,
+			}}}}
+
+		default:
+			results[i].fail(ErrCodeClient, "unknown op: "+op.Kind)
+			failed = true
+			i++
+			continue
+		}
+
+		var res writeCmdResult
+		if e := this.DB().Run(cmd, &res); e != nil {
+			if qe, ok := e.(*mgo.QueryError); ok {
+				// cmd rejected by server, conn is still healthy
+				for j := i; j < i+n; j++ {
+					results[j].fail(qe.Code, qe.Message)
+				}
+				failed = true
+				i += n
+				continue
+			}
+
+			err = e
+			for j := i; j < len(ops); j++ {
+				results[j].fail(ErrCodeClient, e.Error())
+			}
+			return
+		}
+
+		if applyWriteResult(results[i:i+n], op.Kind, &res, ordered) {
+			failed = true
+		}
+		i += n
+	}
+
+	return
+}
+
+// applyWriteResult fills results of ops within a single write cmd.
+// For update and delete, there is exactly 1 op.
+func applyWriteResult(results []WriteResult, kind string,
+	res *writeCmdResult, ordered bool) (failed bool) {
+	switch kind {
+	case OpUpdate, OpUpsert:
+		results[0].Matched = res.N - len(res.Upserted)
+		results[0].Modified = res.NModified
+		if len(res.Upserted) > 0 {
+			results[0].UpsertedId = res.Upserted[0].Id
+		}
+
+	case OpDelete:
+		results[0].Matched = res.N
+	}
+
+	firstErr := len(results)
+	for _, we := range res.WriteErrors {
+		if we.Index < 0 || we.Index >= len(results) {
+			continue
+		}
+
+		results[we.Index].fail(we.Code, we.ErrMsg)
+		if we.Index < firstErr {
+			firstErr = we.Index
+		}
+		failed = true
+	}
+
+	if ordered && firstErr < len(results) {
+		// server stops at the 1st error of an ordered cmd
+		skip(results[firstErr+1:])
+	}
+
+	if wce := res.WriteConcernError; wce != nil {
+		// applied but not durable as required
+		for i := range results {
+			if results[i].ErrCode == 0 {
+				results[i].fail(wce.Code, wce.ErrMsg)
+			}
+		}
+		failed = true
+	}
+
+	return
+}
+
+func skip(results []WriteResult) {
+	for i := range results {
+		results[i].fail(ErrCodeSkipped, "skipped after an earlier failure")
+	}
+}
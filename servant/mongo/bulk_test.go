@@ -0,0 +1,41 @@
+package mongo
+
+import (
+	"github.com/funkygao/assert"
+	"testing"
+)
+
+func TestApplyWriteResultInsert(t *testing.T) {
+	res := &writeCmdResult{N: 1, WriteErrors: []writeError{
+		{Index: 1, Code: 11000, ErrMsg: "E11000 duplicate key"}}}
+
+	// unordered: only the dup fails
+	results := make([]WriteResult, 3)
+	assert.Equal(t, true, applyWriteResult(results, OpInsert, res, false))
+	assert.Equal(t, 0, results[0].ErrCode)
+	assert.Equal(t, 11000, results[1].ErrCode)
+	assert.Equal(t, 0, results[2].ErrCode)
+
+	// ordered: ops after the dup are skipped
+	results = make([]WriteResult, 3)
+	assert.Equal(t, true, applyWriteResult(results, OpInsert, res, true))
+	assert.Equal(t, 0, results[0].ErrCode)
+	assert.Equal(t, 11000, results[1].ErrCode)
+	assert.Equal(t, ErrCodeSkipped, results[2].ErrCode)
+}
+
+func TestApplyWriteResultUpsert(t *testing.T) {
+	res := &writeCmdResult{N: 1, Upserted: []writeUpserted{{Index: 0, Id: 5}}}
+
+	results := make([]WriteResult, 1)
+	assert.Equal(t, false, applyWriteResult(results, OpUpsert, res, true))
+	assert.Equal(t, 0, results[0].Matched)
+	assert.Equal(t, 5, results[0].UpsertedId)
+
+	res = &writeCmdResult{N: 3, NModified: 2}
+	results = make([]WriteResult, 1)
+	applyWriteResult(results, OpUpdate, res, true)
+	assert.Equal(t, 3, results[0].Matched)
+	assert.Equal(t, 2, results[0].Modified)
+	assert.Equal(t, nil, results[0].UpsertedId)
+}
@@ -19,18 +19,31 @@ type Client struct {
 	freeconns     map[string][]*mgo.Session // key is server uri
 	breakers      map[string]*breaker.Consecutive
 	throttleConns map[string]chan interface{}
+
+	modesLock sync.RWMutex
+	modes     map[string]Mode // pool:mode, absent means normal
+
+	membersLock sync.RWMutex
+	members     map[string][]MemberStatus // pool:replica set members
 }
 
-func New(cf *config.ConfigMongodb) (this *Client) {
+func New(cf *config.ConfigMongodb) (this *Client, err error) {
 	this = new(Client)
 	this.conf = cf
 	this.breakers = make(map[string]*breaker.Consecutive)
 	this.throttleConns = make(map[string]chan interface{})
+	this.modes = make(map[string]Mode)
+	this.members = make(map[string][]MemberStatus)
 
 	switch cf.ShardStrategy {
 	case "legacy":
 		this.selector = NewLegacyServerSelector(cf.ShardBaseNum)
 
+	case "map":
+		if this.selector, err = NewMapServerSelector(&cf.ShardMap); err != nil {
+			return nil, err
+		}
+
 	default:
 		this.selector = NewStandardServerSelector(cf.ShardBaseNum)
 	}
@@ -41,23 +54,44 @@ func New(cf *config.ConfigMongodb) (this *Client) {
 	return
 }
 
+// Close stops following the shard map, e,g. when the client is replaced
+// on config reload. Sessions in use are not affected.
+func (this *Client) Close() {
+	if m, ok := this.selector.(*MapServerSelector); ok {
+		m.Close()
+	}
+}
+
 func (this *Client) FreeConnMap() map[string][]*mgo.Session {
 	this.lk.Lock()
 	defer this.lk.Unlock()
 	return this.freeconns
 }
 
-func (this *Client) Session(pool string, shardId int32) (*Session, error) {
+// Session borrows a session of the shard.
+// Empty readPref means the default read preference of the pool.
+func (this *Client) Session(pool string, shardId int32,
+	readPref string) (*Session, error) {
 	server, err := this.selector.PickServer(pool, int(shardId))
 	if err != nil {
 		return nil, err
 	}
 
+	if readPref == "" {
+		readPref = server.ReadPref
+	}
+
 	sess, err := this.getConn(server.Uri())
 	if err != nil {
 		return nil, err
 	}
 
+	// pooled sessions are shared by calls of different read preferences
+	if err = setReadPref(sess, readPref); err != nil {
+		this.putFreeConn(server.Uri(), sess)
+		return nil, err
+	}
+
 	return &Session{Session: sess, client: this, server: server}, nil
 }
 
@@ -121,7 +155,6 @@ func (this *Client) dial(uri string) (*mgo.Session, error) {
 
 	this.breakers[uri].Succeed()
 	sess.SetSocketTimeout(this.conf.IoTimeout)
-	sess.SetMode(mgo.Monotonic, true)
 
 	return sess, nil
 }
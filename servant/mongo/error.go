@@ -7,4 +7,13 @@ import (
 var (
 	ErrServerNotFound = errors.New("mongodb: server not found")
 	ErrCircuitOpen    = errors.New("mongodb: circuit open")
+	ErrTooManyResults = errors.New("mongodb: too many result docs")
+	ErrDegraded       = errors.New("mongodb: pool degraded")
+	ErrReadOnly       = errors.New("mongodb: pool read only")
+	ErrInvalidMode    = errors.New("mongodb: invalid mode")
+	ErrInvalidPool    = errors.New("mongodb: invalid pool")
+	ErrShardMoving    = errors.New("mongodb: shard moving, write rejected")
+	ErrNoShardMap     = errors.New("mongodb: shard_strategy is not map")
+
+	ErrInvalidReadPref = errors.New("mongodb: invalid read preference")
 )
@@ -0,0 +1,164 @@
+package mongo
+
+import (
+	log "github.com/funkygao/log4go"
+)
+
+// Operator controlled service mode of a pool or a shard.
+type Mode int
+
+const (
+	ModeNormal   Mode = iota
+	ModeReadOnly      // writes are rejected
+	ModeDegraded      // everything is rejected, client falls back to cache
+)
+
+var modeNames = map[Mode]string{
+	ModeNormal:   "normal",
+	ModeReadOnly: "readonly",
+	ModeDegraded: "degraded",
+}
+
+func (this Mode) String() string {
+	return modeNames[this]
+}
+
+func ParseMode(s string) (Mode, error) {
+	for mode, name := range modeNames {
+		if name == s {
+			return mode, nil
+		}
+	}
+
+	return ModeNormal, ErrInvalidMode
+}
+
+// SetMode switches mode of a pool or a shard at runtime.
+// pool is either the pool name used by RPC clients, or the pool name
+// of a server in config which is a shard of the former.
+func (this *Client) SetMode(pool string, mode Mode) error {
+	if !this.validPool(pool) {
+		return ErrInvalidPool
+	}
+
+	this.modesLock.Lock()
+	defer this.modesLock.Unlock()
+
+	log.Warn("mongodb pool[%s] mode: %s -> %s", pool, this.modes[pool], mode)
+
+	if mode == ModeNormal {
+		delete(this.modes, pool)
+	} else {
+		this.modes[pool] = mode
+	}
+	return nil
+}
+
+// validPool tells whether the pool is known to config, so that a typo
+// of operator won't silently change nothing.
+func (this *Client) validPool(pool string) bool {
+	for _, server := range this.selector.ServerList() {
+		if server.Pool == pool {
+			return true
+		}
+	}
+
+	if m, ok := this.selector.(*MapServerSelector); ok && m.Sharded(pool) {
+		return true
+	}
+
+	// pool of RPC clients that is resolved to a server pool
+	_, err := this.selector.PickServer(pool, 0)
+	return err == nil
+}
+
+// Modes returns pools that are not in normal mode.
+func (this *Client) Modes() map[string]Mode {
+	this.modesLock.RLock()
+	defer this.modesLock.RUnlock()
+
+	r := make(map[string]Mode, len(this.modes))
+	for pool, mode := range this.modes {
+		r[pool] = mode
+	}
+	return r
+}
+
+func (this *Client) mode(pool string) Mode {
+	this.modesLock.RLock()
+	defer this.modesLock.RUnlock()
+	return this.modes[pool]
+}
+
+// Guard fails fast with ErrDegraded or ErrReadOnly according to the mode
+// of the pool and the shard. A shard whose breaker is open is treated
+// as degraded until the breaker closes, and writes to a moving shard
+// fail with ErrShardMoving.
+func (this *Client) Guard(pool string, shardId int32, write bool) error {
+	server, err := this.selector.PickServer(pool, int(shardId))
+	if err != nil {
+		return err
+	}
+
+	if write {
+		if m, ok := this.selector.(*MapServerSelector); ok &&
+			m.Moving(pool, int(shardId)) {
+			return ErrShardMoving
+		}
+	}
+
+	mode := this.mode(pool)
+	if shardMode := this.mode(server.Pool); shardMode > mode {
+		mode = shardMode
+	}
+	if mode < ModeDegraded && this.breakerOpen(server.Uri()) {
+		mode = ModeDegraded
+	}
+
+	switch {
+	case mode == ModeDegraded:
+		return ErrDegraded
+
+	case mode == ModeReadOnly && write:
+		return ErrReadOnly
+	}
+
+	return nil
+}
+
+func (this *Client) breakerOpen(uri string) bool {
+	this.lk.Lock()
+	b, present := this.breakers[uri]
+	this.lk.Unlock()
+
+	return present && b.Open()
+}
+
+// BreakerStates tells whether circuit breaker of each server is open,
+// key is the server pool.
+func (this *Client) BreakerStates() map[string]bool {
+	r := make(map[string]bool)
+	for _, server := range this.selector.ServerList() {
+		r[server.Pool] = this.breakerOpen(server.Uri())
+	}
+	return r
+}
+
+// SetShardMap hot reloads the shard map without recreating the client.
+func (this *Client) SetShardMap(specs []string) error {
+	m, ok := this.selector.(*MapServerSelector)
+	if !ok {
+		return ErrNoShardMap
+	}
+
+	return m.SetShardMap(specs)
+}
+
+// ShardMap returns nil if shard_strategy is not map.
+func (this *Client) ShardMap() []string {
+	if m, ok := this.selector.(*MapServerSelector); ok {
+		return m.ShardMap()
+	}
+
+	return nil
+}
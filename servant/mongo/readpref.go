@@ -0,0 +1,47 @@
+package mongo
+
+import (
+	"github.com/funkygao/fae/config"
+	"labix.org/v2/mgo"
+)
+
+// readModes maps read preferences to the closest mgo mode.
+//
+// mgo only has 3 consistency modes: Strong reads from primary, Monotonic
+// reads from a secondary till the 1st write, and Eventual reads from any
+// member. So the mapping is not exact:
+//   - primaryPreferred is Monotonic: reads can go to a secondary even
+//     when the primary is up, but switch to primary after a write
+//   - secondary is Eventual: reads can go to the primary as well, and
+//     never fall back to primary only
+//   - nearest is Eventual: member is not picked by latency
+var readModes = map[string]mgo.Mode{
+	config.MongoReadPrimary:          mgo.Strong,
+	config.MongoReadPrimaryPreferred: mgo.Monotonic,
+	config.MongoReadSecondary:        mgo.Eventual,
+	config.MongoReadNearest:          mgo.Eventual,
+	config.MongoReadMonotonic:        mgo.Monotonic,
+}
+
+func readMode(pref string) (mgo.Mode, error) {
+	mode, present := readModes[pref]
+	if !present {
+		return 0, ErrInvalidReadPref
+	}
+
+	return mode, nil
+}
+
+// setReadPref applies read preference to a session.
+func setReadPref(sess *mgo.Session, pref string) error {
+	mode, err := readMode(pref)
+	if err != nil {
+		return err
+	}
+
+	// refresh drops reserved sockets, so only on mode change
+	if sess.Mode() != mode {
+		sess.SetMode(mode, true)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package mongo
+
+import (
+	"github.com/funkygao/assert"
+	"github.com/funkygao/fae/config"
+	"labix.org/v2/mgo"
+	"testing"
+)
+
+func TestReadMode(t *testing.T) {
+	mode, err := readMode(config.MongoReadPrimary)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, mgo.Strong, mode)
+
+	mode, err = readMode(config.MongoReadMonotonic)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, mgo.Monotonic, mode)
+
+	mode, err = readMode(config.MongoReadPrimaryPreferred)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, mgo.Monotonic, mode)
+
+	mode, err = readMode(config.MongoReadSecondary)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, mgo.Eventual, mode)
+
+	mode, err = readMode(config.MongoReadNearest)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, mgo.Eventual, mode)
+
+	for _, pref := range []string{"eventual", "Primary", ""} {
+		_, err = readMode(pref)
+		assert.Equal(t, ErrInvalidReadPref, err)
+	}
+}
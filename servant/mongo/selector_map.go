@@ -0,0 +1,259 @@
+package mongo
+
+import (
+	"fmt"
+	"github.com/funkygao/etclib"
+	"github.com/funkygao/fae/config"
+	log "github.com/funkygao/log4go"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A contiguous range of shard keys served by a server pool.
+type shardRange struct {
+	min, max int    // inclusive
+	pool     string // pool of the server in config
+	moving   bool   // data is being copied, writes fail fast
+}
+
+// Sharding by an explicit shard map that can be reloaded at runtime.
+//
+// Each range is in the form of pool:min-max:serverPool[:moving], e,g.
+//
+//	db:0-99999:db1
+//	db:100000-199999:db2:moving
+//
+// With vbuckets, the shard key is shardId%vbuckets, so that a pool can
+// be resharded by moving vbucket ranges instead of shardId ranges.
+//
+// Pools absent in the map are not sharded, same as StandardServerSelector.
+type MapServerSelector struct {
+	vbuckets int
+	servers  map[string]*config.ConfigMongodbServer // key is pool
+
+	lock   sync.RWMutex
+	ranges map[string][]shardRange // key is sharded pool, sorted by min
+
+	quit chan struct{}
+}
+
+func NewMapServerSelector(cf *config.ConfigMongodbShardMap) (*MapServerSelector,
+	error) {
+	this := &MapServerSelector{vbuckets: cf.Vbuckets,
+		ranges: make(map[string][]shardRange), quit: make(chan struct{})}
+	if err := this.SetShardMap(cf.Ranges); err != nil {
+		return nil, err
+	}
+
+	if cf.EtcdPath != "" {
+		go this.watchEtcd(cf.EtcdPath)
+	}
+
+	return this, nil
+}
+
+// Close stops following the shard map in etcd.
+func (this *MapServerSelector) Close() {
+	close(this.quit)
+}
+
+func (this *MapServerSelector) PickServer(pool string,
+	shardId int) (server *config.ConfigMongodbServer, err error) {
+	bucket := pool
+	if r, sharded := this.pick(pool, shardId); sharded {
+		if r == nil {
+			return nil, ErrServerNotFound
+		}
+
+		bucket = r.pool
+	}
+
+	var present bool
+	server, present = this.servers[bucket]
+	if !present {
+		err = ErrServerNotFound
+	}
+
+	return
+}
+
+func (this *MapServerSelector) SetServers(servers map[string]*config.ConfigMongodbServer) {
+	this.servers = servers
+}
+
+func (this *MapServerSelector) ServerList() (servers []*config.ConfigMongodbServer) {
+	for _, s := range this.servers {
+		servers = append(servers, s)
+	}
+	return
+}
+
+// Moving tells whether the range of the shard is being moved.
+func (this *MapServerSelector) Moving(pool string, shardId int) bool {
+	r, _ := this.pick(pool, shardId)
+	return r != nil && r.moving
+}
+
+// SetShardMap replaces the whole shard map atomically.
+// The current map is kept if any range is invalid.
+func (this *MapServerSelector) SetShardMap(specs []string) error {
+	ranges, err := parseShardMap(specs)
+	if err != nil {
+		return err
+	}
+
+	this.lock.Lock()
+	this.ranges = ranges
+	this.lock.Unlock()
+
+	log.Info("mongodb shard map: %+v", specs)
+	return nil
+}
+
+// ShardMap returns the current shard map in the form of config.
+func (this *MapServerSelector) ShardMap() []string {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	specs := make([]string, 0)
+	for pool, ranges := range this.ranges {
+		for _, r := range ranges {
+			spec := fmt.Sprintf("%s:%d-%d:%s", pool, r.min, r.max, r.pool)
+			if r.moving {
+				spec += ":moving"
+			}
+			specs = append(specs, spec)
+		}
+	}
+	sort.Strings(specs)
+	return specs
+}
+
+// Sharded tells whether the pool is in shard map.
+func (this *MapServerSelector) Sharded(pool string) bool {
+	_, sharded := this.pick(pool, 0)
+	return sharded
+}
+
+// pick returns the range of the shard, nil if not found.
+// sharded is false if the pool is absent in shard map.
+func (this *MapServerSelector) pick(pool string,
+	shardId int) (r *shardRange, sharded bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	ranges, sharded := this.ranges[pool]
+	if !sharded {
+		return
+	}
+
+	key := shardId
+	if this.vbuckets > 0 {
+		key = shardId % this.vbuckets
+	}
+
+	i := sort.Search(len(ranges), func(i int) bool {
+		return ranges[i].max >= key
+	})
+	if i < len(ranges) && ranges[i].min <= key {
+		r = &ranges[i]
+	}
+
+	return
+}
+
+func (this *MapServerSelector) watchEtcd(path string) {
+	ch := make(chan []string, 10)
+	go etclib.WatchChildren(path, ch)
+
+	this.loadEtcd(path)
+	for {
+		select {
+		case <-ch:
+			this.loadEtcd(path)
+
+		case <-this.quit:
+			// etclib watcher can't be stopped, it blocks on ch at most
+			return
+		}
+	}
+}
+
+func (this *MapServerSelector) loadEtcd(path string) {
+	specs, err := etclib.Children(path)
+	if err != nil {
+		log.Error("mongodb shard map[%s]: %s", path, err)
+		return
+	}
+
+	if err = this.SetShardMap(specs); err != nil {
+		log.Error("mongodb shard map[%s] %+v: %s", path, specs, err)
+	}
+}
+
+func parseShardMap(specs []string) (map[string][]shardRange, error) {
+	ranges := make(map[string][]shardRange)
+	for _, spec := range specs {
+		pool, r, err := parseShardRange(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		ranges[pool] = append(ranges[pool], r)
+	}
+
+	for pool, rs := range ranges {
+		sort.Sort(shardRanges(rs))
+		for i := 1; i < len(rs); i++ {
+			if rs[i].min <= rs[i-1].max {
+				return nil, fmt.Errorf("mongodb: overlapped shard range of %s: %d-%d",
+					pool, rs[i].min, rs[i].max)
+			}
+		}
+	}
+
+	return ranges, nil
+}
+
+func parseShardRange(spec string) (pool string, r shardRange, err error) {
+	p := strings.Split(spec, ":")
+	if len(p) < 3 || len(p) > 4 || p[0] == "" || p[2] == "" {
+		err = fmt.Errorf("mongodb: invalid shard range: %s", spec)
+		return
+	}
+
+	bounds := strings.SplitN(p[1], "-", 2)
+	if len(bounds) != 2 {
+		err = fmt.Errorf("mongodb: invalid shard range: %s", spec)
+		return
+	}
+	if r.min, err = strconv.Atoi(bounds[0]); err != nil {
+		return
+	}
+	if r.max, err = strconv.Atoi(bounds[1]); err != nil {
+		return
+	}
+	if r.min > r.max {
+		err = fmt.Errorf("mongodb: invalid shard range: %s", spec)
+		return
+	}
+
+	if len(p) == 4 {
+		if p[3] != "moving" {
+			err = fmt.Errorf("mongodb: invalid shard range: %s", spec)
+			return
+		}
+		r.moving = true
+	}
+
+	pool, r.pool = p[0], p[2]
+	return
+}
+
+type shardRanges []shardRange
+
+func (this shardRanges) Len() int           { return len(this) }
+func (this shardRanges) Less(i, j int) bool { return this[i].min < this[j].min }
+func (this shardRanges) Swap(i, j int)      { this[i], this[j] = this[j], this[i] }
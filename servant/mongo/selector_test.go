@@ -20,7 +20,7 @@ func TestStandardServerSelector(t *testing.T) {
 	picker.SetServers(cf.Servers)
 
 	addr, err := picker.PickServer("db", 23)
-	assert.Equal(t, "mongodb://127.0.0.1:27017/qa_royal_1/", addr.Uri())
+	assert.Equal(t, "mongodb://127.0.0.1:27017/qa_royal_1", addr.Uri())
 	assert.Equal(t, nil, err)
 
 	addr, err = picker.PickServer("invalid", 23)
@@ -30,11 +30,11 @@ func TestStandardServerSelector(t *testing.T) {
 	assert.Equal(t, ErrServerNotFound, err)
 
 	addr, err = picker.PickServer("default", 1<<30) // too big for 1000
-	assert.Equal(t, "mongodb://127.0.0.1:27017/qa_royal_0/", addr.Uri())
+	assert.Equal(t, "mongodb://127.0.0.1:27017/qa_royal_0", addr.Uri())
 	assert.Equal(t, nil, err)
 
 	addr, err = picker.PickServer("log", 1<<30) // too big for 1000
-	assert.Equal(t, "mongodb://127.0.0.1:27017/qa_royal_log/", addr.Uri())
+	assert.Equal(t, "mongodb://127.0.0.1:27017/qa_royal_log", addr.Uri())
 	assert.Equal(t, nil, err)
 }
 
@@ -50,19 +50,19 @@ func TestLegacyServerSelector(t *testing.T) {
 	assert.Equal(t, ErrServerNotFound, err)
 
 	addr, err = picker.PickServer("db1", 2300)
-	assert.Equal(t, "mongodb://127.0.0.1:27017/qa_royal_1/", addr.Uri())
+	assert.Equal(t, "mongodb://127.0.0.1:27017/qa_royal_1", addr.Uri())
 	assert.Equal(t, nil, err)
 
 	addr, err = picker.PickServer("db1", 1<<30) // has nothing to do with shardId
-	assert.Equal(t, "mongodb://127.0.0.1:27017/qa_royal_1/", addr.Uri())
+	assert.Equal(t, "mongodb://127.0.0.1:27017/qa_royal_1", addr.Uri())
 	assert.Equal(t, nil, err)
 
 	addr, err = picker.PickServer("default", 1<<30) // too big for 1000
-	assert.Equal(t, "mongodb://127.0.0.1:27017/qa_royal_0/", addr.Uri())
+	assert.Equal(t, "mongodb://127.0.0.1:27017/qa_royal_0", addr.Uri())
 	assert.Equal(t, nil, err)
 
 	addr, err = picker.PickServer("log", 1<<30) // too big for 1000
-	assert.Equal(t, "mongodb://127.0.0.1:27017/qa_royal_log/", addr.Uri())
+	assert.Equal(t, "mongodb://127.0.0.1:27017/qa_royal_log", addr.Uri())
 	assert.Equal(t, nil, err)
 }
 
@@ -72,3 +72,95 @@ func TestNormalizedPool(t *testing.T) {
 	assert.Equal(t, "db5", fun.normalizedPool("db5"))
 	assert.Equal(t, "db3", fun.normalizedPool("database.db3"))
 }
+
+func TestMapServerSelector(t *testing.T) {
+	picker, err := NewMapServerSelector(&config.ConfigMongodbShardMap{
+		Ranges: []string{
+			"db:100000-199999:db2:moving",
+			"db:0-99999:db1",
+		}})
+	assert.Equal(t, nil, err)
+	picker.SetServers(map[string]*config.ConfigMongodbServer{
+		"db1":     &config.ConfigMongodbServer{Pool: "db1"},
+		"db2":     &config.ConfigMongodbServer{Pool: "db2"},
+		"default": &config.ConfigMongodbServer{Pool: "default"},
+	})
+
+	server, err := picker.PickServer("db", 23)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "db1", server.Pool)
+	assert.Equal(t, false, picker.Moving("db", 23))
+
+	server, err = picker.PickServer("db", 100000)
+	assert.Equal(t, "db2", server.Pool)
+	assert.Equal(t, true, picker.Moving("db", 100000))
+
+	_, err = picker.PickServer("db", 200000) // out of map
+	assert.Equal(t, ErrServerNotFound, err)
+
+	server, err = picker.PickServer("default", 1<<30) // not sharded
+	assert.Equal(t, "default", server.Pool)
+
+	// moving done, hot reload
+	assert.Equal(t, nil, picker.SetShardMap([]string{
+		"db:0-99999:db1", "db:100000-199999:db1"}))
+	server, err = picker.PickServer("db", 100000)
+	assert.Equal(t, "db1", server.Pool)
+	assert.Equal(t, false, picker.Moving("db", 100000))
+
+	// invalid map is rejected and the current is kept
+	assert.NotEqual(t, nil, picker.SetShardMap([]string{
+		"db:0-99999:db1", "db:99999-199999:db2"}))
+	assert.Equal(t, []string{"db:0-99999:db1", "db:100000-199999:db1"},
+		picker.ShardMap())
+
+	_, err = NewMapServerSelector(&config.ConfigMongodbShardMap{
+		Ranges: []string{"db:0-99999"}})
+	assert.NotEqual(t, nil, err)
+}
+
+func TestMapServerSelectorVbucket(t *testing.T) {
+	picker, _ := NewMapServerSelector(&config.ConfigMongodbShardMap{
+		Vbuckets: 16,
+		Ranges:   []string{"db:0-7:db1", "db:8-15:db2"},
+	})
+	picker.SetServers(map[string]*config.ConfigMongodbServer{
+		"db1": &config.ConfigMongodbServer{Pool: "db1"},
+		"db2": &config.ConfigMongodbServer{Pool: "db2"},
+	})
+
+	server, _ := picker.PickServer("db", 16+3)
+	assert.Equal(t, "db1", server.Pool)
+	server, _ = picker.PickServer("db", 16*100+9)
+	assert.Equal(t, "db2", server.Pool)
+}
+
+func TestParseShardRange(t *testing.T) {
+	pool, r, err := parseShardRange("db:0-99:db1:moving")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "db", pool)
+	assert.Equal(t, shardRange{min: 0, max: 99, pool: "db1", moving: true}, r)
+
+	for _, spec := range []string{"db:0-99", "db:99-0:db1", "db:a-9:db1",
+		"db:0-9:db1:frozen", ":0-9:db1"} {
+		_, _, err = parseShardRange(spec)
+		assert.NotEqual(t, nil, err)
+	}
+}
+
+func TestSetModeValidatesPool(t *testing.T) {
+	picker, _ := NewMapServerSelector(&config.ConfigMongodbShardMap{
+		Ranges: []string{"db:100-199:db1"},
+	})
+	picker.SetServers(map[string]*config.ConfigMongodbServer{
+		"db1":     &config.ConfigMongodbServer{Pool: "db1"},
+		"default": &config.ConfigMongodbServer{Pool: "default"},
+	})
+	c := &Client{selector: picker, modes: make(map[string]Mode)}
+
+	assert.Equal(t, nil, c.SetMode("db", ModeReadOnly)) // sharded pool
+	assert.Equal(t, nil, c.SetMode("db1", ModeDegraded))
+	assert.Equal(t, nil, c.SetMode("default", ModeReadOnly))
+	assert.Equal(t, ErrInvalidPool, c.SetMode("defualt", ModeReadOnly))
+	assert.Equal(t, 3, len(c.Modes()))
+}
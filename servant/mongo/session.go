@@ -32,7 +32,7 @@ func (this *Session) Recyle(err *error) {
 
 func (this *Session) resumableError(err error) bool {
 	switch err {
-	case nil, mgo.ErrNotFound:
+	case nil, mgo.ErrNotFound, ErrTooManyResults:
 		return true
 	}
 
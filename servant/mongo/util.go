@@ -15,6 +15,17 @@ func UnmarshalIn(d []byte) (v bson.M, err error) {
 	return
 }
 
+// UnmarshalInD keeps the order of fields, nested docs included, where
+// it matters, e,g. compound $sort of an aggregation pipeline.
+func UnmarshalInD(d []byte) (v bson.D, err error) {
+	err = bson.Unmarshal(d, &v)
+	if err != nil {
+		log.Error("mongo.unmarshalInD: %s -> %s", d, err)
+	}
+
+	return
+}
+
 // specs: outbound data use bson
 func MarshalOut(d bson.M) []byte {
 	val, err := bson.Marshal(d)
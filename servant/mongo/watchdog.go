@@ -3,10 +3,20 @@ package mongo
 import (
 	log "github.com/funkygao/log4go"
 	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
 	"sync"
 	"time"
 )
 
+// Health of a replica set member reported by replSetGetStatus.
+type MemberStatus struct {
+	Name       string    `bson:"name"`     // host:port
+	State      string    `bson:"stateStr"` // PRIMARY, SECONDARY, ...
+	Health     float64   `bson:"health"`   // 1 up, 0 down
+	PingMs     int       `bson:"pingMs"`
+	OptimeDate time.Time `bson:"optimeDate"`
+}
+
 func (this *Client) runWatchdog() {
 	if this.conf.HeartbeatInterval == 0 {
 		return
@@ -27,6 +37,8 @@ func (this *Client) runWatchdog() {
 		}
 		this.lk.Unlock()
 		wg.Wait()
+
+		this.checkReplicaSets()
 	}
 
 }
@@ -42,3 +54,52 @@ func (this *Client) checkServerStatus(wg *sync.WaitGroup, sess *mgo.Session) {
 		this.killConn(sess)
 	}
 }
+
+// checkReplicaSets refreshes member health of each replica set pool.
+func (this *Client) checkReplicaSets() {
+	for _, server := range this.selector.ServerList() {
+		if server.ReplicaSet == "" {
+			continue
+		}
+
+		sess, err := this.getConn(server.Uri())
+		if err != nil {
+			log.Error("mongodb[%s] replica set: %s", server.Pool, err)
+			continue
+		}
+
+		var status struct {
+			Members []MemberStatus `bson:"members"`
+		}
+		err = sess.Run(bson.D{{"replSetGetStatus", 1}}, &status)
+		if err != nil {
+			log.Error("mongodb[%s] replSetGetStatus: %s", server.Pool, err)
+			sess.Close()
+			continue
+		}
+		this.putFreeConn(server.Uri(), sess)
+
+		for _, member := range status.Members {
+			if member.Health == 0 {
+				log.Warn("mongodb[%s] member down: %s %s", server.Pool,
+					member.Name, member.State)
+			}
+		}
+
+		this.membersLock.Lock()
+		this.members[server.Pool] = status.Members
+		this.membersLock.Unlock()
+	}
+}
+
+// Members returns the latest member health of replica set pools.
+func (this *Client) Members() map[string][]MemberStatus {
+	this.membersLock.RLock()
+	defer this.membersLock.RUnlock()
+
+	r := make(map[string][]MemberStatus, len(this.members))
+	for pool, members := range this.members {
+		r[pool] = members
+	}
+	return r
+}
@@ -37,8 +37,12 @@ func (this *MysqlCluster) Conn(pool string, table string,
 	return my.db, nil
 }
 
+// QueryShards runs sql on all shards of a pool and merges the rows.
+// Each row is passed to check before merged, and the query stops once
+// check fails.
 func (this *MysqlCluster) QueryShards(pool string, table string, sql string,
-	args []interface{}) (cols []string, rows [][]string, ex error) {
+	args []interface{}, check func([]string) error) (cols []string,
+	rows [][]string, ex error) {
 	rows = make([][]string, 0)
 	var (
 		rawRowValues []sql_.RawBytes
@@ -84,6 +88,11 @@ func (this *MysqlCluster) QueryShards(pool string, table string, sql string,
 				}
 			}
 
+			if ex = check(rowValues); ex != nil {
+				rs.Close()
+				return
+			}
+
 			rows = append(rows, rowValues)
 		}
 
@@ -125,6 +134,16 @@ func (this *MysqlCluster) Close() (err error) {
 	return
 }
 
+// BreakerStates tells whether circuit breaker of each server is open,
+// key is the dsn without credentials.
+func (this *MysqlCluster) BreakerStates() map[string]bool {
+	r := make(map[string]bool)
+	for _, my := range this.selector.Servers() {
+		r[my.addr()] = my.breaker.Open()
+	}
+	return r
+}
+
 func (this *MysqlCluster) KickLookupCache(pool string, hintId int) {
 	this.selector.KickLookupCache(pool, hintId)
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/funkygao/golib/cache"
 	log "github.com/funkygao/log4go"
 	_ "github.com/funkygao/mysql"
+	"strings"
 	"sync"
 	"time"
 )
@@ -64,6 +65,14 @@ func (this *mysql) String() string {
 	return this.dsn
 }
 
+// addr is the dsn without user and password.
+func (this *mysql) addr() string {
+	if i := strings.LastIndex(this.dsn, "@"); i >= 0 {
+		return this.dsn[i+1:]
+	}
+	return this.dsn
+}
+
 func (this *mysql) Query(query string, args ...interface{}) (rows *sql.Rows,
 	err error) {
 	if this.db == nil {
@@ -39,3 +39,47 @@ func (this *FunServantImpl) peerServantRand(ctx *rpc.Context) (
 	svt.HijackContext(ctx)
 	return svt, nil
 }
+
+// cachePeerByKey routes a cacheable call to the peer that owns cacheKey.
+// Returns nil if the call should be served by self: no cacheKey, self is
+// the owner, or the call is already routed from a peer.
+func (this *FunServantImpl) cachePeerByKey(ctx *rpc.Context,
+	cacheKey string) (*proxy.FunServantPeer, error) {
+	if cacheKey == "" {
+		return nil, nil
+	}
+
+	if ctx.IsSetSticky() && *ctx.Sticky {
+		svtStats.incPeerCall()
+		return nil, nil
+	}
+
+	svt, err := this.proxy.ServantByKey(cacheKey)
+	if err != nil {
+		if svt != nil {
+			if proxy.IsIoError(err) {
+				svt.Close()
+			}
+			svt.Recycle()
+		}
+		return nil, err
+	}
+
+	if svt == proxy.Self {
+		return nil, nil
+	}
+
+	svtStats.incCallPeer()
+	svt.HijackContext(ctx)
+	return svt, nil
+}
+
+// recyclePeer returns the peer conn back to pool after a routed call.
+func recyclePeer(svt *proxy.FunServantPeer, ex error) {
+	if ex != nil && proxy.IsIoError(ex) {
+		svt.Close()
+	}
+
+	svt.Done(ex)
+	svt.Recycle() // NEVER forget about this
+}
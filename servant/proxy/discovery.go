@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"bufio"
+	"github.com/funkygao/etclib"
+	"github.com/funkygao/fae/config"
+	log "github.com/funkygao/log4go"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Discovery finds fae peers of the cluster.
+type Discovery interface {
+	// Watch sends latest peers addr, self inclusive, on each topology
+	// change. It never returns.
+	Watch(peersChan chan<- []string)
+}
+
+func newDiscovery(cf *config.ConfigProxy) Discovery {
+	switch cf.Discovery {
+	case "static":
+		return &staticDiscovery{selfAddr: cf.SelfAddr, peers: cf.StaticPeers}
+
+	case "file":
+		return &fileDiscovery{selfAddr: cf.SelfAddr, path: cf.DiscoveryFile,
+			interval: cf.DiscoveryFileInterval}
+
+	case "gossip":
+		// the gossip node is injected by SetDiscovery
+		return nil
+
+	default:
+		return &etcdDiscovery{}
+	}
+}
+
+// etcdDiscovery watches fae service registered in etcd.
+type etcdDiscovery struct{}
+
+func (this *etcdDiscovery) Watch(peersChan chan<- []string) {
+	ch := make(chan []string, 10)
+	go etclib.WatchService(etclib.SERVICE_FAE, ch)
+
+	for _ = range ch {
+		peers, err := etclib.ServiceEndpoints(etclib.SERVICE_FAE)
+		if err != nil {
+			log.Error("Cluster peers: %s", err)
+			continue
+		}
+
+		peersChan <- peers
+	}
+}
+
+// staticDiscovery is a fixed cluster from config.
+type staticDiscovery struct {
+	selfAddr string
+	peers    []string
+}
+
+func (this *staticDiscovery) Watch(peersChan chan<- []string) {
+	peersChan <- withSelf(this.selfAddr, this.peers)
+	select {} // never changes
+}
+
+// fileDiscovery polls a local file with a peer addr per line, '#' starts
+// a comment. The file is reloaded whenever its mtime changes.
+type fileDiscovery struct {
+	selfAddr string
+	path     string
+	interval time.Duration
+	mtime    time.Time
+}
+
+func (this *fileDiscovery) Watch(peersChan chan<- []string) {
+	ticker := time.NewTicker(this.interval)
+	defer ticker.Stop()
+
+	for {
+		if peers, changed := this.reload(); changed {
+			peersChan <- peers
+		}
+
+		<-ticker.C
+	}
+}
+
+func (this *fileDiscovery) reload() (peers []string, changed bool) {
+	stat, err := os.Stat(this.path)
+	if err != nil {
+		log.Error("Cluster peers file: %s", err)
+		return
+	}
+
+	if stat.ModTime().Equal(this.mtime) {
+		return
+	}
+
+	f, err := os.Open(this.path)
+	if err != nil {
+		log.Error("Cluster peers file: %s", err)
+		return
+	}
+	defer f.Close()
+
+	if peers, err = parsePeers(f); err != nil {
+		log.Error("Cluster peers file: %s", err)
+		return
+	}
+
+	this.mtime = stat.ModTime()
+	return withSelf(this.selfAddr, peers), true
+}
+
+func parsePeers(r io.Reader) (peers []string, err error) {
+	peers = make([]string, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		if line = strings.TrimSpace(line); line != "" {
+			peers = append(peers, line)
+		}
+	}
+
+	return peers, scanner.Err()
+}
+
+// withSelf makes sure self is a member of the cluster.
+func withSelf(selfAddr string, peers []string) []string {
+	r := make([]string, 0, len(peers)+1)
+	for _, addr := range peers {
+		if addr != selfAddr {
+			r = append(r, addr)
+		}
+	}
+
+	return append(r, selfAddr)
+}
@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"github.com/funkygao/assert"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePeers(t *testing.T) {
+	peers, err := parsePeers(strings.NewReader(`# fae cluster
+127.0.0.1:9001
+  127.0.0.1:9011  # 2nd
+
+127.0.0.1:9021`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"127.0.0.1:9001", "127.0.0.1:9011",
+		"127.0.0.1:9021"}, peers)
+}
+
+func TestStaticDiscovery(t *testing.T) {
+	d := &staticDiscovery{selfAddr: "127.0.0.1:9001",
+		peers: []string{"127.0.0.1:9001", "127.0.0.1:9011"}}
+	peersChan := make(chan []string, 1)
+	go d.Watch(peersChan)
+	assert.Equal(t, []string{"127.0.0.1:9011", "127.0.0.1:9001"},
+		<-peersChan)
+}
+
+func TestFileDiscoveryReload(t *testing.T) {
+	f, err := ioutil.TempFile("", "peers")
+	assert.Equal(t, nil, err)
+	defer os.Remove(f.Name())
+	f.WriteString("127.0.0.1:9011\n")
+	f.Close()
+
+	d := &fileDiscovery{selfAddr: "127.0.0.1:9001", path: f.Name(),
+		interval: time.Second}
+	peers, changed := d.reload()
+	assert.Equal(t, true, changed)
+	assert.Equal(t, []string{"127.0.0.1:9011", "127.0.0.1:9001"}, peers)
+
+	_, changed = d.reload()
+	assert.Equal(t, false, changed)
+
+	ioutil.WriteFile(f.Name(), []byte("127.0.0.1:9021\n"), 0644)
+	mtime := time.Now().Add(time.Second)
+	os.Chtimes(f.Name(), mtime, mtime)
+	peers, changed = d.reload()
+	assert.Equal(t, true, changed)
+	assert.Equal(t, []string{"127.0.0.1:9021", "127.0.0.1:9001"}, peers)
+}
@@ -0,0 +1,13 @@
+package proxy
+
+import (
+	"errors"
+)
+
+var (
+	ErrPeerNotFound   = errors.New("proxy: peer not found")
+	ErrPeerEjected    = errors.New("proxy: owner peer ejected")
+	ErrMuxTimeout     = errors.New("proxy: mux call i/o timeout")
+	ErrMuxFrameTooBig = errors.New("proxy: mux frame too big")
+	ErrMuxNoCall      = errors.New("proxy: mux read without call")
+)
@@ -0,0 +1,324 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/funkygao/fae/config"
+	log "github.com/funkygao/log4go"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// muxConn is a conn to a remote peer shared by concurrent calls.
+// Calls are written without waiting for replies of previous ones, and
+// replies are dispatched to callers by seq.
+type muxConn struct {
+	peerAddr  string
+	conn      net.Conn
+	ioTimeout time.Duration
+
+	wmutex sync.Mutex // serializes frames on the wire
+	w      *bufio.Writer
+
+	mutex   sync.Mutex
+	seq     int32
+	pending map[int32]chan []byte // key is seq
+	err     error                 // why the conn is broken
+}
+
+func dialMux(peerAddr string, cf *config.ConfigProxy) (*muxConn, error) {
+	conn, err := net.DialTimeout("tcp", peerAddr, cf.IoTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetNoDelay(cf.TcpNoDelay)
+		// a timed out call leaves the conn open, keepalive finds dead peers
+		tcpConn.SetKeepAlive(true)
+	}
+
+	this := &muxConn{
+		peerAddr:  peerAddr,
+		conn:      conn,
+		ioTimeout: cf.IoTimeout,
+		w:         bufio.NewWriterSize(conn, cf.BufferSize),
+		pending:   make(map[int32]chan []byte),
+	}
+
+	this.w.WriteString(MuxMagic)
+	if err = this.w.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go this.readLoop(bufio.NewReaderSize(conn, cf.BufferSize))
+	return this, nil
+}
+
+// call sends the payload and returns chan of the reply, which is closed
+// if the conn breaks before the reply arrives.
+func (this *muxConn) call(payload []byte) (seq int32, reply chan []byte,
+	err error) {
+	this.mutex.Lock()
+	if this.err != nil {
+		err = this.err
+		this.mutex.Unlock()
+		return
+	}
+
+	this.seq++
+	seq = this.seq
+	reply = make(chan []byte, 1) // readLoop never blocks
+	this.pending[seq] = reply
+	this.mutex.Unlock()
+
+	this.wmutex.Lock()
+	if this.ioTimeout > 0 {
+		this.conn.SetWriteDeadline(time.Now().Add(this.ioTimeout))
+	}
+	if err = WriteMuxFrame(this.w, seq, payload); err == nil {
+		err = this.w.Flush()
+	}
+	this.wmutex.Unlock()
+
+	if err != nil {
+		this.close(err)
+	}
+	return
+}
+
+// cancel forgets a call whose reply is no longer awaited, a late reply
+// is dropped.
+func (this *muxConn) cancel(seq int32) {
+	this.mutex.Lock()
+	delete(this.pending, seq)
+	this.mutex.Unlock()
+}
+
+func (this *muxConn) readLoop(r *bufio.Reader) {
+	for {
+		seq, payload, err := ReadMuxFrame(r)
+		if err != nil {
+			this.close(err)
+			return
+		}
+
+		this.mutex.Lock()
+		reply, present := this.pending[seq]
+		delete(this.pending, seq)
+		this.mutex.Unlock()
+
+		if present {
+			reply <- payload
+		}
+	}
+}
+
+// close fails all outstanding calls with err.
+func (this *muxConn) close(err error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.err != nil {
+		return
+	}
+
+	log.Debug("peer[%s] mux conn closed with %d pending: %s",
+		this.peerAddr, len(this.pending), err)
+
+	this.err = err
+	for seq, reply := range this.pending {
+		close(reply)
+		delete(this.pending, seq)
+	}
+	this.conn.Close()
+}
+
+func (this *muxConn) broken() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.err
+}
+
+func (this *muxConn) outstanding() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return len(this.pending)
+}
+
+// muxStream is the thrift transport of a FunServantPeer in mux mode.
+// It carries a single call at a time: Flush sends the call, and Read
+// awaits its reply.
+type muxStream struct {
+	conn      *muxConn
+	ioTimeout time.Duration
+
+	wbuf   bytes.Buffer
+	rbuf   *bytes.Reader // reply of the last call
+	seq    int32
+	reply  chan []byte // nil if no call awaiting reply
+	closed bool
+}
+
+func newMuxStream(conn *muxConn, ioTimeout time.Duration) *muxStream {
+	return &muxStream{conn: conn, ioTimeout: ioTimeout}
+}
+
+func (this *muxStream) Open() error {
+	return nil
+}
+
+func (this *muxStream) IsOpen() bool {
+	return !this.closed && this.conn.broken() == nil
+}
+
+func (this *muxStream) Close() error {
+	if this.reply != nil {
+		this.conn.cancel(this.seq)
+		this.reply = nil
+	}
+	this.closed = true
+	return nil
+}
+
+func (this *muxStream) Write(p []byte) (int, error) {
+	return this.wbuf.Write(p)
+}
+
+func (this *muxStream) Flush() (err error) {
+	this.seq, this.reply, err = this.conn.call(this.wbuf.Bytes())
+	this.wbuf.Reset()
+	this.rbuf = nil
+	return
+}
+
+func (this *muxStream) Read(p []byte) (int, error) {
+	if this.rbuf == nil {
+		if err := this.await(); err != nil {
+			return 0, err
+		}
+	}
+
+	return this.rbuf.Read(p)
+}
+
+func (this *muxStream) await() error {
+	if this.reply == nil {
+		return ErrMuxNoCall
+	}
+
+	var timeout <-chan time.Time
+	if this.ioTimeout > 0 {
+		timer := time.NewTimer(this.ioTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case payload, ok := <-this.reply:
+		this.reply = nil
+		if !ok {
+			return this.conn.broken()
+		}
+
+		this.rbuf = bytes.NewReader(payload)
+		return nil
+
+	case <-timeout:
+		this.conn.cancel(this.seq)
+		this.reply = nil
+		return ErrMuxTimeout
+	}
+}
+
+// muxPool holds MuxConns conns to a remote peer, calls are spread over
+// them round robin. Broken conns are redialed on demand.
+type muxPool struct {
+	peerAddr string
+	cf       *config.ConfigProxy
+
+	slots []*muxSlot
+	next  uint32
+	calls int64
+}
+
+type muxSlot struct {
+	sync.Mutex
+	conn *muxConn
+}
+
+func newMuxPool(peerAddr string, cf *config.ConfigProxy) *muxPool {
+	this := &muxPool{
+		peerAddr: peerAddr,
+		cf:       cf,
+		slots:    make([]*muxSlot, cf.MuxConns),
+	}
+	for i := range this.slots {
+		this.slots[i] = &muxSlot{}
+	}
+	return this
+}
+
+func (this *muxPool) stream() (*muxStream, error) {
+	i := atomic.AddUint32(&this.next, 1) % uint32(len(this.slots))
+	conn, err := this.conn(this.slots[i])
+	if err != nil {
+		return nil, err
+	}
+
+	atomic.AddInt64(&this.calls, 1)
+	return newMuxStream(conn, this.cf.IoTimeout), nil
+}
+
+func (this *muxPool) conn(slot *muxSlot) (*muxConn, error) {
+	slot.Lock()
+	defer slot.Unlock()
+
+	if slot.conn != nil && slot.conn.broken() == nil {
+		return slot.conn, nil
+	}
+
+	conn, err := dialMux(this.peerAddr, this.cf)
+	if err != nil {
+		log.Error("connect peer[%s]: %s", this.peerAddr, err)
+		return nil, err
+	}
+
+	log.Debug("peer[%s] mux connected", this.peerAddr)
+	slot.conn = conn
+	return conn, nil
+}
+
+func (this *muxPool) close() {
+	for _, slot := range this.slots {
+		slot.Lock()
+		if slot.conn != nil {
+			slot.conn.close(ErrPeerNotFound)
+			slot.conn = nil
+		}
+		slot.Unlock()
+	}
+}
+
+func (this *muxPool) StatsJSON() string {
+	var conns, outstanding int
+	for _, slot := range this.slots {
+		slot.Lock()
+		if slot.conn != nil && slot.conn.broken() == nil {
+			conns++
+			outstanding += slot.conn.outstanding()
+		}
+		slot.Unlock()
+	}
+
+	b, _ := json.Marshal(map[string]interface{}{
+		"conns":       conns,
+		"outstanding": outstanding,
+		"calls":       atomic.LoadInt64(&this.calls),
+	})
+	return string(b)
+}
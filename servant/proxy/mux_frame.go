@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Wire format of peer mux conns.
+//
+// A mux conn starts with MuxMagic sent by the caller, followed by frames
+// in both directions:
+//
+//	[payload size uint32][seq int32][payload]
+//
+// The payload is a thrift binary protocol message. A reply frame carries
+// seq of its call, replies can be out of order.
+const (
+	MuxMagic        = "FMUX"
+	MaxMuxFrameSize = 64 << 20
+
+	muxHeaderSize = 8
+)
+
+func WriteMuxFrame(w io.Writer, seq int32, payload []byte) error {
+	var header [muxHeaderSize]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:], uint32(seq))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+
+	_, err := w.Write(payload)
+	return err
+}
+
+func ReadMuxFrame(r io.Reader) (seq int32, payload []byte, err error) {
+	var header [muxHeaderSize]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+
+	size := binary.BigEndian.Uint32(header[:4])
+	if size > MaxMuxFrameSize {
+		err = ErrMuxFrameTooBig
+		return
+	}
+
+	seq = int32(binary.BigEndian.Uint32(header[4:]))
+	payload = make([]byte, size)
+	_, err = io.ReadFull(r, payload)
+	return
+}
@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/funkygao/assert"
+	"github.com/funkygao/fae/config"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runMuxEcho is a stand-in peer which echoes each frame after a delay
+// decided by the payload, so replies are out of order.
+func runMuxEcho(t *testing.T, delay func(payload []byte) time.Duration) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				magic := make([]byte, len(MuxMagic))
+				if _, err := io.ReadFull(conn, magic); err != nil ||
+					string(magic) != MuxMagic {
+					return
+				}
+
+				var wmutex sync.Mutex
+				for {
+					seq, payload, err := ReadMuxFrame(conn)
+					if err != nil {
+						return
+					}
+
+					go func(seq int32, payload []byte) {
+						time.Sleep(delay(payload))
+						wmutex.Lock()
+						WriteMuxFrame(conn, seq, payload)
+						wmutex.Unlock()
+					}(seq, payload)
+				}
+			}(conn)
+		}
+	}()
+
+	return l
+}
+
+func muxCall(stream *muxStream, payload string) (string, error) {
+	stream.Write([]byte(payload))
+	if err := stream.Flush(); err != nil {
+		return "", err
+	}
+
+	reply, err := ioutil.ReadAll(stream)
+	return string(reply), err
+}
+
+func TestMuxFrame(t *testing.T) {
+	var buf bytes.Buffer
+	WriteMuxFrame(&buf, 12, []byte("hello"))
+	WriteMuxFrame(&buf, -1, nil)
+
+	seq, payload, err := ReadMuxFrame(&buf)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int32(12), seq)
+	assert.Equal(t, "hello", string(payload))
+
+	seq, payload, err = ReadMuxFrame(&buf)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int32(-1), seq)
+	assert.Equal(t, 0, len(payload))
+
+	_, _, err = ReadMuxFrame(&buf)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestMuxOutOfOrder(t *testing.T) {
+	l := runMuxEcho(t, func(payload []byte) time.Duration {
+		// earlier calls reply later
+		return time.Duration(100-len(payload)) * time.Millisecond
+	})
+	defer l.Close()
+
+	cf := config.NewDefaultProxy()
+	cf.MuxConns = 1
+	pool := newMuxPool(l.Addr().String(), cf)
+	defer pool.close()
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			stream, err := pool.stream()
+			assert.Equal(t, nil, err)
+			defer stream.Close()
+
+			payload := fmt.Sprintf("%0*d", i, i)
+			for j := 0; j < 2; j++ {
+				reply, err := muxCall(stream, payload)
+				assert.Equal(t, nil, err)
+				assert.Equal(t, payload, reply)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 0, pool.slots[0].conn.outstanding())
+}
+
+func TestMuxTimeoutAndBroken(t *testing.T) {
+	l := runMuxEcho(t, func(payload []byte) time.Duration {
+		if string(payload) == "slow" {
+			return time.Second
+		}
+		return 0
+	})
+
+	cf := config.NewDefaultProxy()
+	cf.IoTimeout = time.Millisecond * 200
+	pool := newMuxPool(l.Addr().String(), cf)
+	defer pool.close()
+
+	stream, _ := pool.stream()
+	_, err := muxCall(stream, "slow")
+	assert.Equal(t, ErrMuxTimeout, err)
+	assert.Equal(t, true, IsTimeout(err))
+
+	// a timed out call does not break the conn
+	reply, err := muxCall(stream, "fast")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "fast", reply)
+
+	// peer gone away
+	conn := stream.conn
+	l.Close()
+	conn.conn.(*net.TCPConn).CloseRead()
+	time.Sleep(time.Millisecond * 50)
+	assert.NotEqual(t, nil, conn.broken())
+	assert.Equal(t, false, stream.IsOpen())
+	_, err = muxCall(stream, "fast")
+	assert.NotEqual(t, nil, err)
+}
@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"github.com/funkygao/fae/config"
+	log "github.com/funkygao/log4go"
+	"sync"
+	"time"
+)
+
+type peerHealth struct {
+	failures  int // consecutive
+	lastErr   string
+	ejected   bool
+	ejections int       // consecutive, backoff doubles on each
+	ejectedN  int64     // cumulated
+	retryAt   time.Time // ejected at least till then
+	trial     bool      // a trial request is let through after retryAt
+}
+
+// outliers detects unhealthy remote peers by consecutive io errors or
+// timeouts of calls and probes, and ejects them from peer selection.
+//
+// An ejected peer recovers on the first success after retryAt, each
+// failure after retryAt doubles the backoff. Once retryAt has passed, a
+// single request is let through as a trial, so that a peer recovers even
+// without health check.
+type outliers struct {
+	cf *config.ConfigProxy
+
+	mutex sync.Mutex
+	peers map[string]*peerHealth // key is peerAddr, self not inclusive
+}
+
+func newOutliers(cf *config.ConfigProxy) *outliers {
+	return &outliers{cf: cf, peers: make(map[string]*peerHealth)}
+}
+
+// setPeers forgets peers gone away from the cluster.
+func (this *outliers) setPeers(peerAddrs []string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	alive := make(map[string]bool, len(peerAddrs))
+	for _, addr := range peerAddrs {
+		if addr == this.cf.SelfAddr {
+			continue
+		}
+
+		alive[addr] = true
+		if _, present := this.peers[addr]; !present {
+			this.peers[addr] = &peerHealth{}
+		}
+	}
+
+	for addr := range this.peers {
+		if !alive[addr] {
+			delete(this.peers, addr)
+		}
+	}
+}
+
+// report records result of a call, borrow or probe on the peer, any
+// err counts as a failure.
+func (this *outliers) report(peerAddr string, err error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	h, present := this.peers[peerAddr]
+	if !present {
+		return
+	}
+
+	now := time.Now()
+	if err == nil {
+		h.failures = 0
+		if h.ejected && (h.trial || now.After(h.retryAt)) {
+			h.ejected = false
+			h.ejections = 0
+			h.trial = false
+			log.Info("peer[%s] recovered", peerAddr)
+		}
+		return
+	}
+
+	h.failures++
+	h.lastErr = err.Error()
+	switch {
+	case h.ejected && h.trial:
+		// backoff was extended when the trial was let through
+		h.trial = false
+
+	case h.ejected && now.After(h.retryAt):
+		// still broken
+		h.retryAt = now.Add(this.backoff(h.ejections))
+		h.ejections++
+
+	case !h.ejected && h.failures >= this.cf.EjectConsecutiveFailures:
+		if !this.canEject() {
+			log.Warn("peer[%s] unhealthy but max ejection reached: %s",
+				peerAddr, h.lastErr)
+			return
+		}
+
+		h.ejected = true
+		h.ejectedN++
+		h.retryAt = now.Add(this.backoff(h.ejections))
+		h.ejections++
+		log.Warn("peer[%s] ejected till %s: %s", peerAddr, h.retryAt,
+			h.lastErr)
+	}
+}
+
+// isEjected tells whether requests should skip the peer. Once retryAt of
+// an ejected peer has passed, the caller gets false as the trial, and
+// others keep skipping it till the trial is reported or the extended
+// backoff passes again.
+func (this *outliers) isEjected(peerAddr string) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	h, present := this.peers[peerAddr]
+	if !present || !h.ejected {
+		return false
+	}
+
+	now := time.Now()
+	if now.Before(h.retryAt) {
+		return true
+	}
+
+	h.trial = true
+	h.retryAt = now.Add(this.backoff(h.ejections))
+	h.ejections++
+	return false
+}
+
+// canEject always allows a single ejection, so that small clusters, e,g.
+// of a remote peer only, are protected too.
+// MUST be called with mutex held.
+func (this *outliers) canEject() bool {
+	ejected := 0
+	for _, h := range this.peers {
+		if h.ejected {
+			ejected++
+		}
+	}
+	if ejected == 0 {
+		return true
+	}
+
+	ejected++ // the one to eject
+	return ejected*100 <= len(this.peers)*this.cf.MaxEjectionPercent
+}
+
+func (this *outliers) backoff(ejections int) time.Duration {
+	wait := this.cf.EjectBackoff
+	for i := 0; i < ejections && wait < this.cf.EjectMaxBackoff; i++ {
+		wait *= 2
+	}
+
+	if wait > this.cf.EjectMaxBackoff {
+		wait = this.cf.EjectMaxBackoff
+	}
+	return wait
+}
+
+func (this *outliers) stats(peerAddr string) map[string]interface{} {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	h, present := this.peers[peerAddr]
+	if !present {
+		return nil
+	}
+
+	r := map[string]interface{}{
+		"ejected":   h.ejected,
+		"ejections": h.ejectedN,
+		"failures":  h.failures,
+		"last_err":  h.lastErr,
+	}
+	if h.ejected {
+		r["retry_at"] = h.retryAt.String()
+	}
+	return r
+}
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"github.com/funkygao/assert"
+	"github.com/funkygao/fae/config"
+	"testing"
+	"time"
+)
+
+func TestOutlierEjection(t *testing.T) {
+	cf := config.NewDefaultProxy()
+	cf.SelfAddr = "10.0.0.1:9001"
+	cf.EjectConsecutiveFailures = 3
+	cf.MaxEjectionPercent = 50
+	o := newOutliers(cf)
+	o.setPeers([]string{"10.0.0.1:9001", "10.0.0.2:9001", "10.0.0.3:9001"})
+
+	ioErr := errors.New("broken pipe")
+	o.report("10.0.0.2:9001", ioErr)
+	o.report("10.0.0.2:9001", ioErr)
+	o.report("10.0.0.2:9001", nil) // success resets
+	o.report("10.0.0.2:9001", ioErr)
+	o.report("10.0.0.2:9001", ioErr)
+	assert.Equal(t, false, o.isEjected("10.0.0.2:9001"))
+	o.report("10.0.0.2:9001", ioErr)
+	assert.Equal(t, true, o.isEjected("10.0.0.2:9001"))
+
+	// at most 50% of 2 remote peers
+	for i := 0; i < 3; i++ {
+		o.report("10.0.0.3:9001", ioErr)
+	}
+	assert.Equal(t, false, o.isEjected("10.0.0.3:9001"))
+
+	// success within backoff won't recover
+	o.report("10.0.0.2:9001", nil)
+	assert.Equal(t, true, o.isEjected("10.0.0.2:9001"))
+
+	// failure after backoff doubles it
+	o.peers["10.0.0.2:9001"].retryAt = time.Now()
+	o.report("10.0.0.2:9001", ioErr)
+	assert.Equal(t, true, o.isEjected("10.0.0.2:9001"))
+	assert.Equal(t, 2, o.peers["10.0.0.2:9001"].ejections)
+	assert.Equal(t, true, o.peers["10.0.0.2:9001"].retryAt.After(
+		time.Now().Add(cf.EjectBackoff)))
+
+	o.peers["10.0.0.2:9001"].retryAt = time.Now()
+	o.report("10.0.0.2:9001", nil)
+	assert.Equal(t, false, o.isEjected("10.0.0.2:9001"))
+	assert.Equal(t, int64(1), o.stats("10.0.0.2:9001")["ejections"])
+
+	// without health check, a single trial request after backoff
+	for i := 0; i < 3; i++ {
+		o.report("10.0.0.2:9001", ioErr)
+	}
+	assert.Equal(t, true, o.isEjected("10.0.0.2:9001"))
+	o.peers["10.0.0.2:9001"].retryAt = time.Now()
+	assert.Equal(t, false, o.isEjected("10.0.0.2:9001")) // the trial
+	assert.Equal(t, true, o.isEjected("10.0.0.2:9001"))
+	o.report("10.0.0.2:9001", ioErr) // trial failed
+	assert.Equal(t, true, o.isEjected("10.0.0.2:9001"))
+
+	o.peers["10.0.0.2:9001"].retryAt = time.Now()
+	assert.Equal(t, false, o.isEjected("10.0.0.2:9001"))
+	o.report("10.0.0.2:9001", nil) // trial succeeded
+	assert.Equal(t, false, o.isEjected("10.0.0.2:9001"))
+	assert.Equal(t, 0, o.peers["10.0.0.2:9001"].ejections)
+
+	// gone peers are forgotten
+	o.setPeers([]string{"10.0.0.1:9001", "10.0.0.3:9001"})
+	assert.Equal(t, 1, len(o.peers))
+
+	// a single remote peer can be ejected regardless of the percent
+	for i := 0; i < 3; i++ {
+		o.report("10.0.0.3:9001", ioErr)
+	}
+	assert.Equal(t, true, o.isEjected("10.0.0.3:9001"))
+}
+
+func TestEjectedFallback(t *testing.T) {
+	peers := []string{"10.0.0.1:9001", "10.0.0.2:9001", "10.0.0.3:9001"}
+	s := newConsistentPeerSelector(160, nil)
+	s.SetPeersAddr(peers...)
+
+	ejected := "10.0.0.2:9001"
+	removed := newConsistentPeerSelector(160, nil)
+	removed.SetPeersAddr("10.0.0.1:9001", "10.0.0.3:9001")
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("user:%d", i)
+		next := s.NextPeer(key, func(addr string) bool {
+			return addr != ejected
+		})
+
+		// keys go to the next peer, same as if it were removed
+		assert.Equal(t, removed.PickPeer(key), next)
+	}
+}
+
+func TestOwnerServantByKeyFailsClosed(t *testing.T) {
+	cf := config.NewDefaultProxy()
+	cf.SelfAddr = "10.0.0.1:9001"
+	cf.EjectConsecutiveFailures = 1
+	cf.EjectedFallback = "local"
+	peers := []string{"10.0.0.1:9001", "10.0.0.2:9001"}
+	p := &Proxy{
+		cf:              cf,
+		selector:        newStandardPeerSelector(),
+		outliers:        newOutliers(cf),
+		remotePeerPools: make(map[string]*funServantPeerPool),
+	}
+	p.selector.SetPeersAddr(peers...)
+	p.outliers.setPeers(peers)
+
+	var key string
+	for i := 0; ; i++ {
+		if key = fmt.Sprintf("lock:%d", i); p.selector.PickPeer(key) == peers[1] {
+			break
+		}
+	}
+	p.outliers.report(peers[1], errors.New("broken pipe"))
+	assert.Equal(t, true, p.outliers.isEjected(peers[1]))
+
+	// cache style keys are served locally, locks are not
+	svt, err := p.ServantByKey(key)
+	assert.Equal(t, Self, svt)
+	assert.Equal(t, nil, err)
+	_, err = p.OwnerServantByKey(key)
+	assert.Equal(t, ErrPeerEjected, err)
+}
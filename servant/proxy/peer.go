@@ -42,6 +42,12 @@ func (this *FunServantPeer) IsOpen() bool {
 }
 
 func (this *FunServantPeer) Recycle() {
+	if this.pool.mux != nil {
+		// mux servant is per call, the conns underneath are shared
+		this.Transport.Close()
+		return
+	}
+
 	if this.Transport.IsOpen() {
 		this.pool.pool.Put(this)
 	} else {
@@ -50,6 +56,16 @@ func (this *FunServantPeer) Recycle() {
 	}
 }
 
+// Done reports result of a call on this conn for outlier detection.
+// Errors other than io errors and timeouts mean the peer is responsive.
+func (this *FunServantPeer) Done(err error) {
+	if err != nil && !IsIoError(err) && !IsTimeout(err) {
+		err = nil
+	}
+
+	this.pool.outliers.report(this.pool.peerAddr, err)
+}
+
 func (this *FunServantPeer) NewContext(reason string, uid int64) *rpc.Context {
 	ctx := rpc.NewContext()
 	ctx.Rid = this.pool.nextTxn() + time.Now().UnixNano() // roughly unique, maybe enough
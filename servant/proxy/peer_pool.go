@@ -17,26 +17,35 @@ type funServantPeerPool struct {
 
 	cf config.ConfigProxy
 
-	pool *pool.ResourcePool
+	pool     *pool.ResourcePool // pool transport
+	mux      *muxPool           // mux transport
+	outliers *outliers
 
 	nextServantId uint64 // each conn in this pool has an id
 	txn           int64
 }
 
 func newFunServantPeerPool(myIp string, peerAddr string,
-	cf config.ConfigProxy) (this *funServantPeerPool) {
+	cf config.ConfigProxy, outliers *outliers) (this *funServantPeerPool) {
 	this = &funServantPeerPool{
 		myIp:     myIp,
 		peerAddr: peerAddr,
 		cf:       cf,
+		outliers: outliers,
 	}
 	return
 }
 
 func (this *funServantPeerPool) Open() {
+	if this.cf.PeerTransport == "mux" {
+		this.mux = newMuxPool(this.peerAddr, &this.cf)
+		return
+	}
+
 	factory := func() (pool.Resource, error) {
 		client, err := this.connect(this.peerAddr)
 		if err != nil {
+			this.outliers.report(this.peerAddr, err)
 			return nil, err
 		}
 
@@ -52,18 +61,52 @@ func (this *funServantPeerPool) Open() {
 }
 
 func (this *funServantPeerPool) Close() {
+	if this.mux != nil {
+		this.mux.close()
+		return
+	}
+
 	this.pool.Close()
 }
 
 func (this *funServantPeerPool) Get() (*FunServantPeer, error) {
+	if this.mux != nil {
+		return this.getMux()
+	}
+
 	fun, err := this.pool.Get()
 	if err != nil {
+		// failed to connect or borrow timeout, only the former is reported
+		// by factory: a busy peer is not an outlier
 		return nil, err
 	}
 
 	return fun.(*FunServantPeer), nil
 }
 
+// getMux returns a servant whose calls share mux conns of the peer.
+func (this *funServantPeerPool) getMux() (*FunServantPeer, error) {
+	stream, err := this.mux.stream()
+	if err != nil {
+		// failed to connect
+		this.outliers.report(this.peerAddr, err)
+		return nil, err
+	}
+
+	client := rpc.NewFunServantClientFactory(stream,
+		thrift.NewTBinaryProtocolFactoryDefault())
+	return newFunServantPeer(atomic.AddUint64(&this.nextServantId, 1),
+		this, client), nil
+}
+
+func (this *funServantPeerPool) StatsJSON() string {
+	if this.mux != nil {
+		return this.mux.StatsJSON()
+	}
+
+	return this.pool.StatsJSON()
+}
+
 // connect to remote servant peer
 func (this *funServantPeerPool) connect(peerAddr string) (*rpc.FunServantClient,
 	error) {
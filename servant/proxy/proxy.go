@@ -2,7 +2,6 @@ package proxy
 
 import (
 	"encoding/json"
-	"github.com/funkygao/etclib"
 	"github.com/funkygao/fae/config"
 	"github.com/funkygao/fae/servant/gen-go/fun/rpc"
 	"github.com/funkygao/golib/ip"
@@ -21,9 +20,10 @@ type Proxy struct {
 	clusterTopologyReady bool
 	clusterTopologyChan  chan bool
 
-	testOnBorrow    func()                         // TODO
 	remotePeerPools map[string]*funServantPeerPool // key is peerAddr, self not inclusive
+	discovery       Discovery
 	selector        PeerSelector
+	outliers        *outliers
 }
 
 func New(cf *config.ConfigProxy) *Proxy {
@@ -31,7 +31,9 @@ func New(cf *config.ConfigProxy) *Proxy {
 	this := &Proxy{
 		cf:                   cf,
 		remotePeerPools:      make(map[string]*funServantPeerPool),
-		selector:             newStandardPeerSelector(),
+		discovery:            newDiscovery(cf),
+		selector:             newPeerSelector(cf),
+		outliers:             newOutliers(cf),
 		myIp:                 ips[0],
 		clusterTopologyReady: false,
 		clusterTopologyChan:  make(chan bool),
@@ -62,37 +64,47 @@ func (this *Proxy) Enabled() bool {
 	return this.cf.Enabled()
 }
 
+// SetDiscovery replaces discovery built from config, it MUST be called
+// before StartMonitorCluster.
+func (this *Proxy) SetDiscovery(discovery Discovery) {
+	this.discovery = discovery
+}
+
 func (this *Proxy) StartMonitorCluster() {
 	if !this.Enabled() {
 		log.Warn("servant proxy disabled by proxy config section")
 		return
 	}
 
+	if this.discovery == nil {
+		panic("proxy discovery not set: " + this.cf.Discovery)
+	}
+
 	peersChan := make(chan []string, 10)
-	go etclib.WatchService(etclib.SERVICE_FAE, peersChan)
+	go this.discovery.Watch(peersChan)
+
+	if this.cf.HealthCheckInterval > 0 {
+		go this.runHealthCheck()
+	}
 
 	for {
 		select {
-		case <-peersChan:
-			peers, err := etclib.ServiceEndpoints(etclib.SERVICE_FAE)
-			if err == nil {
-				if !this.clusterTopologyReady {
-					this.clusterTopologyReady = true
-					close(this.clusterTopologyChan)
-				}
-
-				if len(peers) == 0 {
-					// TODO panic?
-					log.Warn("Empty cluster fae peers")
-				} else {
-					// no lock, because running within 1 goroutine
-					this.selector.SetPeersAddr(peers...)
-					this.refreshPeers(peers)
+		case peers := <-peersChan:
+			if !this.clusterTopologyReady {
+				this.clusterTopologyReady = true
+				close(this.clusterTopologyChan)
+			}
 
-					log.Info("Cluster latest fae nodes: %+v", peers)
-				}
+			if len(peers) == 0 {
+				// TODO panic?
+				log.Warn("Empty cluster fae peers")
 			} else {
-				log.Error("Cluster peers: %s", err)
+				// no lock, because running within 1 goroutine
+				this.selector.SetPeersAddr(peers...)
+				this.outliers.setPeers(peers)
+				this.refreshPeers(peers)
+
+				log.Info("Cluster latest fae nodes: %+v", peers)
 			}
 		}
 	}
@@ -146,7 +158,7 @@ func (this *Proxy) addRemotePeerIfNecessary(peerAddr string) {
 
 	if _, present := this.remotePeerPools[peerAddr]; !present {
 		this.remotePeerPools[peerAddr] = newFunServantPeerPool(this.myIp,
-			peerAddr, *this.cf)
+			peerAddr, *this.cf, this.outliers)
 		this.remotePeerPools[peerAddr].Open()
 	}
 
@@ -179,15 +191,78 @@ func (this *Proxy) ServantByAddr(peerAddr string) (svt *FunServantPeer, err erro
 
 // sticky request to remote peer servant by key
 // return nil if I'm the servant for this key
+// Keys of an ejected peer fall back to another peer, so it only suits
+// cache style callers.
 func (this *Proxy) ServantByKey(key string) (svt *FunServantPeer, err error) {
+	peerAddr := this.pickPeer(key)
+	if peerAddr == this.cf.SelfAddr || peerAddr == "" {
+		// empty addr means no peers known yet, serve it myself
+		return nil, nil
+	}
+
+	svt, err = this.servantOf(peerAddr, key)
+	if err != nil && this.outliers.isEjected(peerAddr) {
+		// ejected during retries, fall back once
+		if peerAddr = this.pickPeer(key); peerAddr == this.cf.SelfAddr ||
+			peerAddr == "" {
+			return nil, nil
+		}
+
+		svt, err = this.servantOf(peerAddr, key)
+	}
+
+	return
+}
+
+// OwnerServantByKey is ServantByKey without the ejected fallback, for
+// keys that MUST have a single owner cluster wide, e,g. locks.
+// Each peer ejects on its own, so a fallback could serve the same key on
+// 2 peers at once. It fails closed with ErrPeerEjected instead.
+func (this *Proxy) OwnerServantByKey(key string) (svt *FunServantPeer,
+	err error) {
 	peerAddr := this.selector.PickPeer(key)
-	if peerAddr == this.cf.SelfAddr {
+	if peerAddr == this.cf.SelfAddr || peerAddr == "" {
 		return nil, nil
 	}
 
-	this.remotePeerPools[peerAddr].nextTxn()
+	if this.outliers.isEjected(peerAddr) {
+		return nil, ErrPeerEjected
+	}
+
+	return this.servantOf(peerAddr, key)
+}
+
+// pickPeer returns owner of the key, or its fallback if the owner is
+// ejected.
+func (this *Proxy) pickPeer(key string) string {
+	peerAddr := this.selector.PickPeer(key)
+	if peerAddr == "" || !this.outliers.isEjected(peerAddr) {
+		return peerAddr
+	}
+
+	if this.cf.EjectedFallback == "local" {
+		return this.cf.SelfAddr
+	}
+
+	return this.selector.NextPeer(key, func(addr string) bool {
+		return !this.outliers.isEjected(addr)
+	})
+}
+
+// servantOf borrows a conn of the peer, it gives up once the peer is
+// ejected.
+func (this *Proxy) servantOf(peerAddr string,
+	key string) (svt *FunServantPeer, err error) {
+	this.mutex.Lock()
+	peerPool, present := this.remotePeerPools[peerAddr]
+	this.mutex.Unlock()
+	if !present {
+		return nil, ErrPeerNotFound
+	}
+
+	peerPool.nextTxn()
 	for i := 0; i < this.cf.PoolCapacity; i++ {
-		svt, err = this.remotePeerPools[peerAddr].Get()
+		svt, err = peerPool.Get()
 		if err == nil {
 			// found a valid conn
 			break
@@ -200,6 +275,10 @@ func (this *Proxy) ServantByKey(key string) (svt *FunServantPeer, err error) {
 				}
 				svt.Recycle()
 			}
+
+			if this.outliers.isEjected(peerAddr) {
+				break
+			}
 		}
 	}
 
@@ -209,7 +288,7 @@ func (this *Proxy) ServantByKey(key string) (svt *FunServantPeer, err error) {
 // Simulate a simple load balance
 func (this *Proxy) RandServant() (svt *FunServantPeer, err error) {
 	peerAddr := this.selector.RandPeer()
-	if peerAddr == this.cf.SelfAddr {
+	if peerAddr == this.cf.SelfAddr || this.outliers.isEjected(peerAddr) {
 		return nil, nil
 	}
 
@@ -274,22 +353,70 @@ func (this *Proxy) ClusterPeers() []string {
 func (this *Proxy) StatsJSON() string {
 	m := make(map[string]string)
 	for addr, pool := range this.remotePeerPools {
-		m[addr] = pool.pool.StatsJSON()
+		m[addr] = pool.StatsJSON()
 	}
 
 	pretty, _ := json.MarshalIndent(m, "", "    ")
 	return string(pretty)
 }
 
-func (this *Proxy) StatsMap() map[string]string {
-	m := make(map[string]string)
+func (this *Proxy) StatsMap() map[string]interface{} {
+	m := make(map[string]interface{})
 	for addr, pool := range this.remotePeerPools {
-		m[addr] = pool.pool.StatsJSON()
+		m[addr] = map[string]interface{}{
+			"pool":   pool.StatsJSON(),
+			"health": this.outliers.stats(addr),
+		}
 	}
 
 	return m
 }
 
+// runHealthCheck pings each remote peer actively, so that ejected peers
+// without traffic can recover.
+func (this *Proxy) runHealthCheck() {
+	ticker := time.NewTicker(this.cf.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for _ = range ticker.C {
+		this.mutex.Lock()
+		pools := make([]*funServantPeerPool, 0, len(this.remotePeerPools))
+		for _, pool := range this.remotePeerPools {
+			pools = append(pools, pool)
+		}
+		this.mutex.Unlock()
+
+		var wg sync.WaitGroup
+		for _, pool := range pools {
+			wg.Add(1)
+			go func(pool *funServantPeerPool) {
+				defer wg.Done()
+				this.probe(pool)
+			}(pool)
+		}
+		wg.Wait()
+	}
+}
+
+func (this *Proxy) probe(pool *funServantPeerPool) {
+	svt, err := pool.Get() // failure is reported by Get
+	if err != nil {
+		if svt != nil {
+			svt.Close()
+			svt.Recycle()
+		}
+		return
+	}
+
+	_, err = svt.Ping(svt.NewContext("health", 0))
+	if err != nil {
+		log.Debug("peer[%s] probe: %s", pool.peerAddr, err)
+		svt.Close()
+	}
+	svt.Done(err)
+	svt.Recycle()
+}
+
 func (this *Proxy) Warmup() {
 	this.AwaitClusterTopologyReady()
 
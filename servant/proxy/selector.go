@@ -1,7 +1,25 @@
 package proxy
 
+import (
+	"github.com/funkygao/fae/config"
+)
+
 type PeerSelector interface {
 	SetPeersAddr(peerAddrs ...string) // self inclusive
 	PickPeer(key string) string       // return peer addr, self inclusive
 	RandPeer() string
+
+	// NextPeer walks from the owner of key to the 1st peer accepted by ok,
+	// returns empty string if none.
+	NextPeer(key string, ok func(peerAddr string) bool) string
+}
+
+func newPeerSelector(cf *config.ConfigProxy) PeerSelector {
+	switch cf.PeerSelector {
+	case "consistent":
+		return newConsistentPeerSelector(cf.ConsistentReplicas, cf.PeerWeights)
+
+	default:
+		return newStandardPeerSelector()
+	}
 }
@@ -1,30 +1,120 @@
 package proxy
 
 import (
-	"github.com/funkygao/golib/hash"
+	"crypto/md5"
+	"encoding/binary"
+	"hash/crc32"
 	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 )
 
+// A point of a peer on the ring.
+type ringPoint struct {
+	hash uint32
+	addr string
+}
+
+type ringPoints []ringPoint
+
+func (this ringPoints) Len() int      { return len(this) }
+func (this ringPoints) Swap(i, j int) { this[i], this[j] = this[j], this[i] }
+func (this ringPoints) Less(i, j int) bool {
+	if this[i].hash == this[j].hash {
+		// so that collided points are owned the same across peers
+		return this[i].addr < this[j].addr
+	}
+	return this[i].hash < this[j].hash
+}
+
+// Ketama style consistent hashing: each peer has replicas*weight points
+// on the ring, a key belongs to the first point clockwise.
+// Adding or removing a peer only remaps keys of that peer.
 type ConsistentPeerSelector struct {
-	peerAddrs []string  // just for random selecting
-	peers     *hash.Map // array of peerAddr, self inclusive
+	replicas int
+	weights  map[string]int // 1 if absent, 0 takes the peer off the ring
+
+	mutex     sync.RWMutex
+	peerAddrs []string // just for random selecting
+	points    ringPoints
 }
 
-func newConsistentPeerSelector() *ConsistentPeerSelector {
+func newConsistentPeerSelector(replicas int,
+	weights map[string]int) *ConsistentPeerSelector {
 	rand.Seed(time.Now().UnixNano())
-	return &ConsistentPeerSelector{peers: hash.New(32, nil)} // TODO 32?
+	return &ConsistentPeerSelector{replicas: replicas, weights: weights}
 }
 
+// SetPeersAddr rebuilds the ring, peers absent are removed.
 func (this *ConsistentPeerSelector) SetPeersAddr(peerAddrs ...string) {
+	points := make(ringPoints, 0, len(peerAddrs)*this.replicas)
+	for _, addr := range peerAddrs {
+		weight, present := this.weights[addr]
+		if !present {
+			weight = 1
+		}
+
+		// each md5 digest yields 4 points
+		for i := 0; i < (this.replicas*weight+3)/4; i++ {
+			digest := md5.Sum([]byte(addr + "-" + strconv.Itoa(i)))
+			for j := 0; j < 4; j++ {
+				points = append(points, ringPoint{
+					hash: binary.LittleEndian.Uint32(digest[j*4:]),
+					addr: addr,
+				})
+			}
+		}
+	}
+	sort.Sort(points)
+
+	this.mutex.Lock()
 	this.peerAddrs = peerAddrs
-	this.peers.Add(peerAddrs...)
+	this.points = points
+	this.mutex.Unlock()
 }
 
+// PickPeer returns empty string if the ring is empty.
 func (this *ConsistentPeerSelector) PickPeer(key string) (peerAddr string) {
-	return this.peers.Get(key)
+	return this.NextPeer(key, func(string) bool { return true })
+}
+
+// NextPeer walks clockwise, so keys of a peer out of the ring go to
+// the same peer as if it were removed.
+func (this *ConsistentPeerSelector) NextPeer(key string,
+	ok func(peerAddr string) bool) string {
+	h := crc32.ChecksumIEEE([]byte(key))
+
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	n := len(this.points)
+	i := sort.Search(n, func(i int) bool {
+		return this.points[i].hash >= h
+	})
+	var rejected map[string]bool // each peer has many points
+	for j := 0; j < n; j++ {
+		// wrap around
+		addr := this.points[(i+j)%n].addr
+		if rejected[addr] {
+			continue
+		}
+		if ok(addr) {
+			return addr
+		}
+
+		if rejected == nil {
+			rejected = make(map[string]bool)
+		}
+		rejected[addr] = true
+	}
+
+	return ""
 }
 
 func (this *ConsistentPeerSelector) RandPeer() string {
-	return this.peerAddrs[rand.Perm(len(this.peerAddrs))[0]]
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	return this.peerAddrs[rand.Intn(len(this.peerAddrs))]
 }
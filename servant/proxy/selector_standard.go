@@ -20,11 +20,26 @@ func (this *StandardPeerSelector) SetPeersAddr(peerAddrs ...string) {
 }
 
 func (this *StandardPeerSelector) PickPeer(key string) (peerAddr string) {
+	return this.peerAddrs[this.index(key)]
+}
+
+func (this *StandardPeerSelector) NextPeer(key string,
+	ok func(peerAddr string) bool) string {
+	index := this.index(key)
+	for i := 0; i < len(this.peerAddrs); i++ {
+		peerAddr := this.peerAddrs[(index+i)%len(this.peerAddrs)]
+		if ok(peerAddr) {
+			return peerAddr
+		}
+	}
+
+	return ""
+}
+
+func (this *StandardPeerSelector) index(key string) int {
 	// adler32 is almost same as crc32, but much 3 times faster
 	checksum := adler32.Checksum([]byte(key))
-	index := int(checksum) % len(this.peerAddrs)
-
-	return this.peerAddrs[index]
+	return int(checksum) % len(this.peerAddrs)
 }
 
 func (this *StandardPeerSelector) RandPeer() string {
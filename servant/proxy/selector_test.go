@@ -1,6 +1,8 @@
 package proxy
 
 import (
+	"fmt"
+	"github.com/funkygao/assert"
 	"testing"
 )
 
@@ -11,3 +13,74 @@ func TestRandServant(t *testing.T) {
 		t.Logf("%s", s.RandPeer())
 	}
 }
+
+var testPeers = []string{"10.0.0.1:9001", "10.0.0.2:9001", "10.0.0.3:9001",
+	"10.0.0.4:9001", "10.0.0.5:9001", "10.0.0.6:9001", "10.0.0.7:9001",
+	"10.0.0.8:9001", "10.0.0.9:9001"}
+
+// keyMoved returns the ratio of keys remapped after topology change.
+func keyMoved(s PeerSelector, before, after []string) float64 {
+	const keys = 100000
+	s.SetPeersAddr(before...)
+	owners := make([]string, keys)
+	for i := 0; i < keys; i++ {
+		owners[i] = s.PickPeer(fmt.Sprintf("user:%d", i))
+	}
+
+	s.SetPeersAddr(after...)
+	moved := 0
+	for i := 0; i < keys; i++ {
+		if s.PickPeer(fmt.Sprintf("user:%d", i)) != owners[i] {
+			moved++
+		}
+	}
+	return float64(moved) / keys
+}
+
+func TestPeerSelectorKeyMovement(t *testing.T) {
+	n := len(testPeers)
+	added := append(append([]string{}, testPeers...), "10.0.0.10:9001")
+	removed := testPeers[:n-1]
+
+	standardAdd := keyMoved(newStandardPeerSelector(), testPeers, added)
+	standardDel := keyMoved(newStandardPeerSelector(), testPeers, removed)
+	consistentAdd := keyMoved(newConsistentPeerSelector(160, nil),
+		testPeers, added)
+	consistentDel := keyMoved(newConsistentPeerSelector(160, nil),
+		testPeers, removed)
+	t.Logf("key moved on add: standard %.3f consistent %.3f",
+		standardAdd, consistentAdd)
+	t.Logf("key moved on del: standard %.3f consistent %.3f",
+		standardDel, consistentDel)
+
+	// ideally 1/(n+1) and 1/n
+	assert.Equal(t, true, standardAdd > 0.8)
+	assert.Equal(t, true, consistentAdd < 1.5/float64(n+1))
+	assert.Equal(t, true, consistentDel < 1.5/float64(n))
+}
+
+func TestConsistentPeerSelector(t *testing.T) {
+	s := newConsistentPeerSelector(160, map[string]int{
+		"10.0.0.1:9001": 2,
+		"10.0.0.3:9001": 0,
+	})
+	assert.Equal(t, "", s.PickPeer("user:1"))
+
+	s.SetPeersAddr(testPeers[:3]...)
+	counts := make(map[string]int)
+	for i := 0; i < 90000; i++ {
+		counts[s.PickPeer(fmt.Sprintf("user:%d", i))]++
+	}
+	t.Logf("%+v", counts)
+
+	// weight 0 is off the ring, weight 2 gets about 2/3
+	assert.Equal(t, 0, counts["10.0.0.3:9001"])
+	ratio := float64(counts["10.0.0.1:9001"]) / 90000
+	assert.Equal(t, true, ratio > 0.6 && ratio < 0.73)
+
+	// removed peer is gone from the ring
+	s.SetPeersAddr(testPeers[1:2]...)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, "10.0.0.2:9001", s.PickPeer(fmt.Sprintf("user:%d", i)))
+	}
+}
@@ -32,3 +32,10 @@ func IsIoError(err error) bool {
 
 	return false
 }
+
+// IsTimeout tells io timeout of the conn and borrow timeout of the pool.
+func IsTimeout(err error) bool {
+	errmsg := err.Error()
+	return strings.HasSuffix(errmsg, "i/o timeout") ||
+		strings.HasSuffix(errmsg, "timed out")
+}
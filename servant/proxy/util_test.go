@@ -16,3 +16,9 @@ func TestIsIoError(t *testing.T) {
 	err = errors.New("connection reset by peer")
 	assert.Equal(t, true, IsIoError(err))
 }
+
+func TestIsTimeout(t *testing.T) {
+	assert.Equal(t, true, IsTimeout(errors.New("read tcp 10.0.0.1:9001: i/o timeout")))
+	assert.Equal(t, true, IsTimeout(errors.New("resource pool timed out")))
+	assert.Equal(t, false, IsTimeout(errors.New("EOF")))
+}
@@ -0,0 +1,12 @@
+package pubsub
+
+import (
+	"errors"
+)
+
+var (
+	ErrNotSubscribed      = errors.New("pubsub: channel not subscribed")
+	ErrTooManySubscribers = errors.New("pubsub: too many subscribers")
+	ErrEmptySubscriber    = errors.New("pubsub: empty subscriber id")
+	ErrNoMaster           = errors.New("pubsub: master of shard unknown")
+)
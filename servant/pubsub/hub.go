@@ -0,0 +1,371 @@
+// Package pubsub bridges redis pub/sub to short lived RPC clients.
+//
+// Hub holds long lived subscriptions of the configured channels and
+// patterns, and buffers messages for each subscriber until it polls.
+// A subscriber starts buffering a channel on its first poll of it.
+package pubsub
+
+import (
+	"github.com/funkygao/fae/config"
+	log "github.com/funkygao/log4go"
+	"github.com/funkygao/redigo/redis"
+	"sync"
+	"time"
+)
+
+const (
+	dialTimeout         = 4 * time.Second
+	reconnectBackoff    = time.Second
+	masterCheckInterval = time.Second
+)
+
+// MasterResolver tells the current master addr of a redis shard.
+type MasterResolver interface {
+	MasterAddr(pool, name string) string
+}
+
+type subscriber struct {
+	mutex    sync.Mutex
+	rings    map[string]*ring // key is channel or pattern
+	lastPoll time.Time
+}
+
+type Hub struct {
+	cf      *config.ConfigPubsub
+	masters MasterResolver
+	shards  []string // names of redis shards to subscribe
+
+	topics map[string]bool // channels and patterns subscribed
+
+	mutex       sync.RWMutex
+	offsets     map[string]int64 // channel or pattern:latest offset
+	subscribers map[string]*subscriber
+
+	connsMutex sync.Mutex
+	conns      map[string]redis.Conn // shard:subscribing conn
+	addrs      map[string]string     // shard:subscribed master addr
+	quit       chan struct{}
+}
+
+// New subscribes to the pubsub pool and starts buffering.
+//
+// PUBLISH is routed to a single shard by channel name, so each master
+// of the pool is subscribed. In cluster mode messages are broadcast to
+// all nodes, so only 1 seed is subscribed.
+//
+// Masters are resolved on each (re)connect, and a subscription is made
+// again once its shard fails over to another master.
+func New(cf *config.ConfigPubsub, redisConf *config.ConfigRedis,
+	masters MasterResolver) *Hub {
+	this := &Hub{
+		cf:          cf,
+		masters:     masters,
+		topics:      make(map[string]bool),
+		offsets:     make(map[string]int64),
+		subscribers: make(map[string]*subscriber),
+		conns:       make(map[string]redis.Conn),
+		addrs:       make(map[string]string),
+		quit:        make(chan struct{}),
+	}
+	for _, topic := range cf.Channels {
+		this.topics[topic] = true
+	}
+	for _, topic := range cf.Patterns {
+		this.topics[topic] = true
+	}
+
+	for name, _ := range redisConf.Servers[cf.Pool] {
+		this.shards = append(this.shards, name)
+		if redisConf.ClusterPools[cf.Pool] {
+			break
+		}
+	}
+
+	for _, name := range this.shards {
+		go this.subscribe(name)
+	}
+	go this.runJanitor()
+
+	return this
+}
+
+// Close stops all subscriptions.
+func (this *Hub) Close() {
+	close(this.quit)
+
+	this.connsMutex.Lock()
+	for _, conn := range this.conns {
+		conn.Close() // unblock Receive
+	}
+	this.connsMutex.Unlock()
+}
+
+func (this *Hub) stopped() bool {
+	select {
+	case <-this.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// subscribe keeps the subscription of a shard alive until Close.
+func (this *Hub) subscribe(name string) {
+	for !this.stopped() {
+		err := this.receive(name)
+		if this.stopped() {
+			return
+		}
+
+		log.Error("pubsub[%s]: %s, reconnecting...", name, err)
+		time.Sleep(reconnectBackoff)
+	}
+}
+
+func (this *Hub) receive(name string) error {
+	addr := this.masters.MasterAddr(this.cf.Pool, name)
+	if addr == "" {
+		return ErrNoMaster
+	}
+
+	// no read timeout: subscribing conn blocks until a message arrives
+	conn, err := redis.DialTimeout("tcp", addr, dialTimeout, 0, dialTimeout)
+	if err != nil {
+		return err
+	}
+
+	this.connsMutex.Lock()
+	this.conns[name] = conn
+	this.addrs[name] = addr
+	this.connsMutex.Unlock()
+	if this.stopped() {
+		// Close came before conn registered
+		conn.Close()
+	}
+
+	done := make(chan struct{})
+	go this.followMaster(name, addr, conn, done)
+	defer func() {
+		close(done)
+		this.connsMutex.Lock()
+		delete(this.conns, name)
+		delete(this.addrs, name)
+		this.connsMutex.Unlock()
+		conn.Close()
+	}()
+
+	psc := redis.PubSubConn{Conn: conn}
+	if len(this.cf.Channels) > 0 {
+		if err = psc.Subscribe(redis.Args{}.AddFlat(this.cf.Channels)...); err != nil {
+			return err
+		}
+	}
+	if len(this.cf.Patterns) > 0 {
+		if err = psc.PSubscribe(redis.Args{}.AddFlat(this.cf.Patterns)...); err != nil {
+			return err
+		}
+	}
+
+	log.Info("pubsub[%s] subscribed on %s: %+v %+v", name, addr,
+		this.cf.Channels, this.cf.Patterns)
+
+	for {
+		switch msg := psc.Receive().(type) {
+		case redis.Message:
+			this.deliver(msg.Channel, msg.Channel, msg.Data)
+
+		case redis.PMessage:
+			this.deliver(msg.Pattern, msg.Channel, msg.Data)
+
+		case error:
+			return msg
+		}
+	}
+}
+
+// followMaster closes the subscribing conn once the shard has another
+// master, so that the subscription is made again on the new one.
+func (this *Hub) followMaster(name, addr string, conn redis.Conn,
+	done chan struct{}) {
+	ticker := time.NewTicker(masterCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if master := this.masters.MasterAddr(this.cf.Pool,
+				name); master != addr {
+				log.Warn("pubsub[%s] master %s -> %s, resubscribing", name,
+					addr, master)
+				conn.Close() // unblock Receive
+				return
+			}
+
+		case <-done:
+			return
+		}
+	}
+}
+
+// deliver assigns an offset to the message and buffers it for each
+// subscriber that polls the topic.
+func (this *Hub) deliver(topic, channel string, payload []byte) {
+	// offset assignment and buffering are atomic so that each ring
+	// stays ordered by offset
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.offsets[topic]++
+	msg := Message{Offset: this.offsets[topic], Channel: channel, Payload: payload}
+	for _, sub := range this.subscribers {
+		sub.mutex.Lock()
+		if r, present := sub.rings[topic]; present {
+			r.push(msg)
+		}
+		sub.mutex.Unlock()
+	}
+}
+
+func (this *Hub) subscriber(id string) (*subscriber, error) {
+	this.mutex.RLock()
+	sub, present := this.subscribers[id]
+	this.mutex.RUnlock()
+	if present {
+		return sub, nil
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if sub, present = this.subscribers[id]; present {
+		return sub, nil
+	}
+
+	if len(this.subscribers) >= this.cf.MaxSubscribers {
+		return nil, ErrTooManySubscribers
+	}
+
+	sub = &subscriber{rings: make(map[string]*ring), lastPoll: time.Now()}
+	this.subscribers[id] = sub
+	return sub, nil
+}
+
+// Poll returns at most max messages of topic after offset sinceOffset.
+// If none is buffered yet, it waits at most wait for new messages.
+// Negative sinceOffset means only messages after now.
+// next is the sinceOffset of the following poll.
+// lost is true if some messages after sinceOffset were overwritten
+// because the subscriber polled too slow.
+func (this *Hub) Poll(subscriberId, topic string, sinceOffset int64, max int,
+	wait time.Duration) (msgs []Message, next int64, lost bool, err error) {
+	if subscriberId == "" {
+		return nil, 0, false, ErrEmptySubscriber
+	}
+	if !this.topics[topic] {
+		return nil, 0, false, ErrNotSubscribed
+	}
+	if max <= 0 || max > this.cf.BufferSize {
+		max = this.cf.BufferSize
+	}
+	if wait > this.cf.MaxPollWait {
+		wait = this.cf.MaxPollWait
+	}
+
+	sub, err := this.subscriber(subscriberId)
+	if err != nil {
+		return
+	}
+
+	if sinceOffset < 0 {
+		this.mutex.RLock()
+		sinceOffset = this.offsets[topic]
+		this.mutex.RUnlock()
+	}
+	next = sinceOffset
+	defer func() {
+		if len(msgs) > 0 {
+			next = msgs[len(msgs)-1].Offset
+		}
+	}()
+
+	sub.mutex.Lock()
+	sub.lastPoll = time.Now()
+	r, present := sub.rings[topic]
+	if !present {
+		r = newRing(this.cf.BufferSize)
+		sub.rings[topic] = r
+	}
+	msgs, lost = r.since(sinceOffset, max)
+	notify := r.notify
+	sub.mutex.Unlock()
+
+	if len(msgs) > 0 || wait <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-notify:
+		sub.mutex.Lock()
+		msgs, lost = r.since(sinceOffset, max)
+		sub.mutex.Unlock()
+
+	case <-timer.C:
+	case <-this.quit:
+	}
+
+	return
+}
+
+// runJanitor drops idle subscribers with their buffers.
+func (this *Hub) runJanitor() {
+	if this.cf.SubscriberIdleTimeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(this.cf.SubscriberIdleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			this.mutex.Lock()
+			for id, sub := range this.subscribers {
+				sub.mutex.Lock()
+				idle := time.Since(sub.lastPoll) > this.cf.SubscriberIdleTimeout
+				sub.mutex.Unlock()
+				if idle {
+					delete(this.subscribers, id)
+				}
+			}
+			this.mutex.Unlock()
+
+		case <-this.quit:
+			return
+		}
+	}
+}
+
+func (this *Hub) Stats() map[string]interface{} {
+	this.connsMutex.Lock()
+	servers := make(map[string]string, len(this.addrs))
+	for name, addr := range this.addrs {
+		servers[name] = addr
+	}
+	this.connsMutex.Unlock()
+
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	offsets := make(map[string]int64, len(this.offsets))
+	for topic, offset := range this.offsets {
+		offsets[topic] = offset
+	}
+
+	return map[string]interface{}{
+		"subscribers": len(this.subscribers),
+		"offsets":     offsets,
+		"servers":     servers,
+	}
+}
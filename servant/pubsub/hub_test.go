@@ -0,0 +1,59 @@
+package pubsub
+
+import (
+	"github.com/funkygao/assert"
+	"github.com/funkygao/fae/config"
+	"github.com/funkygao/redigo/redis"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeMasters struct {
+	sync.Mutex
+	addr string
+}
+
+func (this *fakeMasters) MasterAddr(pool, name string) string {
+	this.Lock()
+	defer this.Unlock()
+	return this.addr
+}
+
+func (this *fakeMasters) failover(addr string) {
+	this.Lock()
+	this.addr = addr
+	this.Unlock()
+}
+
+type fakeConn struct {
+	redis.Conn
+	closed chan struct{}
+}
+
+func (this *fakeConn) Close() error {
+	close(this.closed)
+	return nil
+}
+
+func TestFollowMaster(t *testing.T) {
+	masters := &fakeMasters{addr: "127.0.0.1:6379"}
+	hub := &Hub{cf: &config.ConfigPubsub{Pool: "default"}, masters: masters}
+	conn := &fakeConn{closed: make(chan struct{})}
+	done := make(chan struct{})
+	go hub.followMaster("shard1", "127.0.0.1:6379", conn, done)
+
+	select {
+	case <-conn.closed:
+		t.Fatal("closed while master unchanged")
+	case <-time.After(masterCheckInterval + time.Millisecond*100):
+	}
+
+	masters.failover("127.0.0.1:6380")
+	select {
+	case <-conn.closed:
+	case <-time.After(masterCheckInterval * 2):
+		t.Fatal("not resubscribed after failover")
+	}
+	assert.Equal(t, "127.0.0.1:6380", masters.MasterAddr("default", "shard1"))
+}
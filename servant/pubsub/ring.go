@@ -0,0 +1,57 @@
+package pubsub
+
+// A message received from a redis channel.
+type Message struct {
+	Offset  int64 // monotonic within a channel/pattern
+	Channel string
+	Payload []byte
+}
+
+// ring is a bounded FIFO of messages: when full, the oldest is overwritten.
+// Not goroutine safe.
+type ring struct {
+	msgs []Message
+	head int // index of the oldest
+	n    int
+
+	dropped int64 // offset of the latest overwritten message
+
+	notify chan struct{} // closed and renewed on each push
+}
+
+func newRing(size int) *ring {
+	return &ring{msgs: make([]Message, size), notify: make(chan struct{})}
+}
+
+func (this *ring) push(msg Message) {
+	if this.n < len(this.msgs) {
+		this.msgs[(this.head+this.n)%len(this.msgs)] = msg
+		this.n++
+	} else {
+		this.dropped = this.msgs[this.head].Offset
+		this.msgs[this.head] = msg
+		this.head = (this.head + 1) % len(this.msgs)
+	}
+
+	close(this.notify)
+	this.notify = make(chan struct{})
+}
+
+// since returns at most max messages whose offset is greater than offset.
+// lost is true if some messages after offset were overwritten.
+func (this *ring) since(offset int64, max int) (msgs []Message, lost bool) {
+	lost = offset < this.dropped
+	if this.n == 0 {
+		return
+	}
+
+	msgs = make([]Message, 0, max)
+	for i := 0; i < this.n && len(msgs) < max; i++ {
+		msg := this.msgs[(this.head+i)%len(this.msgs)]
+		if msg.Offset > offset {
+			msgs = append(msgs, msg)
+		}
+	}
+
+	return
+}
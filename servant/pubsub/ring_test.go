@@ -0,0 +1,52 @@
+package pubsub
+
+import (
+	"github.com/funkygao/assert"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	r := newRing(3)
+	msgs, lost := r.since(0, 10)
+	assert.Equal(t, 0, len(msgs))
+	assert.Equal(t, false, lost)
+
+	for i := int64(1); i <= 2; i++ {
+		r.push(Message{Offset: i, Channel: "chat"})
+	}
+	msgs, lost = r.since(0, 10)
+	assert.Equal(t, 2, len(msgs))
+	assert.Equal(t, false, lost)
+	msgs, _ = r.since(1, 10)
+	assert.Equal(t, 1, len(msgs))
+	assert.Equal(t, int64(2), msgs[0].Offset)
+
+	// overwrite 1 and 2
+	for i := int64(3); i <= 5; i++ {
+		r.push(Message{Offset: i, Channel: "chat"})
+	}
+	msgs, lost = r.since(0, 10)
+	assert.Equal(t, true, lost)
+	assert.Equal(t, 3, len(msgs))
+	assert.Equal(t, int64(3), msgs[0].Offset)
+	assert.Equal(t, int64(5), msgs[2].Offset)
+
+	msgs, lost = r.since(2, 10)
+	assert.Equal(t, false, lost)
+	assert.Equal(t, 3, len(msgs))
+
+	msgs, _ = r.since(2, 2) // max
+	assert.Equal(t, 2, len(msgs))
+	assert.Equal(t, int64(4), msgs[1].Offset)
+}
+
+func TestRingNotify(t *testing.T) {
+	r := newRing(1)
+	notify := r.notify
+	r.push(Message{Offset: 1})
+	select {
+	case <-notify:
+	default:
+		t.Fatal("push should wake up waiters")
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"github.com/funkygao/fae/config"
 	"github.com/funkygao/golib/server"
 	"github.com/funkygao/msgpack"
+	"github.com/funkygao/redigo/redis"
 	"testing"
+	"time"
 )
 
 func TestCRUD(t *testing.T) {
@@ -81,3 +83,75 @@ func TestComplexDataType(t *testing.T) {
 	assert.Equal(t, name, val1.Name)
 
 }
+
+func TestServerBorrowTimeout(t *testing.T) {
+	svr := newServer("127.0.0.1:6379", &config.ConfigRedisServer{MaxActive: 1},
+		time.Minute)
+	svr.throttle <- struct{}{} // occupy the only slot
+
+	t0 := time.Now()
+	_, err := svr.get(time.Millisecond * 20)
+	assert.Equal(t, ErrPoolExhausted, err)
+	assert.Equal(t, true, time.Since(t0) >= time.Millisecond*20)
+	assert.Equal(t, int64(1), svr.stats()["waits"])
+	assert.Equal(t, int64(1), svr.stats()["waitTimeouts"])
+	assert.Equal(t, int64(0), svr.stats()["inflight"])
+}
+
+func TestIsReadOnly(t *testing.T) {
+	assert.Equal(t, true, isReadOnly("GET"))
+	assert.Equal(t, true, isReadOnly("hgetall"))
+	assert.Equal(t, false, isReadOnly("SET"))
+	assert.Equal(t, false, isReadOnly("EVAL"))
+}
+
+func TestSlot(t *testing.T) {
+	assert.Equal(t, uint16(0x31C3), crc16("123456789"))
+	assert.Equal(t, 12182, Slot("foo"))
+	assert.Equal(t, 5061, Slot("bar"))
+	assert.Equal(t, Slot("{user1000}.following"), Slot("{user1000}.followers"))
+	// empty hashtag hashes the whole key
+	assert.Equal(t, int(crc16("foo{}{bar}"))%clusterSlots, Slot("foo{}{bar}"))
+}
+
+func TestRedirect(t *testing.T) {
+	ask, slot, addr, ok := redirect(redis.Error("MOVED 3999 127.0.0.1:6381"))
+	assert.Equal(t, true, ok)
+	assert.Equal(t, false, ask)
+	assert.Equal(t, 3999, slot)
+	assert.Equal(t, "127.0.0.1:6381", addr)
+
+	ask, _, _, ok = redirect(redis.Error("ASK 3999 127.0.0.1:6381"))
+	assert.Equal(t, true, ok)
+	assert.Equal(t, true, ask)
+
+	_, _, _, ok = redirect(redis.Error("WRONGTYPE Operation against a key"))
+	assert.Equal(t, false, ok)
+	_, _, _, ok = redirect(nil)
+	assert.Equal(t, false, ok)
+}
+
+func TestCmdKeys(t *testing.T) {
+	assert.Equal(t, []string{"a", "b"}, cmdKeys("mset", []interface{}{"a", "1", "b", "2"}))
+	assert.Equal(t, []string{"a", "b"}, cmdKeys("MGET", []interface{}{"a", "b"}))
+	assert.Equal(t, 0, len(cmdKeys("SET", []interface{}{"a", "1"})))
+}
+
+func TestClusterPrune(t *testing.T) {
+	cl := &cluster{
+		ClusterServerSelector: &ClusterServerSelector{
+			seeds: []string{"127.0.0.1:7000"},
+		},
+		cf:    &config.ConfigRedisServer{},
+		nodes: make(map[string]*server),
+	}
+	cl.slots[0] = "127.0.0.1:7001"
+	cl.node("127.0.0.1:7000")
+	cl.node("127.0.0.1:7001")
+	cl.node("127.0.0.1:7002") // left the cluster
+
+	cl.prune()
+	assert.Equal(t, 2, len(cl.nodes))
+	_, present := cl.nodes["127.0.0.1:7002"]
+	assert.Equal(t, false, present)
+}
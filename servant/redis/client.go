@@ -4,108 +4,256 @@ import (
 	"errors"
 	"github.com/funkygao/fae/config"
 	"github.com/funkygao/golib/breaker"
+	"github.com/funkygao/golib/cache"
 	log "github.com/funkygao/log4go"
 	"github.com/funkygao/redigo/redis"
+	"sort"
 	"sync"
 	"time"
 )
 
-// TODO use my own pool, TestOnBorrow is expensive
 type Client struct {
 	cf      *config.ConfigRedis
 	breaker *breaker.Consecutive
 
-	selectors map[string]ServerSelector         // key is pool name
-	locks     map[string]map[string]*sync.Mutex // pool:serverAddr:Mutex
-	conns     map[string]map[string]*redis.Pool // pool:serverAddr:redis.Pool
+	breakersLock sync.Mutex
+	breakers     map[string]*breaker.Consecutive // key is server addr
+
+	selectors map[string]ServerSelector    // key is pool name
+	shards    map[string]map[string]*shard // pool:shardName:shard
+	clusters  map[string]*cluster          // pool:cluster, cluster mode pools
+
+	scripts *cache.LruCache // lua script:sha1
+
+	quit chan struct{}
 }
 
 func New(cf *config.ConfigRedis) *Client {
 	this := new(Client)
 	this.cf = cf
 	this.selectors = make(map[string]ServerSelector)
-	this.conns = make(map[string]map[string]*redis.Pool)
-	this.locks = make(map[string]map[string]*sync.Mutex)
+	this.shards = make(map[string]map[string]*shard)
+	this.clusters = make(map[string]*cluster)
+	this.breakers = make(map[string]*breaker.Consecutive)
+	this.scripts = cache.NewLruCache(scriptCacheMaxItems)
+	this.quit = make(chan struct{})
 	this.breaker = &breaker.Consecutive{
 		FailureAllowance: cf.Breaker.FailureAllowance,
 		RetryTimeout:     cf.Breaker.RetryTimeout}
 	for pool, _ := range cf.Servers {
+		if cf.ClusterPools[pool] {
+			this.newCluster(pool)
+			continue
+		}
+
 		this.selectors[pool] = new(ConsistentServerSelector)
 		this.selectors[pool].SetServers(cf.PoolServers(pool)...)
-		this.conns[pool] = make(map[string]*redis.Pool)
-		this.locks[pool] = make(map[string]*sync.Mutex)
-		for _, addr := range cf.PoolServers(pool) {
-			this.locks[pool][addr] = &sync.Mutex{}
-
-			this.conns[pool][addr] = &redis.Pool{
-				MaxIdle:     cf.Servers[pool][addr].MaxIdle,
-				MaxActive:   cf.Servers[pool][addr].MaxActive,
-				IdleTimeout: cf.Servers[pool][addr].IdleTimeout,
-				Dial: func() (redis.Conn, error) {
-					c, err := redis.Dial("tcp", addr)
-					if err != nil {
-						return nil, err
-					}
-
-					return c, err
-				},
-				TestOnBorrow: func(c redis.Conn, t time.Time) error {
-					_, err := c.Do("PING")
-					return err
-				},
-			}
+		this.shards[pool] = make(map[string]*shard)
+		for _, name := range cf.PoolServers(pool) {
+			this.shards[pool][name] = newShard(cf.Servers[pool][name],
+				cf.HealthCheckIdle)
 		}
 	}
 
+	go this.runSentinelWatchdog()
+
 	return this
 }
 
+// Close stops the sentinel watchdog and closes all conn pools.
+func (this *Client) Close() {
+	close(this.quit)
+
+	for _, shards := range this.shards {
+		for _, sh := range shards {
+			sh.close()
+		}
+	}
+	for _, cl := range this.clusters {
+		cl.close()
+	}
+}
+
+func (this *Client) newCluster(pool string) {
+	servers := make(map[string]*config.ConfigRedisServer) // addr:server
+	seeds := make([]string, 0, len(this.cf.Servers[pool]))
+	for _, server := range this.cf.Servers[pool] {
+		servers[server.Addr] = server
+		seeds = append(seeds, server.Addr)
+	}
+	if len(seeds) == 0 {
+		return
+	}
+	sort.Strings(seeds)
+
+	// all nodes share the conn pool sizing of the 1st seed, sorted to
+	// be stable across reloads
+	cl := newCluster(seeds, servers[seeds[0]], this.cf.HealthCheckIdle)
+	this.clusters[pool] = cl
+	this.selectors[pool] = cl
+}
+
+// MasterAddr returns addr of the current master of a shard, which follows
+// sentinel failover. In cluster mode, name is a seed node.
+func (this *Client) MasterAddr(pool, name string) string {
+	if sh, present := this.shards[pool][name]; present {
+		return sh.masterAddr()
+	}
+
+	if svr, present := this.cf.Servers[pool][name]; present {
+		return svr.Addr
+	}
+	return ""
+}
+
 func (this *Client) Call(cmd string, pool string,
+	keysAndArgs ...interface{}) (newVal interface{}, err error) {
+	return this.call(false, cmd, pool, keysAndArgs...)
+}
+
+// CallReplica is same as Call except that read only cmd is served by
+// replicas of the shard if any, so the reply may be stale.
+func (this *Client) CallReplica(cmd string, pool string,
+	keysAndArgs ...interface{}) (newVal interface{}, err error) {
+	return this.call(isReadOnly(cmd), cmd, pool, keysAndArgs...)
+}
+
+func (this *Client) call(replica bool, cmd string, pool string,
 	keysAndArgs ...interface{}) (newVal interface{}, err error) {
 	if this.breaker.Open() {
 		return nil, ErrCircuitOpen
 	}
 
 	if len(keysAndArgs) == 0 {
-		// e,g. cmd=multi | exec | discard
+		// e,g. cmd=multi | exec | discard, use Multi instead
 		return nil, errors.New("redis cmd not implemented:" + cmd)
 	}
 
-	key := keysAndArgs[0].(string)
-	addr, errPoolNotFound := this.addr(pool, key)
-	if errPoolNotFound != nil {
-		return nil, errPoolNotFound
+	key, ok := keysAndArgs[0].(string)
+	if !ok {
+		return nil, ErrInvalidKey
 	}
-
-	conn := this.conns[pool][addr].Get()
-	err = conn.Err()
+	name, err := this.shardName(pool, key)
 	if err != nil {
-		conn.Close()
-		this.breaker.Fail() // conn err is always system err
-		return
+		return nil, err
+	}
+	if _, present := this.clusters[pool]; present {
+		if keys := cmdKeys(cmd, keysAndArgs); len(keys) > 1 {
+			if name, err = this.sameShard(pool, keys); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	this.locks[pool][addr].Lock()
+	return this.withConn(pool, name, replica, func(conn redis.Conn) (interface{}, error) {
+		// Do(cmd string, args ...interface{}) (reply interface{}, err error)
+		switch cmd {
+		case "GET":
+			val, err := conn.Do(cmd, key)
+			if val == nil && err == nil {
+				err = ErrKeyNotExist
+			}
+			return val, err
 
-	// Do(cmd string, args ...interface{}) (reply interface{}, err error)
-	switch cmd {
-	case "GET":
-		newVal, err = conn.Do(cmd, key)
-		if newVal == nil {
-			err = ErrKeyNotExist
+		default:
+			return conn.Do(cmd, keysAndArgs...)
 		}
+	})
+}
+
+// withConn runs fn on a conn borrowed from the master or a replica
+// of the shard. In cluster mode, name is the node addr and replica
+// is ignored.
+func (this *Client) withConn(pool, name string, replica bool,
+	fn func(conn redis.Conn) (interface{}, error)) (reply interface{}, err error) {
+	if cl, present := this.clusters[pool]; present {
+		return this.withClusterConn(cl, name, fn)
+	}
+
+	return this.withServer(this.shards[pool][name].pick(replica), fn)
+}
+
+// server returns the master conn pool of a shard or a cluster node.
+func (this *Client) server(pool, name string) *server {
+	if cl, present := this.clusters[pool]; present {
+		return cl.node(name)
+	}
 
-	default:
-		newVal, err = conn.Do(cmd, keysAndArgs...)
+	return this.shards[pool][name].pick(false)
+}
+
+func (this *Client) withServer(svr *server,
+	fn func(conn redis.Conn) (interface{}, error)) (reply interface{}, err error) {
+	b := this.serverBreaker(svr.addr)
+	if b.Open() {
+		return nil, ErrCircuitOpen
+	}
+
+	conn, err := svr.get(this.cf.BorrowTimeout)
+	if err != nil {
+		if err != ErrPoolExhausted {
+			// conn err is always system err
+			this.breaker.Fail()
+			b.Fail()
+		}
+		return
 	}
-	if err != nil && err != ErrKeyNotExist {
+	defer svr.put(conn) // return to conn pool
+
+	reply, err = fn(conn)
+	if err != nil && err != ErrKeyNotExist && !IsReplyError(err) {
 		this.breaker.Fail()
+		b.Fail()
 	} else {
 		this.breaker.Succeed()
+		b.Succeed()
+	}
+
+	return
+}
+
+// serverBreaker returns circuit breaker of a single server, so that a
+// broken server is told from the others.
+func (this *Client) serverBreaker(addr string) *breaker.Consecutive {
+	this.breakersLock.Lock()
+	defer this.breakersLock.Unlock()
+
+	b, present := this.breakers[addr]
+	if !present {
+		b = &breaker.Consecutive{
+			FailureAllowance: this.cf.Breaker.FailureAllowance,
+			RetryTimeout:     this.cf.Breaker.RetryTimeout}
+		this.breakers[addr] = b
+	}
+	return b
+}
+
+// sameShard makes sure all keys are on the same shard.
+// In cluster mode, all keys must be on the same slot.
+func (this *Client) sameShard(pool string, keys []string) (name string, err error) {
+	if _, present := this.clusters[pool]; present {
+		for _, key := range keys[1:] {
+			if Slot(key) != Slot(keys[0]) {
+				return "", ErrCrossSlot
+			}
+		}
+
+		return this.shardName(pool, keys[0])
+	}
+
+	for i, key := range keys {
+		keyShard, err := this.shardName(pool, key)
+		if err != nil {
+			return "", err
+		}
+
+		if i == 0 {
+			name = keyShard
+		} else if keyShard != name {
+			return "", ErrCrossServer
+		}
 	}
 
-	this.locks[pool][addr].Unlock()
-	conn.Close() // return to conn pool
 	return
 }
 
@@ -130,7 +278,7 @@ func (this *Client) Del(pool, key string) (err error) {
 	return
 }
 
-func (this *Client) addr(pool, key string) (string, error) {
+func (this *Client) shardName(pool, key string) (string, error) {
 	selector, present := this.selectors[pool]
 	if !present {
 		return "", ErrPoolNotFound
@@ -139,20 +287,51 @@ func (this *Client) addr(pool, key string) (string, error) {
 	return selector.PickServer(key), nil
 }
 
+// pool:shardName:stats
+func (this *Client) StatsMap() map[string]map[string]interface{} {
+	r := make(map[string]map[string]interface{})
+	for pool, shards := range this.shards {
+		r[pool] = make(map[string]interface{})
+		for name, sh := range shards {
+			r[pool][name] = sh.stats()
+		}
+	}
+	for pool, cl := range this.clusters {
+		r[pool] = cl.stats()
+	}
+	return r
+}
+
+// BreakerStates tells whether circuit breaker of each server that has
+// been called is open, key is the server addr.
+func (this *Client) BreakerStates() map[string]bool {
+	this.breakersLock.Lock()
+	defer this.breakersLock.Unlock()
+
+	r := make(map[string]bool, len(this.breakers))
+	for addr, b := range this.breakers {
+		r[addr] = b.Open()
+	}
+	return r
+}
+
 func (this *Client) Warmup() {
 	t1 := time.Now()
-	for poolName, pool := range this.conns {
-		for addr, conn := range pool {
-			log.Debug("redis pool[%s] connecting: %s", poolName, addr)
-			for i := 0; i < this.cf.Servers[poolName][addr].MaxActive; i++ {
-				c := conn.Get()
-				if c.Err() != nil {
-					log.Error("redis[%s][%s]: %v", poolName, addr, c.Err())
-					continue
-				}
+	for poolName, shards := range this.shards {
+		for name, sh := range shards {
+			for _, svr := range sh.servers() {
+				log.Debug("redis pool[%s] shard[%s] connecting: %s",
+					poolName, name, svr.addr)
+				for i := 0; i < this.cf.Servers[poolName][name].MaxIdle; i++ {
+					c := svr.pool.Get()
+					if c.Err() != nil {
+						log.Error("redis[%s][%s]: %v", poolName, svr.addr, c.Err())
+						continue
+					}
 
-				c.Do("PING")
-				defer c.Close()
+					c.Do("PING")
+					defer c.Close()
+				}
 			}
 		}
 	}
@@ -0,0 +1,325 @@
+package redis
+
+import (
+	"github.com/funkygao/fae/config"
+	log "github.com/funkygao/log4go"
+	"github.com/funkygao/redigo/redis"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const clusterTimeout = time.Second
+
+// step of keys within args of multi-key cmds, all keys of such a cmd
+// must be on the same slot in cluster mode
+var multiKeyCmds = map[string]int{
+	"MGET": 1, "DEL": 1, "EXISTS": 1, "UNLINK": 1, "TOUCH": 1,
+	"RENAME": 1, "RENAMENX": 1, "RPOPLPUSH": 1,
+	"SINTER": 1, "SUNION": 1, "SDIFF": 1,
+	"SINTERSTORE": 1, "SUNIONSTORE": 1, "SDIFFSTORE": 1,
+	"PFCOUNT": 1, "PFMERGE": 1,
+	"MSET": 2, "MSETNX": 2,
+}
+
+func cmdKeys(cmd string, keysAndArgs []interface{}) []string {
+	step := multiKeyCmds[strings.ToUpper(cmd)]
+	if step == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(keysAndArgs)/step+1)
+	for i := 0; i < len(keysAndArgs); i += step {
+		if key, ok := keysAndArgs[i].(string); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// ClusterServerSelector routes keys by the redis cluster hash slot map
+// loaded from CLUSTER SLOTS of the seed nodes.
+type ClusterServerSelector struct {
+	mutex sync.RWMutex
+	seeds []string
+	slots [clusterSlots]string // slot:master addr
+
+	refreshing int32
+	refreshed  func() // called after each successful refresh
+}
+
+func (this *ClusterServerSelector) SetServers(servers ...string) error {
+	this.seeds = servers
+	return this.refresh()
+}
+
+func (this *ClusterServerSelector) PickServer(key string) (addr string) {
+	return this.slotAddr(Slot(key))
+}
+
+func (this *ClusterServerSelector) slotAddr(slot int) string {
+	this.mutex.RLock()
+	addr := this.slots[slot]
+	this.mutex.RUnlock()
+
+	if addr == "" && len(this.seeds) > 0 {
+		// slot not covered yet, the seed will redirect us
+		addr = this.seeds[slot%len(this.seeds)]
+	}
+	return addr
+}
+
+// moved updates a single slot right away and reloads the whole map
+// in background, because a MOVED usually means resharding or failover.
+func (this *ClusterServerSelector) moved(slot int, addr string) {
+	this.mutex.Lock()
+	this.slots[slot] = addr
+	this.mutex.Unlock()
+
+	if !atomic.CompareAndSwapInt32(&this.refreshing, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&this.refreshing, 0)
+
+		if err := this.refresh(); err != nil {
+			log.Error("redis cluster refresh slots: %s", err)
+		}
+	}()
+}
+
+// refresh loads slot map from any reachable node: seeds first, then
+// the known masters.
+func (this *ClusterServerSelector) refresh() (err error) {
+	var slots []string
+	for _, addr := range this.nodeAddrs() {
+		if slots, err = this.loadSlots(addr); err == nil {
+			this.mutex.Lock()
+			copy(this.slots[:], slots)
+			this.mutex.Unlock()
+
+			if this.refreshed != nil {
+				this.refreshed()
+			}
+			return
+		}
+
+		log.Warn("redis cluster[%s] CLUSTER SLOTS: %s", addr, err)
+	}
+
+	if err == nil {
+		err = ErrNoClusterNode
+	}
+	return
+}
+
+func (this *ClusterServerSelector) nodeAddrs() []string {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	seen := make(map[string]bool)
+	addrs := make([]string, 0, len(this.seeds))
+	for _, addr := range this.seeds {
+		if !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	for _, addr := range this.slots {
+		if addr != "" && !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// CLUSTER SLOTS reply:
+// 1) 1) (integer) 0          start slot
+//  2. (integer) 5460       end slot
+//  3. 1) "127.0.0.1"       master ip
+//  2. (integer) 7000    master port
+//  4. ...                  replicas
+func (this *ClusterServerSelector) loadSlots(addr string) (slots []string, err error) {
+	conn, err := redis.DialTimeout("tcp", addr,
+		clusterTimeout, clusterTimeout, clusterTimeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	ranges, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+	if err != nil {
+		return
+	}
+
+	slots = make([]string, clusterSlots)
+	for _, r := range ranges {
+		fields, e := redis.Values(r, nil)
+		if e != nil || len(fields) < 3 {
+			return nil, ErrBadSlotMap
+		}
+
+		start, e1 := redis.Int(fields[0], nil)
+		end, e2 := redis.Int(fields[1], nil)
+		master, e3 := redis.Values(fields[2], nil)
+		if e1 != nil || e2 != nil || e3 != nil || len(master) < 2 ||
+			start < 0 || end >= clusterSlots || start > end {
+			return nil, ErrBadSlotMap
+		}
+
+		host, e1 := redis.String(master[0], nil)
+		port, e2 := redis.Int(master[1], nil)
+		if e1 != nil || e2 != nil {
+			return nil, ErrBadSlotMap
+		}
+
+		masterAddr := net.JoinHostPort(host, strconv.Itoa(port))
+		for slot := start; slot <= end; slot++ {
+			slots[slot] = masterAddr
+		}
+	}
+
+	return
+}
+
+// A redis cluster pool: slot map plus conn pools of the discovered masters.
+type cluster struct {
+	*ClusterServerSelector
+
+	cf              *config.ConfigRedisServer // conn pool sizing of each node
+	healthCheckIdle time.Duration
+
+	mutex sync.Mutex
+	nodes map[string]*server // addr:server
+}
+
+func newCluster(seeds []string, cf *config.ConfigRedisServer,
+	healthCheckIdle time.Duration) *cluster {
+	this := &cluster{
+		ClusterServerSelector: new(ClusterServerSelector),
+		cf:                    cf,
+		healthCheckIdle:       healthCheckIdle,
+		nodes:                 make(map[string]*server),
+	}
+	this.refreshed = this.prune
+	if err := this.SetServers(seeds...); err != nil {
+		// slots will be learned from redirects
+		log.Error("redis cluster%+v: %s", seeds, err)
+	}
+
+	return this
+}
+
+// node returns conn pool of a node, created on first use.
+func (this *cluster) node(addr string) *server {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	svr, present := this.nodes[addr]
+	if !present {
+		svr = newServer(addr, this.cf, this.healthCheckIdle)
+		this.nodes[addr] = svr
+	}
+	return svr
+}
+
+// prune closes conn pools of the nodes that left the cluster, i.e.
+// neither a seed nor a master in the current slot map.
+func (this *cluster) prune() {
+	alive := make(map[string]bool)
+	for _, addr := range this.nodeAddrs() {
+		alive[addr] = true
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for addr, svr := range this.nodes {
+		if !alive[addr] {
+			log.Info("redis cluster node[%s] gone", addr)
+
+			delete(this.nodes, addr)
+			svr.close()
+		}
+	}
+}
+
+func (this *cluster) close() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for addr, svr := range this.nodes {
+		delete(this.nodes, addr)
+		svr.close()
+	}
+}
+
+func (this *cluster) stats() map[string]interface{} {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	r := make(map[string]interface{}, len(this.nodes))
+	for addr, svr := range this.nodes {
+		r[addr] = svr.stats()
+	}
+	return r
+}
+
+// redirect parses "MOVED 3999 127.0.0.1:6381" or "ASK 3999 127.0.0.1:6381".
+func redirect(err error) (ask bool, slot int, addr string, ok bool) {
+	e, isReply := err.(redis.Error)
+	if !isReply {
+		return
+	}
+
+	fields := strings.Fields(string(e))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return
+	}
+
+	slot, e2 := strconv.Atoi(fields[1])
+	if e2 != nil {
+		return
+	}
+
+	return fields[0] == "ASK", slot, fields[2], true
+}
+
+// withClusterConn runs fn on the node that owns the slot, following
+// MOVED/ASK redirects at most ClusterMaxRedirects times.
+func (this *Client) withClusterConn(cl *cluster, addr string,
+	fn func(conn redis.Conn) (interface{}, error)) (reply interface{}, err error) {
+	asking := false
+	for i := 0; ; i++ {
+		reply, err = this.withServer(cl.node(addr), func(conn redis.Conn) (interface{}, error) {
+			if asking {
+				// the slot is being migrated, target node serves it only after ASKING
+				if _, err := conn.Do("ASKING"); err != nil {
+					return nil, err
+				}
+			}
+
+			return fn(conn)
+		})
+
+		ask, slot, target, ok := redirect(err)
+		if !ok {
+			return
+		}
+
+		if i >= this.cf.ClusterMaxRedirects {
+			log.Error("redis cluster too many redirects: %s", err)
+			return nil, ErrTooManyRedirects
+		}
+
+		if !ask {
+			cl.moved(slot, target)
+		}
+		addr, asking = target, ask
+	}
+}
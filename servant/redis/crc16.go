@@ -0,0 +1,46 @@
+package redis
+
+import (
+	"strings"
+)
+
+// number of hash slots of redis cluster
+const clusterSlots = 16384
+
+var crc16Table [256]uint16
+
+func init() {
+	// CRC16-CCITT(XMODEM), polynomial 0x1021
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		crc16Table[i] = crc
+	}
+}
+
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+// Slot returns the redis cluster hash slot of a key.
+// If key contains a non-empty {hashtag}, only the hashtag is hashed so
+// that related keys can be put on the same slot.
+func Slot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	return int(crc16(key)) % clusterSlots
+}
@@ -2,10 +2,28 @@ package redis
 
 import (
 	"errors"
+	"github.com/funkygao/redigo/redis"
 )
 
 var (
-	ErrCircuitOpen  = errors.New("redis: circuit open")
-	ErrPoolNotFound = errors.New("redis pool not found")
-	ErrKeyNotExist  = errors.New("key not exists")
+	ErrCircuitOpen   = errors.New("redis: circuit open")
+	ErrPoolNotFound  = errors.New("redis pool not found")
+	ErrKeyNotExist   = errors.New("key not exists")
+	ErrInvalidKey    = errors.New("redis: key must be string")
+	ErrCrossServer   = errors.New("redis: keys span multiple servers")
+	ErrEmptyTxn      = errors.New("redis: empty transaction")
+	ErrPoolExhausted = errors.New("redis: conn pool exhausted")
+	ErrNoSentinel    = errors.New("redis: no sentinel knows the master")
+
+	ErrCrossSlot        = errors.New("redis: keys span multiple cluster slots")
+	ErrNoClusterNode    = errors.New("redis: no cluster node reachable")
+	ErrBadSlotMap       = errors.New("redis: malformed CLUSTER SLOTS reply")
+	ErrTooManyRedirects = errors.New("redis: too many cluster redirects")
 )
+
+// Error reply from redis server, e,g. WRONGTYPE, is an app level error
+// that should not break the circuit.
+func IsReplyError(err error) bool {
+	_, ok := err.(redis.Error)
+	return ok
+}
@@ -0,0 +1,114 @@
+package redis
+
+import (
+	"errors"
+	"sync"
+)
+
+// A single command within a pipeline.
+type Command struct {
+	Name        string
+	KeysAndArgs []interface{}
+}
+
+// Reply of a single command within a pipeline.
+type Reply struct {
+	Val interface{}
+	Err error
+}
+
+// Pipeline sends cmds grouped by target server, each group as one
+// pipeline(Send/Flush/Receive) and all groups in parallel.
+// Replies are returned in the same order of cmds.
+func (this *Client) Pipeline(pool string, cmds []Command) ([]Reply, error) {
+	if this.breaker.Open() {
+		return nil, ErrCircuitOpen
+	}
+
+	// shardName:index of cmds
+	groups := make(map[string][]int)
+	for i, cmd := range cmds {
+		if len(cmd.KeysAndArgs) == 0 {
+			return nil, errors.New("redis cmd not implemented:" + cmd.Name)
+		}
+
+		key, ok := cmd.KeysAndArgs[0].(string)
+		if !ok {
+			return nil, ErrInvalidKey
+		}
+
+		name, err := this.shardName(pool, key)
+		if err != nil {
+			return nil, err
+		}
+
+		groups[name] = append(groups[name], i)
+	}
+
+	replies := make([]Reply, len(cmds))
+	var wg sync.WaitGroup
+	for name, idxs := range groups {
+		wg.Add(1)
+		go func(name string, idxs []int) {
+			defer wg.Done()
+
+			this.pipelineShard(pool, name, cmds, idxs, replies)
+		}(name, idxs)
+	}
+	wg.Wait()
+
+	return replies, nil
+}
+
+// each goroutine fills replies of its own idxs, so no lock on replies
+func (this *Client) pipelineShard(pool, name string, cmds []Command,
+	idxs []int, replies []Reply) {
+	fail := func(from int, err error) {
+		for _, i := range idxs[from:] {
+			replies[i].Err = err
+		}
+	}
+
+	// in cluster mode, redirects are not followed within pipeline: the
+	// reply carries the MOVED/ASK error and the slot map is refreshed
+	svr := this.server(pool, name)
+	conn, err := svr.get(this.cf.BorrowTimeout)
+	if err != nil {
+		if err != ErrPoolExhausted {
+			this.breaker.Fail()
+		}
+		fail(0, err)
+		return
+	}
+	defer svr.put(conn) // return to conn pool
+
+	for _, i := range idxs {
+		if err := conn.Send(cmds[i].Name, cmds[i].KeysAndArgs...); err != nil {
+			this.breaker.Fail()
+			fail(0, err)
+			return
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		this.breaker.Fail()
+		fail(0, err)
+		return
+	}
+
+	for j, i := range idxs {
+		replies[i].Val, replies[i].Err = conn.Receive()
+		if ask, slot, addr, ok := redirect(replies[i].Err); ok && !ask {
+			if cl, present := this.clusters[pool]; present {
+				cl.moved(slot, addr)
+			}
+		}
+		if replies[i].Err != nil && !IsReplyError(replies[i].Err) {
+			// conn broken, the remaining replies are lost
+			this.breaker.Fail()
+			fail(j, replies[i].Err)
+			return
+		}
+	}
+
+	this.breaker.Succeed()
+}
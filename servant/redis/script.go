@@ -0,0 +1,112 @@
+package redis
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/funkygao/redigo/redis"
+	"strings"
+)
+
+const scriptCacheMaxItems = 1 << 10
+
+// Eval runs lua script on the server that owns all the keys.
+// EVALSHA is tried first, and EVAL if the script is not loaded yet.
+func (this *Client) Eval(pool string, script string, keys []string,
+	args ...interface{}) (interface{}, error) {
+	if this.breaker.Open() {
+		return nil, ErrCircuitOpen
+	}
+
+	sha := this.scriptSha(script)
+
+	routingKeys := keys
+	if len(keys) == 0 {
+		// keyless script, e,g. return redis.call('TIME')
+		routingKeys = []string{sha}
+	}
+	name, err := this.sameShard(pool, routingKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	keysAndArgs := make([]interface{}, 0, 2+len(keys)+len(args))
+	keysAndArgs = append(keysAndArgs, sha, len(keys))
+	for _, key := range keys {
+		keysAndArgs = append(keysAndArgs, key)
+	}
+	keysAndArgs = append(keysAndArgs, args...)
+
+	return this.withConn(pool, name, false, func(conn redis.Conn) (interface{}, error) {
+		reply, err := conn.Do("EVALSHA", keysAndArgs...)
+		if e, ok := err.(redis.Error); ok && strings.HasPrefix(string(e), "NOSCRIPT") {
+			// EVAL will also load the script into server script cache
+			keysAndArgs[0] = script
+			reply, err = conn.Do("EVAL", keysAndArgs...)
+		}
+
+		return reply, err
+	})
+}
+
+func (this *Client) scriptSha(script string) string {
+	if sha, present := this.scripts.Get(script); present {
+		return sha.(string)
+	}
+
+	h := sha1.New()
+	h.Write([]byte(script))
+	sha := hex.EncodeToString(h.Sum(nil))
+	this.scripts.Set(script, sha)
+	return sha
+}
+
+// Multi atomically runs cmds inside MULTI/EXEC on a single conn.
+// The 1st arg of each cmd is the key and all keys must be on the same server.
+// Replies of EXEC are in the same order of cmds.
+func (this *Client) Multi(pool string, cmds []Command) ([]interface{}, error) {
+	if this.breaker.Open() {
+		return nil, ErrCircuitOpen
+	}
+
+	if len(cmds) == 0 {
+		return nil, ErrEmptyTxn
+	}
+
+	keys := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		if len(cmd.KeysAndArgs) == 0 {
+			return nil, ErrInvalidKey
+		}
+
+		key, ok := cmd.KeysAndArgs[0].(string)
+		if !ok {
+			return nil, ErrInvalidKey
+		}
+		keys[i] = key
+	}
+
+	name, err := this.sameShard(pool, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := this.withConn(pool, name, false, func(conn redis.Conn) (interface{}, error) {
+		if err := conn.Send("MULTI"); err != nil {
+			return nil, err
+		}
+		for _, cmd := range cmds {
+			if err := conn.Send(cmd.Name, cmd.KeysAndArgs...); err != nil {
+				return nil, err
+			}
+		}
+
+		// Do flushes and reads +OK, +QUEUED... and EXEC reply in turn
+		// if any cmd fails to queue, EXECABORT error is returned
+		return conn.Do("EXEC")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return redis.Values(reply, nil)
+}
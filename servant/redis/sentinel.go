@@ -0,0 +1,125 @@
+package redis
+
+import (
+	log "github.com/funkygao/log4go"
+	"github.com/funkygao/redigo/redis"
+	"net"
+	"strings"
+	"time"
+)
+
+const sentinelTimeout = time.Second
+
+// runSentinelWatchdog follows the master of each shard reported by
+// sentinels, and rebuilds the shard conn pools on failover.
+func (this *Client) runSentinelWatchdog() {
+	if len(this.cf.Sentinels) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(this.cf.SentinelInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.quit:
+			return
+
+		case <-ticker.C:
+			this.followSentinels()
+		}
+	}
+}
+
+func (this *Client) followSentinels() {
+	for pool, shards := range this.shards {
+		for name, sh := range shards {
+			master, replicas, err := this.sentinelDiscover(name)
+			if err != nil {
+				log.Error("redis[%s] sentinel shard[%s]: %s", pool, name, err)
+				continue
+			}
+
+			if master != sh.masterAddr() ||
+				!sameAddrs(replicas, sh.replicaAddrs()) {
+				sh.failover(master, replicas)
+			}
+		}
+	}
+}
+
+// sentinelDiscover asks sentinels one by one for the current master
+// and healthy replicas of a shard.
+func (this *Client) sentinelDiscover(name string) (master string,
+	replicas []string, err error) {
+	var conn redis.Conn
+	for _, sentinel := range this.cf.Sentinels {
+		conn, err = redis.DialTimeout("tcp", sentinel,
+			sentinelTimeout, sentinelTimeout, sentinelTimeout)
+		if err != nil {
+			continue
+		}
+
+		master, replicas, err = this.querySentinel(conn, name)
+		conn.Close()
+		if err == nil {
+			return
+		}
+	}
+
+	if err == nil {
+		err = ErrNoSentinel
+	}
+	return
+}
+
+func (this *Client) querySentinel(conn redis.Conn, name string) (master string,
+	replicas []string, err error) {
+	hostPort, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", name))
+	if err != nil {
+		return
+	}
+	if len(hostPort) != 2 {
+		return "", nil, ErrNoSentinel
+	}
+	master = net.JoinHostPort(hostPort[0], hostPort[1])
+
+	slaves, err := redis.Values(conn.Do("SENTINEL", "slaves", name))
+	if err != nil {
+		return
+	}
+	for _, slave := range slaves {
+		info, e := redis.StringMap(slave, nil)
+		if e != nil {
+			continue
+		}
+
+		flags := info["flags"]
+		if strings.Contains(flags, "s_down") ||
+			strings.Contains(flags, "o_down") ||
+			strings.Contains(flags, "disconnected") {
+			continue
+		}
+
+		replicas = append(replicas, net.JoinHostPort(info["ip"], info["port"]))
+	}
+
+	return
+}
+
+func sameAddrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	m := make(map[string]bool, len(a))
+	for _, addr := range a {
+		m[addr] = true
+	}
+	for _, addr := range b {
+		if !m[addr] {
+			return false
+		}
+	}
+	return true
+}
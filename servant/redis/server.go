@@ -0,0 +1,118 @@
+package redis
+
+import (
+	"github.com/funkygao/fae/config"
+	"github.com/funkygao/redigo/redis"
+	"sync/atomic"
+	"time"
+)
+
+// A single redis server.
+//
+// Each call borrows its own conn, so calls to the same server run
+// concurrently. In-flight calls are bounded by MaxActive, and borrowers
+// wait at most borrowTimeout for a free slot.
+type server struct {
+	addr string
+	pool *redis.Pool
+
+	throttle chan struct{} // nil means unbounded
+
+	calls        int64
+	inflight     int64
+	waits        int64 // borrow had to wait for a free slot
+	waitTimeouts int64
+	waitNanos    int64 // cumulated
+}
+
+func newServer(addr string, cf *config.ConfigRedisServer,
+	healthCheckIdle time.Duration) *server {
+	this := &server{addr: addr}
+	if cf.MaxActive > 0 {
+		this.throttle = make(chan struct{}, cf.MaxActive)
+	}
+
+	this.pool = &redis.Pool{
+		MaxIdle:     cf.MaxIdle,
+		MaxActive:   0, // bounded by throttle instead
+		IdleTimeout: cf.IdleTimeout,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr)
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			// only conns idle for a while are health checked
+			if time.Since(t) < healthCheckIdle {
+				return nil
+			}
+
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+
+	return this
+}
+
+func (this *server) get(borrowTimeout time.Duration) (redis.Conn, error) {
+	if this.throttle != nil {
+		select {
+		case this.throttle <- struct{}{}:
+
+		default:
+			// MaxActive exhausted, bounded wait
+			atomic.AddInt64(&this.waits, 1)
+			t0 := time.Now()
+			timer := time.NewTimer(borrowTimeout)
+			select {
+			case this.throttle <- struct{}{}:
+				timer.Stop()
+				atomic.AddInt64(&this.waitNanos, int64(time.Since(t0)))
+
+			case <-timer.C:
+				atomic.AddInt64(&this.waitTimeouts, 1)
+				return nil, ErrPoolExhausted
+			}
+		}
+	}
+
+	atomic.AddInt64(&this.calls, 1)
+	atomic.AddInt64(&this.inflight, 1)
+	conn := this.pool.Get()
+	if err := conn.Err(); err != nil {
+		this.put(conn)
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// put returns conn to pool and releases the in-flight slot.
+func (this *server) put(conn redis.Conn) {
+	conn.Close()
+	atomic.AddInt64(&this.inflight, -1)
+	if this.throttle != nil {
+		<-this.throttle
+	}
+}
+
+func (this *server) stats() map[string]interface{} {
+	var (
+		waits   = atomic.LoadInt64(&this.waits)
+		avgWait time.Duration
+	)
+	if waits > 0 {
+		avgWait = time.Duration(atomic.LoadInt64(&this.waitNanos) / waits)
+	}
+
+	return map[string]interface{}{
+		"calls":        atomic.LoadInt64(&this.calls),
+		"inflight":     atomic.LoadInt64(&this.inflight),
+		"waits":        waits,
+		"waitTimeouts": atomic.LoadInt64(&this.waitTimeouts),
+		"avgWait":      avgWait.String(),
+	}
+}
+
+func (this *server) close() {
+	this.pool.Close()
+}
@@ -0,0 +1,153 @@
+package redis
+
+import (
+	"github.com/funkygao/fae/config"
+	log "github.com/funkygao/log4go"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// commands that can be served by replicas when caller tolerates staleness
+var readOnlyCmds = map[string]bool{
+	"EXISTS": true, "TTL": true, "PTTL": true, "TYPE": true,
+	"GET": true, "MGET": true, "STRLEN": true, "GETRANGE": true,
+	"HGET": true, "HMGET": true, "HGETALL": true, "HKEYS": true,
+	"HVALS": true, "HLEN": true, "HEXISTS": true, "HSCAN": true,
+	"LLEN": true, "LRANGE": true, "LINDEX": true,
+	"SCARD": true, "SMEMBERS": true, "SISMEMBER": true,
+	"SRANDMEMBER": true, "SSCAN": true,
+	"ZCARD": true, "ZCOUNT": true, "ZRANGE": true, "ZRANGEBYSCORE": true,
+	"ZREVRANGE": true, "ZREVRANGEBYSCORE": true, "ZRANK": true,
+	"ZREVRANK": true, "ZSCORE": true, "ZSCAN": true,
+}
+
+func isReadOnly(cmd string) bool {
+	return readOnlyCmds[strings.ToUpper(cmd)]
+}
+
+// A shard of a redis pool: a master with optional replicas.
+// The shard name is stable, while its master can change on failover.
+type shard struct {
+	name            string
+	cf              *config.ConfigRedisServer
+	healthCheckIdle time.Duration
+
+	mutex    sync.RWMutex
+	master   *server
+	replicas []*server
+
+	nextReplica uint32 // round robin
+}
+
+func newShard(cf *config.ConfigRedisServer, healthCheckIdle time.Duration) *shard {
+	this := &shard{name: cf.Name, cf: cf, healthCheckIdle: healthCheckIdle}
+	this.master = newServer(cf.Addr, cf, healthCheckIdle)
+	this.replicas = make([]*server, 0, len(cf.Replicas))
+	for _, addr := range cf.Replicas {
+		this.replicas = append(this.replicas,
+			newServer(addr, cf, healthCheckIdle))
+	}
+
+	return this
+}
+
+func (this *shard) pick(replica bool) *server {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	if !replica || len(this.replicas) == 0 {
+		return this.master
+	}
+
+	idx := atomic.AddUint32(&this.nextReplica, 1) % uint32(len(this.replicas))
+	return this.replicas[idx]
+}
+
+func (this *shard) masterAddr() string {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	return this.master.addr
+}
+
+func (this *shard) replicaAddrs() []string {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	r := make([]string, len(this.replicas))
+	for i, svr := range this.replicas {
+		r[i] = svr.addr
+	}
+	return r
+}
+
+// failover rebuilds the conn pools of the shard to follow the new topology.
+// Pools whose addr is unchanged are kept.
+func (this *shard) failover(masterAddr string, replicaAddrs []string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	old := make(map[string]*server)
+	old[this.master.addr] = this.master
+	for _, svr := range this.replicas {
+		old[svr.addr] = svr
+	}
+
+	reuse := func(addr string) *server {
+		if svr, present := old[addr]; present {
+			delete(old, addr)
+			return svr
+		}
+
+		return newServer(addr, this.cf, this.healthCheckIdle)
+	}
+
+	log.Warn("redis shard[%s] failover: master %s -> %s, replicas %+v",
+		this.name, this.master.addr, masterAddr, replicaAddrs)
+
+	this.master = reuse(masterAddr)
+	replicas := make([]*server, 0, len(replicaAddrs))
+	for _, addr := range replicaAddrs {
+		if addr != masterAddr {
+			replicas = append(replicas, reuse(addr))
+		}
+	}
+	this.replicas = replicas
+
+	// in-flight calls on the gone servers still hold their own conns
+	for _, svr := range old {
+		svr.close()
+	}
+}
+
+func (this *shard) close() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.master.close()
+	for _, svr := range this.replicas {
+		svr.close()
+	}
+}
+
+func (this *shard) servers() []*server {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	return append([]*server{this.master}, this.replicas...)
+}
+
+func (this *shard) stats() map[string]interface{} {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	replicas := make(map[string]interface{})
+	for _, svr := range this.replicas {
+		replicas[svr.addr] = svr.stats()
+	}
+
+	return map[string]interface{}{
+		"master":      this.master.addr,
+		"masterStats": this.master.stats(),
+		"replicas":    replicas,
+	}
+}
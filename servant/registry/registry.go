@@ -0,0 +1,426 @@
+// Package registry lets applications register and discover endpoints
+// of their own services, e,g. internal http services.
+//
+// Each endpoint is an ephemeral zk node under root/service whose data
+// is the json encoded Endpoint. Like election, faed holds the nodes
+// under a lease that the registrant renews by registering again within
+// ttl.
+//
+// Endpoints of a service are cached and watched once it is looked up,
+// so lookups never hit zk afterwards, till it is not looked up for
+// idleTimeout.
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/funkygao/fae/servant/zk"
+	log "github.com/funkygao/log4go"
+	"hash/fnv"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrInvalidName = errors.New("registry: invalid service or endpoint")
+
+type Endpoint struct {
+	Addr string            `json:"addr"`
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+// The subset of zk that registry needs.
+type Store interface {
+	CreateAll(path string) error
+	Create(path string, data []byte, flags int32) (string, error)
+	Get(path string) ([]byte, int32, error)
+	Exists(path string) (bool, int32, error)
+	ExistsW(path string) (bool, <-chan struct{}, error)
+	ChildrenW(path string) ([]string, <-chan struct{}, error)
+	Delete(path string, version int32) error
+	Czxid(path string) (int64, error)
+	DeleteCreated(path string, czxid int64) error
+}
+
+type lease struct {
+	service string
+	data    []byte
+	czxid   int64 // of the node we created
+	expires time.Time
+}
+
+// local cache of a watched service
+type service struct {
+	mutex     sync.RWMutex
+	version   int64
+	endpoints []Endpoint
+	changed   chan struct{} // closed on each change
+
+	lastLookup int64         // UnixNano, atomic
+	quit       chan struct{} // closed when no longer watched
+}
+
+type Registry struct {
+	store       Store
+	root        string
+	maxTtl      time.Duration
+	idleTimeout time.Duration
+
+	mutex    sync.Mutex
+	leases   map[string]*lease // key is node path
+	services map[string]*service
+}
+
+func New(store Store, root string, maxTtl,
+	idleTimeout time.Duration) *Registry {
+	this := &Registry{
+		store:       store,
+		root:        strings.TrimRight(root, "/"),
+		maxTtl:      maxTtl,
+		idleTimeout: idleTimeout,
+		leases:      make(map[string]*lease),
+		services:    make(map[string]*service),
+	}
+	go this.runJanitor()
+	return this
+}
+
+// Register adds the endpoint or renews its lease, ttl is capped by maxTtl.
+func (this *Registry) Register(svc string, addr string,
+	meta map[string]string, ttl time.Duration) error {
+	if !validName(svc) || addr == "" {
+		return ErrInvalidName
+	}
+	if ttl <= 0 || ttl > this.maxTtl {
+		ttl = this.maxTtl
+	}
+
+	data, _ := json.Marshal(Endpoint{Addr: addr, Meta: meta})
+	path := this.dir(svc) + "/" + url.QueryEscape(addr)
+
+	// zk round trips are out of the lock, not to serialize registrations
+	this.mutex.Lock()
+	l := this.leases[path]
+	this.mutex.Unlock()
+
+	if l != nil && string(l.data) == string(data) {
+		// ephemerals are gone with an expired zk session
+		exists, _, err := this.store.Exists(path)
+		if err != nil {
+			return err
+		}
+		if exists {
+			this.mutex.Lock()
+			l.expires = time.Now().Add(ttl)
+			this.mutex.Unlock()
+			return nil
+		}
+	}
+
+	czxid, err := this.publish(svc, path, data)
+	if err != nil {
+		return err
+	}
+
+	this.mutex.Lock()
+	if cur := this.leases[path]; cur != nil && cur != l && cur.czxid > czxid {
+		// published by a concurrent call meanwhile, which replaced our node
+		this.mutex.Unlock()
+		return nil
+	}
+	this.leases[path] = &lease{service: svc, data: data, czxid: czxid,
+		expires: time.Now().Add(ttl)}
+	this.mutex.Unlock()
+
+	log.Debug("registry[%s] %s registered: %+v", svc, addr, meta)
+	return nil
+}
+
+// publish (re)creates the node and returns its czxid, meta change is
+// published as delete and create, so that children watchers notice it.
+//
+// The node can be replaced by another faed the registrant renews through,
+// so a lease only ever deletes the node of its own czxid.
+func (this *Registry) publish(svc, path string, data []byte) (int64, error) {
+	if err := this.store.CreateAll(this.dir(svc)); err != nil {
+		return 0, err
+	}
+
+	if err := this.store.Delete(path, zk.NoVersion); err != nil &&
+		err != zk.ErrNoNode {
+		return 0, err
+	}
+
+	if _, err := this.store.Create(path, data, zk.FlagEphemeral); err != nil {
+		return 0, err
+	}
+
+	return this.store.Czxid(path)
+}
+
+func (this *Registry) Deregister(svc string, addr string) (bool, error) {
+	path := this.dir(svc) + "/" + url.QueryEscape(addr)
+
+	this.mutex.Lock()
+	l, present := this.leases[path]
+	delete(this.leases, path)
+	this.mutex.Unlock()
+
+	if !present {
+		return false, nil
+	}
+
+	log.Debug("registry[%s] %s deregistered", svc, addr)
+	return true, this.remove(path, l.czxid)
+}
+
+// Endpoints returns healthy endpoints of the service sorted by addr, and
+// the version of them, which is the same across faeds.
+// If version equals lastVersion, it waits for change at most wait.
+func (this *Registry) Endpoints(svc string, lastVersion int64,
+	wait time.Duration) (endpoints []Endpoint, version int64, err error) {
+	if !validName(svc) {
+		return nil, 0, ErrInvalidName
+	}
+
+	s, err := this.service(svc)
+	if err != nil {
+		return
+	}
+
+	s.mutex.RLock()
+	endpoints, version, changed := s.endpoints, s.version, s.changed
+	s.mutex.RUnlock()
+	if version != lastVersion || wait <= 0 {
+		return
+	}
+
+	select {
+	case <-changed:
+		s.mutex.RLock()
+		endpoints, version = s.endpoints, s.version
+		s.mutex.RUnlock()
+
+	case <-time.After(wait):
+	}
+
+	return
+}
+
+// Services returns locally cached endpoints of looked up services.
+func (this *Registry) Services() map[string][]Endpoint {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	r := make(map[string][]Endpoint, len(this.services))
+	for name, s := range this.services {
+		s.mutex.RLock()
+		r[name] = s.endpoints
+		s.mutex.RUnlock()
+	}
+	return r
+}
+
+// service returns the cached service, it loads and starts watching it on
+// the first lookup.
+func (this *Registry) service(svc string) (*service, error) {
+	this.mutex.Lock()
+	s, present := this.services[svc]
+	this.mutex.Unlock()
+	if present {
+		atomic.StoreInt64(&s.lastLookup, time.Now().UnixNano())
+		return s, nil
+	}
+
+	endpoints, changed, err := this.load(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if s, present = this.services[svc]; present {
+		// loaded by others meanwhile
+		atomic.StoreInt64(&s.lastLookup, time.Now().UnixNano())
+		return s, nil
+	}
+
+	s = &service{changed: make(chan struct{}), quit: make(chan struct{}),
+		lastLookup: time.Now().UnixNano()}
+	s.set(endpoints)
+	this.services[svc] = s
+	go this.watch(svc, s, changed)
+	return s, nil
+}
+
+// load reads all endpoints of the service and watches its children, or
+// the creation of its dir if nothing is registered yet.
+func (this *Registry) load(svc string) ([]Endpoint, <-chan struct{}, error) {
+	dir := this.dir(svc)
+	children, changed, err := this.store.ChildrenW(dir)
+	for err == zk.ErrNoNode {
+		var exists bool
+		if exists, changed, err = this.store.ExistsW(dir); err != nil {
+			return nil, nil, err
+		}
+		if !exists {
+			return []Endpoint{}, changed, nil
+		}
+
+		// created meanwhile
+		children, changed, err = this.store.ChildrenW(dir)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	endpoints := make([]Endpoint, 0, len(children))
+	for _, child := range children {
+		data, _, err := this.store.Get(dir + "/" + child)
+		if err == zk.ErrNoNode {
+			// gone meanwhile, the watch fires soon
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var ep Endpoint
+		if err = json.Unmarshal(data, &ep); err != nil {
+			log.Warn("registry[%s] %s: %s", svc, child, err)
+			continue
+		}
+		endpoints = append(endpoints, ep)
+	}
+
+	sort.Sort(endpointsByAddr(endpoints))
+	return endpoints, changed, nil
+}
+
+// watch keeps the service cache up to date till it is no longer watched.
+// zk watches can't be cancelled, a pending one just fires in vain.
+func (this *Registry) watch(svc string, s *service, changed <-chan struct{}) {
+	const retryInterval = time.Second
+	for {
+		select {
+		case <-changed:
+		case <-s.quit:
+			return
+		}
+
+		var (
+			endpoints []Endpoint
+			err       error
+		)
+		for {
+			if endpoints, changed, err = this.load(svc); err == nil {
+				break
+			}
+
+			log.Error("registry[%s] watch: %s", svc, err)
+			select {
+			case <-time.After(retryInterval):
+			case <-s.quit:
+				return
+			}
+		}
+
+		if s.set(endpoints) {
+			log.Info("registry[%s] endpoints changed: %d", svc, len(endpoints))
+		}
+	}
+}
+
+// set replaces the endpoints and wakes up waiters if they change.
+func (this *service) set(endpoints []Endpoint) bool {
+	data, _ := json.Marshal(endpoints)
+	h := fnv.New64a()
+	h.Write(data)
+	version := int64(h.Sum64())
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if version == this.version && this.endpoints != nil {
+		return false
+	}
+
+	this.version, this.endpoints = version, endpoints
+	close(this.changed)
+	this.changed = make(chan struct{})
+	return true
+}
+
+func (this *Registry) runJanitor() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for _ = range ticker.C {
+		this.expireLeases()
+		this.expireServices()
+	}
+}
+
+// expireServices stops watching services not looked up for idleTimeout.
+func (this *Registry) expireServices() {
+	now := time.Now().UnixNano()
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for name, s := range this.services {
+		if time.Duration(now-atomic.LoadInt64(&s.lastLookup)) >
+			this.idleTimeout {
+			log.Debug("registry[%s] idle, unwatched", name)
+
+			delete(this.services, name)
+			close(s.quit)
+		}
+	}
+}
+
+func (this *Registry) expireLeases() {
+	expired := make(map[string]int64) // path:czxid
+	now := time.Now()
+	this.mutex.Lock()
+	for path, l := range this.leases {
+		if now.After(l.expires) {
+			log.Warn("registry[%s] %s lease expired", l.service, path)
+
+			delete(this.leases, path)
+			expired[path] = l.czxid
+		}
+	}
+	this.mutex.Unlock()
+
+	for path, czxid := range expired {
+		if err := this.remove(path, czxid); err != nil {
+			log.Error("registry[%s]: %s", path, err)
+		}
+	}
+}
+
+// remove deletes the node if it is still the one we created.
+func (this *Registry) remove(path string, czxid int64) error {
+	err := this.store.DeleteCreated(path, czxid)
+	if err == zk.ErrNoNode {
+		err = nil
+	}
+	return err
+}
+
+func (this *Registry) dir(svc string) string {
+	return this.root + "/" + svc
+}
+
+func validName(name string) bool {
+	return name != "" && !strings.Contains(name, "/")
+}
+
+type endpointsByAddr []Endpoint
+
+func (this endpointsByAddr) Len() int           { return len(this) }
+func (this endpointsByAddr) Less(i, j int) bool { return this[i].Addr < this[j].Addr }
+func (this endpointsByAddr) Swap(i, j int)      { this[i], this[j] = this[j], this[i] }
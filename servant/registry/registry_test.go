@@ -0,0 +1,117 @@
+package registry
+
+import (
+	"fmt"
+	"github.com/funkygao/assert"
+	"github.com/funkygao/fae/servant/zk/zktest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRegisterAndEndpoints(t *testing.T) {
+	r := New(zktest.NewStore(), "/fae/services", time.Minute, time.Minute)
+
+	assert.Equal(t, nil, r.Register("user", "10.0.0.1:80",
+		map[string]string{"dc": "us"}, time.Minute))
+	eps, v1, err := r.Endpoints("user", 0, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(eps))
+	assert.Equal(t, "us", eps[0].Meta["dc"])
+
+	// no change: wait till timeout
+	t0 := time.Now()
+	_, v, _ := r.Endpoints("user", v1, 50*time.Millisecond)
+	assert.Equal(t, v1, v)
+	assert.Equal(t, true, time.Since(t0) >= 50*time.Millisecond)
+
+	// long poll wakes up on change
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		r.Register("user", "http://10.0.0.2/api", nil, time.Minute)
+	}()
+	eps, v2, _ := r.Endpoints("user", v1, 5*time.Second)
+	assert.NotEqual(t, v1, v2)
+	assert.Equal(t, 2, len(eps))
+	assert.Equal(t, "10.0.0.1:80", eps[0].Addr)
+	assert.Equal(t, "http://10.0.0.2/api", eps[1].Addr)
+
+	deregistered, _ := r.Deregister("user", "10.0.0.1:80")
+	assert.Equal(t, true, deregistered)
+	waitVersion(t, r, "user", v2)
+	eps, _, _ = r.Endpoints("user", 0, 0)
+	assert.Equal(t, 1, len(eps))
+
+	// version is derived from endpoints, same across faeds
+	r2 := New(zktest.NewStore(), "/fae/services", time.Minute, time.Minute)
+	r2.Register("user", "http://10.0.0.2/api", nil, time.Minute)
+	_, v3, _ := r.Endpoints("user", 0, 0)
+	_, v4, _ := r2.Endpoints("user", 0, 0)
+	assert.Equal(t, v3, v4)
+}
+
+func TestLeaseExpire(t *testing.T) {
+	r := New(zktest.NewStore(), "/fae/services", time.Minute, time.Minute)
+	r.Register("user", "10.0.0.1:80", nil, time.Minute)
+	_, v, _ := r.Endpoints("user", 0, 0)
+
+	for _, l := range r.leases {
+		l.expires = time.Now().Add(-time.Second)
+	}
+	r.expireLeases()
+	waitVersion(t, r, "user", v)
+
+	eps, _, _ := r.Endpoints("user", 0, 0)
+	assert.Equal(t, 0, len(eps))
+}
+
+func TestLeaseExpireAfterTakeover(t *testing.T) {
+	store := zktest.NewStore()
+	r1 := New(store, "/fae/services", time.Minute, time.Minute)
+	r2 := New(store, "/fae/services", time.Minute, time.Minute)
+
+	// registrant renews through another faed
+	r1.Register("user", "10.0.0.1:80", nil, time.Minute)
+	r2.Register("user", "10.0.0.1:80", nil, time.Minute)
+
+	for _, l := range r1.leases {
+		l.expires = time.Now().Add(-time.Second)
+	}
+	r1.expireLeases()
+
+	exists, _, _ := store.Exists("/fae/services/user/10.0.0.1%3A80")
+	assert.Equal(t, true, exists)
+}
+
+func TestLookupBeforeRegister(t *testing.T) {
+	store := zktest.NewStore()
+	r := New(store, "/fae/services", time.Minute, time.Minute)
+
+	// lookup never creates the dir
+	eps, v, err := r.Endpoints("order", 0, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(eps))
+	exists, _, _ := store.Exists("/fae/services/order")
+	assert.Equal(t, false, exists)
+
+	// but notices it once created
+	r2 := New(store, "/fae/services", time.Minute, time.Minute)
+	r2.Register("order", "10.0.0.1:80", nil, time.Minute)
+	waitVersion(t, r, "order", v)
+	eps, _, _ = r.Endpoints("order", 0, 0)
+	assert.Equal(t, 1, len(eps))
+
+	// idle services are no longer watched
+	r.mutex.Lock()
+	atomic.StoreInt64(&r.services["order"].lastLookup,
+		time.Now().Add(-2*time.Minute).UnixNano())
+	r.mutex.Unlock()
+	r.expireServices()
+	assert.Equal(t, 0, len(r.Services()))
+}
+
+func waitVersion(t *testing.T, r *Registry, svc string, last int64) {
+	if _, v, _ := r.Endpoints(svc, last, 5*time.Second); v == last {
+		t.Fatal(fmt.Sprintf("%s not changed", svc))
+	}
+}
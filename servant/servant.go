@@ -7,13 +7,18 @@ package servant
 import (
 	"github.com/funkygao/fae/config"
 	"github.com/funkygao/fae/servant/couch"
+	"github.com/funkygao/fae/servant/election"
+	"github.com/funkygao/fae/servant/gossip"
 	"github.com/funkygao/fae/servant/lock"
 	"github.com/funkygao/fae/servant/memcache"
 	"github.com/funkygao/fae/servant/mongo"
 	"github.com/funkygao/fae/servant/mysql"
 	"github.com/funkygao/fae/servant/proxy"
+	"github.com/funkygao/fae/servant/pubsub"
 	"github.com/funkygao/fae/servant/redis"
+	"github.com/funkygao/fae/servant/registry"
 	"github.com/funkygao/fae/servant/store"
+	"github.com/funkygao/fae/servant/zk"
 	"github.com/funkygao/golib/cache"
 	"github.com/funkygao/golib/gofmt"
 	"github.com/funkygao/golib/idgen"
@@ -33,6 +38,7 @@ type FunServantImpl struct {
 	startedAt time.Time
 	proxyMode bool
 	sessions  *cache.LruCache // state kept for sessions FIXME kill it
+	cursors   *cursorRegistry // server side db cursors bound to sessions
 
 	ctxReasonPercentage metrics.PercentCounter
 	digitNormalizer     *regexp.Regexp
@@ -41,6 +47,7 @@ type FunServantImpl struct {
 	mysqlMergeMutexMap *mutexmap.MutexMap
 	dbCacheStore       store.Store
 	dbCacheHits        metrics.PercentCounter
+	lcBigValues        map[string]metrics.Counter // key is reason
 
 	proxy *proxy.Proxy         // remote fae agent
 	idgen *idgen.IdGenerator   // global id generator
@@ -51,6 +58,13 @@ type FunServantImpl struct {
 	rd    *redis.Client        // redis pool, auto sharding by pool name
 	cb    *couch.Client        // couchbase client
 	lk    *lock.Lock           // cluster wise mutex lock
+	ps    *pubsub.Hub          // redis pub/sub bridge
+	zk    *zk.Client           // coordination on etcd servers
+	el    *election.Election   // leader election on zk
+	reg   *registry.Registry   // service registry on zk
+	gs    *gossip.Gossip       // membership and state among fae nodes
+
+	ephemerals *ephemeralRegistry // zk ephemeral nodes bound to sessions
 }
 
 func NewFunServant(cf *config.ConfigServant) (this *FunServantImpl) {
@@ -67,13 +81,29 @@ func NewFunServant(cf *config.ConfigServant) (this *FunServantImpl) {
 			return this.handleHttpQuery(w, req, params)
 		}).Methods("GET")
 
+	// e,g. curl -XPUT localhost:9101/svt/mongo/default/readonly
+	server.RegisterHttpApi("/svt/mongo/{pool}/{mode}",
+		func(w http.ResponseWriter, req *http.Request,
+			params map[string]interface{}) (interface{}, error) {
+			return this.handleMongoMode(w, req, params)
+		}).Methods("PUT")
+
 	this.sessions = cache.NewLruCache(cf.SessionMaxItems)
+	this.cursors = newCursorRegistry(cf.CursorMaxItems,
+		cf.CursorMaxMysqlItems, cf.CursorIdleTimeout)
 	this.mysqlMergeMutexMap = mutexmap.New(cf.Mysql.JsonMergeMaxOutstandingItems)
 
 	this.ctxReasonPercentage = metrics.NewPercentCounter()
 	metrics.Register("call.reason", this.ctxReasonPercentage)
 	this.dbCacheHits = metrics.NewPercentCounter()
 	metrics.Register("db.cache.hits", this.dbCacheHits)
+	this.lcBigValues = map[string]metrics.Counter{
+		"compressed": metrics.NewCounter(),
+		"rejected":   metrics.NewCounter(),
+	}
+	for reason, counter := range this.lcBigValues {
+		metrics.Register("lc.bigvalue."+reason, counter)
+	}
 
 	this.createServants()
 
@@ -85,6 +115,10 @@ func (this *FunServantImpl) Start() {
 	svtStats.registerMetrics()
 
 	go this.showStats()
+	if this.gs != nil {
+		this.gs.Join(this.conf.Gossip.Seeds)
+		go this.runGossipState()
+	}
 	go this.proxy.StartMonitorCluster()
 	go func() {
 		for {
@@ -126,6 +160,10 @@ func (this *FunServantImpl) warmUp() {
 
 func (this *FunServantImpl) Flush() {
 	log.Debug("servants flushing...")
+	if this.gs != nil {
+		// tell other nodes instead of waiting for failure detection
+		this.gs.Leave()
+	}
 	// TODO
 	this.my.Close()
 	log.Trace("servants flushed")
@@ -139,7 +177,26 @@ func (this *FunServantImpl) createServants() {
 		log.Debug("creating servant: proxy")
 		this.proxy = proxy.New(this.conf.Proxy)
 	} else {
-		panic("peers proxy required")
+		log.Warn("servant proxy disabled, running standalone")
+		this.proxy = proxy.New(config.NewStandaloneProxy(config.Engine.Rpc.ListenAddr))
+	}
+
+	if this.conf.Gossip.Enabled() {
+		log.Debug("creating servant: gossip")
+		var err error
+		// node name is the peer addr known to proxy
+		if this.gs, err = gossip.New(this.conf.Gossip,
+			this.conf.Proxy.SelfAddr); err != nil {
+			log.Error("gossip: %s", err)
+		} else {
+			this.gs.Subscribe(gossipTopicLcInvalidate, this.onLcInvalidate)
+		}
+	}
+	if this.conf.Proxy.Discovery == "gossip" {
+		if this.gs == nil {
+			panic("proxy gossip discovery requires gossip servant")
+		}
+		this.proxy.SetDiscovery(this.gs)
 	}
 
 	log.Debug("creating servant: idgen")
@@ -165,6 +222,11 @@ func (this *FunServantImpl) createServants() {
 		this.rd = redis.New(this.conf.Redis)
 	}
 
+	if this.conf.Pubsub.Enabled() && this.rd != nil {
+		log.Debug("creating servant: pubsub")
+		this.ps = pubsub.New(this.conf.Pubsub, this.conf.Redis, this.rd)
+	}
+
 	if this.conf.Lock.Enabled() {
 		log.Debug("creating servant: lock")
 		this.lk = lock.New(this.conf.Lock)
@@ -173,23 +235,17 @@ func (this *FunServantImpl) createServants() {
 	if this.conf.Mysql.Enabled() {
 		log.Debug("creating servant: mysql")
 		this.my = mysql.New(this.conf.Mysql)
+	}
 
-		switch this.conf.Mysql.CacheStore {
-		case "mem":
-			this.dbCacheStore = store.NewMemStore(this.conf.Mysql.CacheStoreMemMaxItems)
-
-		case "redis":
-			this.dbCacheStore = store.NewRedisStore(this.conf.Mysql.CacheStoreRedisPool,
-				this.conf.Redis)
-
-		default:
-			panic("unknown mysql cache store")
-		}
+	if this.conf.Mysql.Enabled() || this.conf.Mongodb.Enabled() {
+		this.dbCacheStore = newDbCacheStore(this.conf)
 	}
 
 	if this.conf.Mongodb.Enabled() {
 		log.Debug("creating servant: mongodb")
-		this.mg = mongo.New(this.conf.Mongodb)
+		if this.mg, err = mongo.New(this.conf.Mongodb); err != nil {
+			panic(err)
+		}
 		if this.conf.Mongodb.DebugProtocol ||
 			this.conf.Mongodb.DebugHeartbeat {
 			mgo.SetLogger(&mongoProtocolLogger{})
@@ -197,12 +253,27 @@ func (this *FunServantImpl) createServants() {
 		}
 	}
 
+	if len(config.Engine.EtcdServers) > 0 {
+		log.Debug("creating servant: zk")
+		if this.zk, err = zk.New(config.Engine.EtcdServers,
+			this.conf.ZkSessionTimeout); err != nil {
+			log.Error("zk: %s", err)
+		} else {
+			this.ephemerals = newEphemeralRegistry(this.conf.ZkMaxEphemerals,
+				this.conf.ZkEphemeralIdleTimeout, this.removeEphemeral)
+			this.el = election.New(this.zk, this.conf.ElectionRoot,
+				this.conf.ElectionMaxTtl)
+			this.reg = registry.New(this.zk, this.conf.RegistryRoot,
+				this.conf.RegistryMaxTtl, this.conf.RegistryIdleTimeout)
+		}
+	}
+
 	if this.conf.Couchbase.Enabled() {
 		log.Debug("creating servant: couchbase")
 
 		var err error
 		// pool is always 'default'
-		this.cb, err = couch.New(this.conf.Couchbase.Servers, "default")
+		this.cb, err = couch.New(this.conf.Couchbase, "default")
 		if err != nil {
 			log.Error("couchbase: %s", err)
 		}
@@ -240,40 +311,77 @@ func (this *FunServantImpl) recreateServants(cf *config.ConfigServant) {
 	if cf.Redis.Enabled() &&
 		!reflect.DeepEqual(*this.conf.Redis, *cf.Redis) {
 		log.Debug("recreating servant: redis")
+		rd := this.rd
 		this.rd = redis.New(cf.Redis)
+		if rd != nil {
+			// pubsub is switched to the new client below
+			defer rd.Close()
+		}
+	}
+
+	if cf.Pubsub.Enabled() && this.rd != nil &&
+		(!reflect.DeepEqual(*this.conf.Pubsub, *cf.Pubsub) ||
+			!reflect.DeepEqual(*this.conf.Redis, *cf.Redis)) {
+		log.Debug("recreating servant: pubsub")
+		if this.ps != nil {
+			this.ps.Close()
+		}
+		this.ps = pubsub.New(cf.Pubsub, cf.Redis, this.rd)
 	}
 
 	if cf.Mysql.Enabled() &&
 		!reflect.DeepEqual(*this.conf.Mysql, *cf.Mysql) {
 		log.Debug("recreating servant: mysql")
 		this.my = mysql.New(cf.Mysql)
-
-		switch cf.Mysql.CacheStore {
-		case "mem":
-			this.dbCacheStore = store.NewMemStore(cf.Mysql.CacheStoreMemMaxItems)
-
-		case "redis":
-			this.dbCacheStore = store.NewRedisStore(cf.Mysql.CacheStoreRedisPool,
-				cf.Redis)
-
-		default:
-			panic("unknown cache store")
-		}
 	}
 
-	if cf.Mongodb.Enabled() &&
+	if cf.Mongodb.Enabled() && this.mg != nil &&
+		this.conf.Mongodb.OnlyShardMapChanged(cf.Mongodb) {
+		log.Debug("reloading mongodb shard map")
+		if err := this.mg.SetShardMap(cf.Mongodb.ShardMap.Ranges); err != nil {
+			log.Error("mongodb shard map: %s", err)
+		}
+	} else if cf.Mongodb.Enabled() &&
 		!reflect.DeepEqual(*this.conf.Mongodb, *cf.Mongodb) {
 		log.Debug("recreating servant: mongodb")
-		this.mg = mongo.New(cf.Mongodb)
+		if mg, err := mongo.New(cf.Mongodb); err != nil {
+			// keep serving with the current one
+			log.Error("mongodb: %s", err)
+		} else {
+			if this.mg != nil {
+				// modes are set by operator at runtime, not in config
+				for pool, mode := range this.mg.Modes() {
+					if err := mg.SetMode(pool, mode); err != nil {
+						log.Warn("mongodb pool[%s] mode %s dropped: %s",
+							pool, mode, err)
+					}
+				}
+				this.mg.Close()
+			}
+			this.mg = mg
+		}
+	}
+
+	if (cf.Mysql.Enabled() || cf.Mongodb.Enabled()) &&
+		(this.dbCacheStore == nil || dbCacheStoreChanged(this.conf, cf)) {
+		log.Debug("recreating servant: db cache store")
+		if rs, ok := this.dbCacheStore.(*store.RedisStore); ok {
+			rs.Close()
+		}
+		this.dbCacheStore = newDbCacheStore(cf)
 	}
 
 	if cf.Couchbase.Enabled() &&
 		!reflect.DeepEqual(*this.conf.Couchbase, *cf.Couchbase) {
 		log.Debug("recreating servant: couchbase")
 
+		if this.cb != nil {
+			this.cb.Close()
+		}
+
 		var err error
 		// pool is always 'default'
-		this.cb, err = couch.New(cf.Couchbase.Servers, "default")
+		this.cb, err = couch.New(cf.Couchbase, "default")
 		if err != nil {
 			log.Error("couchbase: %s", err)
 		}
@@ -284,6 +392,36 @@ func (this *FunServantImpl) recreateServants(cf *config.ConfigServant) {
 	log.Info("servants recreated")
 }
 
+// db cache store is shared by mysql and mongodb, configured in mysql section.
+func newDbCacheStore(cf *config.ConfigServant) store.Store {
+	switch cf.Mysql.CacheStore {
+	case "mem", "":
+		maxItems := cf.Mysql.CacheStoreMemMaxItems
+		if maxItems == 0 {
+			// mysql section absent
+			maxItems = config.DefaultCacheStoreMemMaxItems
+		}
+		return store.NewMemStore(maxItems)
+
+	case "redis":
+		return store.NewRedisStore(cf.Mysql.CacheStoreRedisPool, cf.Redis)
+
+	default:
+		panic("unknown db cache store")
+	}
+}
+
+func dbCacheStoreChanged(old, cf *config.ConfigServant) bool {
+	if old.Mysql.CacheStore != cf.Mysql.CacheStore ||
+		old.Mysql.CacheStoreMemMaxItems != cf.Mysql.CacheStoreMemMaxItems ||
+		old.Mysql.CacheStoreRedisPool != cf.Mysql.CacheStoreRedisPool {
+		return true
+	}
+
+	return cf.Mysql.CacheStore == "redis" &&
+		!reflect.DeepEqual(*old.Redis, *cf.Redis)
+}
+
 func (this *FunServantImpl) Runtime() map[string]interface{} {
 	r := make(map[string]interface{})
 	r["call.slow"] = svtStats.callsSlow
@@ -299,6 +437,14 @@ func (this *FunServantImpl) Runtime() map[string]interface{} {
 	for _, key := range this.dbCacheHits.Keys() {
 		r["dbcache["+key+"]"] = this.dbCacheHits.Percent(key)
 	}
+	for reason, counter := range this.lcBigValues {
+		r["lc.bigvalue["+reason+"]"] = counter.Count()
+	}
+	if this.mc != nil {
+		for reason, n := range this.mc.BigValueStats() {
+			r["mc.bigvalue["+reason+"]"] = n
+		}
+	}
 
 	return r
 }
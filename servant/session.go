@@ -5,12 +5,14 @@ import (
 	"github.com/funkygao/fae/servant/gen-go/fun/rpc"
 	"github.com/funkygao/golib/sampling"
 	log "github.com/funkygao/log4go"
+	"sync/atomic"
 	"time"
 )
 
 type session struct {
 	ctx      *rpc.Context // will stay the same during a session
 	profiler *profiler
+	lastCall int64 // unix nano, atomic
 }
 
 func (this *FunServantImpl) getSession(ctx *rpc.Context) *session {
@@ -28,7 +30,15 @@ func (this *FunServantImpl) getSession(ctx *rpc.Context) *session {
 			ctx.Rid, ctx.Reason)
 	}
 
-	return s.(*session)
+	sess := s.(*session)
+	atomic.StoreInt64(&sess.lastCall, time.Now().UnixNano())
+	return sess
+}
+
+// idle returns how long since the last call of the session.
+func (this *session) idle() time.Duration {
+	return time.Duration(time.Now().UnixNano() -
+		atomic.LoadInt64(&this.lastCall))
 }
 
 func (this *session) startProfiler() (*profiler, error) {
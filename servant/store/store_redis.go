@@ -30,3 +30,7 @@ func (this *RedisStore) Set(key string, val interface{}) {
 func (this *RedisStore) Del(key string) {
 	this.redis.Del(this.pool, key)
 }
+
+func (this *RedisStore) Close() {
+	this.redis.Close()
+}
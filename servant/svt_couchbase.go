@@ -2,8 +2,10 @@ package servant
 
 import (
 	"github.com/couchbase/gomemcached"
+	"github.com/funkygao/fae/servant/couch"
 	"github.com/funkygao/fae/servant/gen-go/fun/rpc"
 	log "github.com/funkygao/log4go"
+	"github.com/funkygao/thrift/lib/go/thrift"
 )
 
 // curl localhost:8091/pools/ | python -m json.tool
@@ -100,7 +102,8 @@ func (this *FunServantImpl) CbGet(ctx *rpc.Context, bucket string,
 // key can be up to 250 chars long, unique within a bucket
 // val can be up to 25MB in size
 func (this *FunServantImpl) CbSet(ctx *rpc.Context, bucket string,
-	key string, val []byte, expire int32) (ex error) {
+	key string, val []byte, expire int32,
+	durability *rpc.TCouchbaseDurability) (ex error) {
 	const IDENT = "cb.set"
 	if this.cb == nil {
 		ex = ErrServantNotStarted
@@ -115,13 +118,8 @@ func (this *FunServantImpl) CbSet(ctx *rpc.Context, bucket string,
 
 	svtStats.inc(IDENT)
 
-	b, err := this.cb.GetBucket(bucket)
-	if err != nil {
-		ex = err
-		return
-	}
-
-	ex = b.SetRaw(key, int(expire), val)
+	_, ex = this.cb.Store(bucket, gomemcached.SET, key, val, int(expire), 0,
+		couchDurability(durability))
 	if ex != nil {
 		log.Error("Q=%s %s: %s %s", IDENT, ctx.String(), key, ex)
 	}
@@ -134,7 +132,8 @@ func (this *FunServantImpl) CbSet(ctx *rpc.Context, bucket string,
 }
 
 func (this *FunServantImpl) CbAdd(ctx *rpc.Context, bucket string,
-	key string, val []byte, expire int32) (r bool, ex error) {
+	key string, val []byte, expire int32,
+	durability *rpc.TCouchbaseDurability) (r bool, ex error) {
 	const IDENT = "cb.add"
 	if this.cb == nil {
 		ex = ErrServantNotStarted
@@ -149,14 +148,16 @@ func (this *FunServantImpl) CbAdd(ctx *rpc.Context, bucket string,
 
 	svtStats.inc(IDENT)
 
-	b, err := this.cb.GetBucket(bucket)
-	if err != nil {
-		ex = err
-		return
-	}
+	_, ex = this.cb.Store(bucket, gomemcached.ADD, key, val, int(expire), 0,
+		couchDurability(durability))
+	switch ex {
+	case nil:
+		r = true
 
-	r, ex = b.AddRaw(key, int(expire), val)
-	if ex != nil {
+	case couch.ErrKeyExists:
+		ex = nil
+
+	default:
 		log.Error("Q=%s %s: %s %s", IDENT, ctx.String(), key, ex)
 	}
 
@@ -242,3 +243,269 @@ func (this *FunServantImpl) CbGets(ctx *rpc.Context, bucket string,
 
 	return
 }
+
+func (this *FunServantImpl) CbReplace(ctx *rpc.Context, bucket string,
+	key string, val []byte, expire int32,
+	durability *rpc.TCouchbaseDurability) (miss *rpc.TCouchbaseNotFound,
+	ex error) {
+	const IDENT = "cb.replace"
+	if this.cb == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	_, err = this.cb.Store(bucket, gomemcached.REPLACE, key, val,
+		int(expire), 0, couchDurability(durability))
+	if miss, _, ex = couchException(err); ex != nil {
+		log.Error("Q=%s %s: %s %s", IDENT, ctx.String(), key, ex)
+	}
+
+	profiler.do(IDENT, ctx,
+		"{b^%s k^%s v^%s exp^%d} {miss^%v}",
+		bucket, key, string(val), expire, miss != nil)
+
+	return
+}
+
+func (this *FunServantImpl) CbSetMulti(ctx *rpc.Context, bucket string,
+	items map[string][]byte, expire int32,
+	durability *rpc.TCouchbaseDurability) (r map[string]string, ex error) {
+	const IDENT = "cb.setMulti"
+	if this.cb == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	r = make(map[string]string)
+	for key, err := range this.cb.SetMulti(bucket, items, int(expire),
+		couchDurability(durability)) {
+		r[key] = err.Error()
+	}
+	if len(r) > 0 {
+		log.Error("Q=%s %s: %+v", IDENT, ctx.String(), r)
+	}
+
+	profiler.do(IDENT, ctx,
+		"{b^%s n^%d exp^%d} {failed^%d}",
+		bucket, len(items), expire, len(r))
+
+	return
+}
+
+func (this *FunServantImpl) CbGetsCas(ctx *rpc.Context, bucket string,
+	key string) (r *rpc.TCouchbaseCasData, miss *rpc.TCouchbaseNotFound,
+	ex error) {
+	const IDENT = "cb.getsCas"
+	if this.cb == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	data, cas, err := this.cb.GetsCas(bucket, key)
+	if miss, _, ex = couchException(err); ex != nil {
+		log.Error("Q=%s %s: %s %s", IDENT, ctx.String(), key, ex)
+	} else if miss == nil {
+		r = rpc.NewTCouchbaseCasData()
+		r.Data = data
+		r.Cas = int64(cas)
+	}
+
+	profiler.do(IDENT, ctx,
+		"{b^%s k^%s} {miss^%v cas^%d r^%s}",
+		bucket, key, miss != nil, cas, string(data))
+
+	return
+}
+
+func (this *FunServantImpl) CbCas(ctx *rpc.Context, bucket string,
+	key string, val []byte, expire int32, cas int64,
+	durability *rpc.TCouchbaseDurability) (r int64,
+	miss *rpc.TCouchbaseNotFound, casMismatch *rpc.TCouchbaseCasMismatch,
+	ex error) {
+	const IDENT = "cb.cas"
+	if this.cb == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	if cas <= 0 {
+		// 0 would be an unconditional set
+		ex = couch.ErrInvalidCas
+		log.Error("Q=%s %s: %s cas^%d", IDENT, ctx.String(), key, cas)
+		profiler.do(IDENT, ctx, "{b^%s k^%s cas^%d} {err^%v}",
+			bucket, key, cas, ex)
+		return
+	}
+
+	newCas, err := this.cb.Store(bucket, gomemcached.SET, key, val,
+		int(expire), uint64(cas), couchDurability(durability))
+	if miss, casMismatch, ex = couchException(err); ex != nil {
+		log.Error("Q=%s %s: %s %s", IDENT, ctx.String(), key, ex)
+	}
+	r = int64(newCas)
+
+	profiler.do(IDENT, ctx,
+		"{b^%s k^%s v^%s exp^%d cas^%d} {miss^%v mismatch^%v r^%d}",
+		bucket, key, string(val), expire, cas,
+		miss != nil, casMismatch != nil, r)
+
+	return
+}
+
+func (this *FunServantImpl) CbIncr(ctx *rpc.Context, bucket string,
+	key string, delta int64, initial int64, expire int32,
+	durability *rpc.TCouchbaseDurability) (r int64, ex error) {
+	return this.cbCounter("cb.incr", gomemcached.INCREMENT, ctx, bucket,
+		key, delta, initial, expire, durability)
+}
+
+func (this *FunServantImpl) CbDecr(ctx *rpc.Context, bucket string,
+	key string, delta int64, initial int64, expire int32,
+	durability *rpc.TCouchbaseDurability) (r int64, ex error) {
+	return this.cbCounter("cb.decr", gomemcached.DECREMENT, ctx, bucket,
+		key, delta, initial, expire, durability)
+}
+
+func (this *FunServantImpl) cbCounter(ident string,
+	op gomemcached.CommandCode, ctx *rpc.Context, bucket string,
+	key string, delta int64, initial int64, expire int32,
+	durability *rpc.TCouchbaseDurability) (r int64, ex error) {
+	if this.cb == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	svtStats.inc(ident)
+
+	if initial < 0 {
+		ex = couch.ErrInvalidCounter
+		log.Error("Q=%s %s: %s init^%d", ident, ctx.String(), key, initial)
+		profiler.do(ident, ctx, "{b^%s k^%s init^%d} {err^%v}",
+			bucket, key, initial, ex)
+		return
+	}
+
+	if delta < 0 {
+		// memcached deltas are unsigned
+		delta = -delta
+		if op == gomemcached.INCREMENT {
+			op = gomemcached.DECREMENT
+		} else {
+			op = gomemcached.INCREMENT
+		}
+	}
+
+	val, ex := this.cb.Counter(bucket, op, key, uint64(delta),
+		uint64(initial), int(expire), couchDurability(durability))
+	if ex != nil {
+		log.Error("Q=%s %s: %s %s", ident, ctx.String(), key, ex)
+	}
+	r = int64(val)
+
+	profiler.do(ident, ctx,
+		"{b^%s k^%s delta^%d init^%d exp^%d} {r^%d}",
+		bucket, key, delta, initial, expire, r)
+
+	return
+}
+
+func (this *FunServantImpl) CbTouch(ctx *rpc.Context, bucket string,
+	key string, expire int32) (miss *rpc.TCouchbaseNotFound, ex error) {
+	const IDENT = "cb.touch"
+	if this.cb == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	if miss, _, ex = couchException(this.cb.Touch(bucket, key,
+		int(expire))); ex != nil {
+		log.Error("Q=%s %s: %s %s", IDENT, ctx.String(), key, ex)
+	}
+
+	profiler.do(IDENT, ctx,
+		"{b^%s k^%s exp^%d} {miss^%v}",
+		bucket, key, expire, miss != nil)
+
+	return
+}
+
+func couchDurability(d *rpc.TCouchbaseDurability) (r couch.Durability) {
+	if d == nil {
+		return
+	}
+
+	if d.IsSetPersistTo() {
+		r.PersistTo = int(*d.PersistTo)
+	}
+	if d.IsSetReplicateTo() {
+		r.ReplicateTo = int(*d.ReplicateTo)
+	}
+	return
+}
+
+// couchException converts expected errors into thrift exceptions.
+func couchException(err error) (miss *rpc.TCouchbaseNotFound,
+	casMismatch *rpc.TCouchbaseCasMismatch, ex error) {
+	switch err {
+	case nil:
+
+	case couch.ErrNotFound:
+		miss = rpc.NewTCouchbaseNotFound()
+		miss.Message = thrift.StringPtr(err.Error())
+
+	case couch.ErrKeyExists:
+		casMismatch = rpc.NewTCouchbaseCasMismatch()
+		casMismatch.Message = thrift.StringPtr(err.Error())
+
+	default:
+		ex = err
+	}
+
+	return
+}
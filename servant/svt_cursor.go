@@ -0,0 +1,292 @@
+package servant
+
+import (
+	"github.com/funkygao/fae/servant/gen-go/fun/rpc"
+	"github.com/funkygao/fae/servant/mongo"
+	log "github.com/funkygao/log4go"
+	"labix.org/v2/mgo/bson"
+	"strings"
+)
+
+func (this *FunServantImpl) MgCursorOpen(ctx *rpc.Context,
+	pool string, table string, shardId int32,
+	query []byte, fields []byte, orderBy []string,
+	batchSize int32) (r *rpc.TMongoCursorBatch, degrade *rpc.TMongoDegrade,
+	ex error) {
+	const IDENT = "mg.cursor.open"
+
+	if this.mg == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	bsonQuery, err := mongo.UnmarshalIn(query)
+	if err != nil {
+		ex = err
+		return
+	}
+	var bsonFields bson.M
+	if !mongo.FieldsIsNil(fields) {
+		bsonFields, err = mongo.UnmarshalIn(fields)
+		if err != nil {
+			ex = err
+			return
+		}
+	}
+
+	sess, err := this.mongoSession(ctx, pool, shardId, false)
+	if err != nil {
+		degrade, _, ex = mongoModeException(err)
+		return
+	}
+
+	n := this.cursorBatchSize(batchSize)
+	q := sess.DB().C(table).Find(bsonQuery).Batch(n)
+	if bsonFields != nil {
+		q.Select(bsonFields)
+	}
+	if len(orderBy) > 0 {
+		q.Sort(orderBy...)
+	}
+
+	c := &mongoCursor{sess: sess, iter: q.Iter()}
+	entry, err := this.cursors.open(ctx.Rid, c, false)
+	if err != nil {
+		c.Close()
+		ex = err
+		log.Error("Q=%s %s {pool^%s table^%s}: %s", IDENT, ctx.String(),
+			pool, table, ex)
+		return
+	}
+
+	r, ex = this.mongoCursorNext(entry, n)
+	if ex != nil {
+		log.Error("Q=%s %s {pool^%s table^%s query^%v}: %s", IDENT,
+			ctx.String(), pool, table, bsonQuery, ex)
+	}
+
+	profiler.do(IDENT, ctx,
+		"{pool^%s table^%s query^%v fields^%v batch^%d} {err^%v cursor^%d}",
+		pool, table, bsonQuery, bsonFields, n, ex, entry.id)
+
+	return
+}
+
+func (this *FunServantImpl) MgCursorNext(ctx *rpc.Context, cursorId int64,
+	batchSize int32) (r *rpc.TMongoCursorBatch, ex error) {
+	const IDENT = "mg.cursor.next"
+
+	svtStats.inc(IDENT)
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	entry, ex := this.cursors.get(ctx.Rid, cursorId)
+	if ex == nil {
+		r, ex = this.mongoCursorNext(entry, this.cursorBatchSize(batchSize))
+	}
+	if ex != nil {
+		log.Error("Q=%s %s {cursor^%d}: %s", IDENT, ctx.String(),
+			cursorId, ex)
+	}
+
+	profiler.do(IDENT, ctx, "{cursor^%d batch^%d} {err^%v}",
+		cursorId, batchSize, ex)
+
+	return
+}
+
+func (this *FunServantImpl) MgCursorClose(ctx *rpc.Context,
+	cursorId int64) (ex error) {
+	const IDENT = "mg.cursor.close"
+
+	svtStats.inc(IDENT)
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	if entry, err := this.cursors.get(ctx.Rid, cursorId); err == nil {
+		this.cursors.remove(entry)
+	}
+
+	profiler.do(IDENT, ctx, "{cursor^%d}", cursorId)
+
+	return
+}
+
+// mongoCursorNext fetches a batch from a locked cursor entry and
+// closes the cursor once it is exhausted or broken.
+func (this *FunServantImpl) mongoCursorNext(entry *cursorEntry,
+	n int) (r *rpc.TMongoCursorBatch, err error) {
+	c, ok := entry.cursor.(*mongoCursor)
+	if !ok {
+		this.cursors.release(entry)
+		return nil, ErrCursorNotFound
+	}
+
+	docs, more, err := c.next(n, this.conf.MaxResultBytes)
+	if err != nil {
+		this.cursors.remove(entry)
+		return
+	}
+
+	r = rpc.NewTMongoCursorBatch()
+	r.Docs = docs
+	if more {
+		r.CursorId = entry.id
+		this.cursors.release(entry)
+	} else {
+		this.cursors.remove(entry)
+	}
+
+	return
+}
+
+func (this *FunServantImpl) MyCursorOpen(ctx *rpc.Context, pool string,
+	table string, hintId int64, sql string, args []string,
+	batchSize int32) (r *rpc.MysqlCursorBatch, ex error) {
+	const IDENT = "my.cursor.open"
+
+	if this.my == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	if !strings.HasPrefix(sql, "SELECT") { // SELECT MUST be in upper case
+		ex = ErrCursorNotSelect
+		return
+	}
+
+	iargs := make([]interface{}, len(args), len(args))
+	for i, arg := range args {
+		iargs[i] = arg
+	}
+
+	var cursorId int64
+	rows, err := this.my.Query(pool, table, int(hintId), sql, iargs)
+	if err != nil {
+		ex = err
+	} else {
+		var c *mysqlCursor
+		if c, ex = newMysqlCursor(rows); ex != nil {
+			rows.Close()
+		} else {
+			var entry *cursorEntry
+			if entry, ex = this.cursors.open(ctx.Rid, c, true); ex != nil {
+				c.Close()
+			} else {
+				cursorId = entry.id
+				r, ex = this.mysqlCursorNext(entry,
+					this.cursorBatchSize(batchSize))
+			}
+		}
+	}
+	if ex != nil {
+		log.Error("Q=%s %s %s[%s]: sql=%s args=(%v): %s", IDENT,
+			ctx.String(), pool, table, sql, args, ex)
+	}
+
+	profiler.do(IDENT, ctx,
+		"{pool^%s table^%s id^%d sql^%s args^%+v batch^%d} {err^%v cursor^%d}",
+		pool, table, hintId, sql, args, batchSize, ex, cursorId)
+
+	return
+}
+
+func (this *FunServantImpl) MyCursorNext(ctx *rpc.Context, cursorId int64,
+	batchSize int32) (r *rpc.MysqlCursorBatch, ex error) {
+	const IDENT = "my.cursor.next"
+
+	svtStats.inc(IDENT)
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	entry, ex := this.cursors.get(ctx.Rid, cursorId)
+	if ex == nil {
+		r, ex = this.mysqlCursorNext(entry, this.cursorBatchSize(batchSize))
+	}
+	if ex != nil {
+		log.Error("Q=%s %s {cursor^%d}: %s", IDENT, ctx.String(),
+			cursorId, ex)
+	}
+
+	profiler.do(IDENT, ctx, "{cursor^%d batch^%d} {err^%v}",
+		cursorId, batchSize, ex)
+
+	return
+}
+
+func (this *FunServantImpl) MyCursorClose(ctx *rpc.Context,
+	cursorId int64) (ex error) {
+	const IDENT = "my.cursor.close"
+
+	svtStats.inc(IDENT)
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	if entry, err := this.cursors.get(ctx.Rid, cursorId); err == nil {
+		this.cursors.remove(entry)
+	}
+
+	profiler.do(IDENT, ctx, "{cursor^%d}", cursorId)
+
+	return
+}
+
+// mysqlCursorNext is the same as mongoCursorNext.
+func (this *FunServantImpl) mysqlCursorNext(entry *cursorEntry,
+	n int) (r *rpc.MysqlCursorBatch, err error) {
+	c, ok := entry.cursor.(*mysqlCursor)
+	if !ok {
+		this.cursors.release(entry)
+		return nil, ErrCursorNotFound
+	}
+
+	rows, more, err := c.next(n, this.conf.MaxResultBytes)
+	if err != nil {
+		this.cursors.remove(entry)
+		return
+	}
+
+	r = rpc.NewMysqlCursorBatch()
+	r.Cols = c.cols
+	r.Rows = rows
+	if more {
+		r.CursorId = entry.id
+		this.cursors.release(entry)
+	} else {
+		this.cursors.remove(entry)
+	}
+
+	return
+}
@@ -0,0 +1,86 @@
+package servant
+
+import (
+	"github.com/funkygao/fae/servant/gen-go/fun/rpc"
+	log "github.com/funkygao/log4go"
+	"time"
+)
+
+func (this *FunServantImpl) ElectionCampaign(ctx *rpc.Context, name string,
+	candidateId string, ttl int32) (r bool, ex error) {
+	const IDENT = "election.campaign"
+	if this.el == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	r, ex = this.el.Campaign(name, candidateId, time.Duration(ttl)*time.Second)
+	if ex != nil {
+		log.Error("Q=%s %s {name^%s candidate^%s}: %s", IDENT, ctx.String(),
+			name, candidateId, ex)
+	}
+
+	profiler.do(IDENT, ctx, "{name^%s candidate^%s ttl^%d} {r^%v err^%v}",
+		name, candidateId, ttl, r, ex)
+	return
+}
+
+func (this *FunServantImpl) ElectionLeader(ctx *rpc.Context,
+	name string) (r string, ex error) {
+	const IDENT = "election.leader"
+	if this.el == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	r, ex = this.el.Leader(name)
+	if ex != nil {
+		log.Error("Q=%s %s {name^%s}: %s", IDENT, ctx.String(), name, ex)
+	}
+
+	profiler.do(IDENT, ctx, "{name^%s} {r^%s err^%v}", name, r, ex)
+	return
+}
+
+func (this *FunServantImpl) ElectionResign(ctx *rpc.Context, name string,
+	candidateId string) (r bool, ex error) {
+	const IDENT = "election.resign"
+	if this.el == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	r, ex = this.el.Resign(name, candidateId)
+	if ex != nil {
+		log.Error("Q=%s %s {name^%s candidate^%s}: %s", IDENT, ctx.String(),
+			name, candidateId, ex)
+	}
+
+	profiler.do(IDENT, ctx, "{name^%s candidate^%s} {r^%v err^%v}",
+		name, candidateId, r, ex)
+	return
+}
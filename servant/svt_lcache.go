@@ -2,11 +2,19 @@ package servant
 
 import (
 	"github.com/funkygao/fae/servant/gen-go/fun/rpc"
+	"github.com/funkygao/fae/servant/memcache"
 	"github.com/funkygao/golib/cache"
+	"github.com/funkygao/golib/sampling"
 	log "github.com/funkygao/log4go"
 	"github.com/funkygao/thrift/lib/go/thrift"
 )
 
+// value stored in lcache
+type lcItem struct {
+	compressed bool
+	data       []byte
+}
+
 func (this *FunServantImpl) onLcLruEvicted(key cache.Key, value interface{}) {
 	// Can't use LruCache public api
 	// Because that will lead to nested LruCache RWMutex lock, dead lock
@@ -26,7 +34,15 @@ func (this *FunServantImpl) LcSet(ctx *rpc.Context,
 		return
 	}
 
-	this.lc.Set(key, value)
+	item, err := this.encodeLcItem(key, value)
+	if err != nil {
+		ex = err
+		profiler.do(IDENT, ctx,
+			"{key^%s size^%d} {err^%v}", key, len(value), ex)
+		return
+	}
+
+	this.lc.Set(key, item)
 	r = true
 	profiler.do(IDENT, ctx,
 		"{key^%s val^%s} {r^%v}", key, value, r)
@@ -51,7 +67,7 @@ func (this *FunServantImpl) LcGet(ctx *rpc.Context, key string) (r []byte,
 		miss = rpc.NewTCacheMissed()
 		miss.Message = thrift.StringPtr("lcache missed: " + key) // optional
 	} else {
-		r = result.([]byte)
+		r, ex = this.decodeLcItem(key, result.(*lcItem))
 	}
 
 	profiler.do(IDENT, ctx,
@@ -72,6 +88,61 @@ func (this *FunServantImpl) LcDel(ctx *rpc.Context, key string) (ex error) {
 	}
 
 	this.lc.Del(key)
+	if this.gs != nil {
+		this.gs.Publish(gossipTopicLcInvalidate, key)
+	}
 	profiler.do(IDENT, ctx, "{key^%s}", key)
 	return
 }
+
+func (this *FunServantImpl) encodeLcItem(key string, value []byte) (*lcItem, error) {
+	var (
+		cf   = this.conf.Lcache
+		item = &lcItem{data: value}
+		size = len(value)
+	)
+
+	if cf.CompressThreshold > 0 && size > cf.CompressThreshold {
+		compressed, err := memcache.Compress(value)
+		if err != nil {
+			return nil, err
+		}
+
+		// incompressible data is stored as is
+		if len(compressed) < size {
+			item.data = compressed
+			item.compressed = true
+			this.onLcBigValue(key, size, len(item.data), "compressed")
+		}
+	}
+
+	if cf.MaxValueSize > 0 && len(item.data) > cf.MaxValueSize {
+		this.onLcBigValue(key, size, len(item.data), "rejected")
+		return nil, &memcache.ValueTooLargeError{Key: key, Size: len(item.data),
+			Limit: cf.MaxValueSize}
+	}
+
+	return item, nil
+}
+
+func (this *FunServantImpl) decodeLcItem(key string, item *lcItem) ([]byte, error) {
+	if !item.compressed {
+		return item.data, nil
+	}
+
+	val, err := memcache.Decompress(item.data)
+	if err != nil {
+		log.Error("lcache key[%s] decompress: %s", key, err)
+	}
+	return val, err
+}
+
+func (this *FunServantImpl) onLcBigValue(key string, rawSize, size int,
+	reason string) {
+	this.lcBigValues[reason].Inc(1)
+
+	if sampling.SampleRateSatisfied(this.conf.Lcache.BigValueLogSampleRate) {
+		log.Warn("lcache big value {key^%s raw^%d size^%d}: %s",
+			key, rawSize, size, reason)
+	}
+}
@@ -23,7 +23,8 @@ func (this *FunServantImpl) Lock(ctx *rpc.Context,
 
 		r = this.lk.Lock(key)
 	} else {
-		svt, err := this.proxy.ServantByKey(key) // FIXME add prefix?
+		// never fall back: 2 peers could grant the same lock
+		svt, err := this.proxy.OwnerServantByKey(key) // FIXME add prefix?
 		if err != nil {
 			ex = err
 			if svt != nil {
@@ -49,6 +50,7 @@ func (this *FunServantImpl) Lock(ctx *rpc.Context,
 				}
 			}
 
+			svt.Done(ex)
 			svt.Recycle()
 		}
 	}
@@ -80,7 +82,7 @@ func (this *FunServantImpl) Unlock(ctx *rpc.Context,
 
 		this.lk.Unlock(key)
 	} else {
-		svt, err := this.proxy.ServantByKey(key)
+		svt, err := this.proxy.OwnerServantByKey(key)
 		if err != nil {
 			ex = err
 			if svt != nil {
@@ -106,6 +108,7 @@ func (this *FunServantImpl) Unlock(ctx *rpc.Context,
 				}
 			}
 
+			svt.Done(ex)
 			svt.Recycle()
 		}
 	}
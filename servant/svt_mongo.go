@@ -1,6 +1,7 @@
 package servant
 
 import (
+	"crypto/sha1"
 	"github.com/funkygao/fae/servant/gen-go/fun/rpc"
 	"github.com/funkygao/fae/servant/mongo"
 	"github.com/funkygao/golib/debug"
@@ -12,7 +13,8 @@ import (
 
 func (this *FunServantImpl) MgInsert(ctx *rpc.Context,
 	pool string, table string, shardId int32,
-	doc []byte) (r bool, ex error) {
+	doc []byte) (r bool, degrade *rpc.TMongoDegrade,
+	readOnly *rpc.TMongoReadOnly, ex error) {
 	const IDENT = "mg.insert"
 
 	if this.mg == nil {
@@ -28,9 +30,9 @@ func (this *FunServantImpl) MgInsert(ctx *rpc.Context,
 		return
 	}
 
-	sess, err := this.mongoSession(pool, shardId)
+	sess, err := this.mongoSession(ctx, pool, shardId, true)
 	if err != nil {
-		ex = err
+		degrade, readOnly, ex = mongoModeException(err)
 		return
 	}
 	defer sess.Recyle(&err)
@@ -74,7 +76,8 @@ func (this *FunServantImpl) MgInsert(ctx *rpc.Context,
 
 func (this *FunServantImpl) MgInserts(ctx *rpc.Context,
 	pool string, table string, shardId int32,
-	docs [][]byte) (r bool, ex error) {
+	docs [][]byte) (r bool, degrade *rpc.TMongoDegrade,
+	readOnly *rpc.TMongoReadOnly, ex error) {
 	const IDENT = "mg.inserts"
 
 	if this.mg == nil {
@@ -91,9 +94,9 @@ func (this *FunServantImpl) MgInserts(ctx *rpc.Context,
 	}
 
 	// get mongodb session
-	sess, err := this.mongoSession(pool, shardId)
+	sess, err := this.mongoSession(ctx, pool, shardId, true)
 	if err != nil {
-		ex = err
+		degrade, readOnly, ex = mongoModeException(err)
 		return
 	}
 	defer sess.Recyle(&err)
@@ -132,7 +135,8 @@ func (this *FunServantImpl) MgInserts(ctx *rpc.Context,
 
 func (this *FunServantImpl) MgDelete(ctx *rpc.Context,
 	pool string, table string, shardId int32,
-	query []byte) (r bool, ex error) {
+	query []byte, cacheKey string) (r bool, degrade *rpc.TMongoDegrade,
+	readOnly *rpc.TMongoReadOnly, ex error) {
 	const IDENT = "mg.del"
 
 	if this.mg == nil {
@@ -148,10 +152,25 @@ func (this *FunServantImpl) MgDelete(ctx *rpc.Context,
 		return
 	}
 
+	if svt, err := this.cachePeerByKey(ctx, cacheKey); err != nil {
+		ex = err
+		return
+	} else if svt != nil {
+		peer := svt.Addr()
+		r, degrade, readOnly, ex = svt.MgDelete(ctx, pool, table, shardId,
+			query, cacheKey)
+		recyclePeer(svt, ex)
+
+		profiler.do(IDENT, ctx,
+			"P=%s {cache^%s pool^%s table^%s} {err^%v}",
+			peer, cacheKey, pool, table, ex)
+		return
+	}
+
 	// get mongodb session
-	sess, err := this.mongoSession(pool, shardId)
+	sess, err := this.mongoSession(ctx, pool, shardId, true)
 	if err != nil {
-		ex = err
+		degrade, readOnly, ex = mongoModeException(err)
 		return
 	}
 	defer sess.Recyle(&err)
@@ -162,6 +181,7 @@ func (this *FunServantImpl) MgDelete(ctx *rpc.Context,
 		return
 	}
 	err = sess.DB().C(table).Remove(bsonQuery)
+	this.mongoCacheDel(IDENT, cacheKey)
 	if err == nil {
 		r = true
 	}
@@ -178,8 +198,8 @@ func (this *FunServantImpl) MgDelete(ctx *rpc.Context,
 
 func (this *FunServantImpl) MgFindOne(ctx *rpc.Context,
 	pool string, table string, shardId int32,
-	query []byte, fields []byte) (r []byte,
-	miss *rpc.TMongoNotFound, ex error) {
+	query []byte, fields []byte, cacheKey string) (r []byte,
+	miss *rpc.TMongoNotFound, degrade *rpc.TMongoDegrade, ex error) {
 	const IDENT = "mg.findOne"
 
 	if this.mg == nil {
@@ -195,10 +215,33 @@ func (this *FunServantImpl) MgFindOne(ctx *rpc.Context,
 		return
 	}
 
+	if svt, err := this.cachePeerByKey(ctx, cacheKey); err != nil {
+		ex = err
+		return
+	} else if svt != nil {
+		peer := svt.Addr()
+		r, miss, degrade, ex = svt.MgFindOne(ctx, pool, table, shardId,
+			query, fields, cacheKey)
+		recyclePeer(svt, ex)
+
+		profiler.do(IDENT, ctx,
+			"P=%s {cache^%s pool^%s table^%s} {miss^%v err^%v}",
+			peer, cacheKey, pool, table, miss, ex)
+		return
+	}
+
+	if cached, present := this.mongoCacheGet(IDENT, cacheKey); present {
+		r = cached
+
+		profiler.do(IDENT, ctx, "{cache^%s pool^%s table^%s} {hit^true}",
+			cacheKey, pool, table)
+		return
+	}
+
 	// get mongodb session
-	sess, err := this.mongoSession(pool, shardId)
+	sess, err := this.mongoSession(ctx, pool, shardId, false)
 	if err != nil {
-		ex = err
+		degrade, _, ex = mongoModeException(err)
 		return
 	}
 	defer sess.Recyle(&err)
@@ -245,6 +288,7 @@ func (this *FunServantImpl) MgFindOne(ctx *rpc.Context,
 	}
 
 	r = mongo.MarshalOut(result)
+	this.mongoCacheSet(IDENT, cacheKey, r)
 
 	profiler.do(IDENT, ctx,
 		"{pool^%s table^%s query^%v fields^%v} {miss^%v err^%v val^%v}",
@@ -261,7 +305,8 @@ func (this *FunServantImpl) MgFindOne(ctx *rpc.Context,
 func (this *FunServantImpl) MgFindAll(ctx *rpc.Context,
 	pool string, table string, shardId int32,
 	query []byte, fields []byte, limit int32, skip int32,
-	orderBy []string) (r [][]byte, ex error) {
+	orderBy []string, cacheKey string) (r [][]byte,
+	degrade *rpc.TMongoDegrade, ex error) {
 	const IDENT = "mg.findAll"
 
 	if this.mg == nil {
@@ -277,10 +322,37 @@ func (this *FunServantImpl) MgFindAll(ctx *rpc.Context,
 		return
 	}
 
-	sess, err := this.mongoSession(pool, shardId)
-	if err != nil {
+	if svt, err := this.cachePeerByKey(ctx, cacheKey); err != nil {
 		ex = err
 		return
+	} else if svt != nil {
+		peer := svt.Addr()
+		r, degrade, ex = svt.MgFindAll(ctx, pool, table, shardId,
+			query, fields, limit, skip, orderBy, cacheKey)
+		recyclePeer(svt, ex)
+
+		profiler.do(IDENT, ctx,
+			"P=%s {cache^%s pool^%s table^%s} {err^%v rN^%d}",
+			peer, cacheKey, pool, table, ex, len(r))
+		return
+	}
+
+	if cached, present := this.mongoCacheGet(IDENT, cacheKey); present {
+		var docs mongoCachedDocs
+		if err = bson.Unmarshal(cached, &docs); err == nil {
+			r = docs.Docs
+
+			profiler.do(IDENT, ctx,
+				"{cache^%s pool^%s table^%s} {hit^true rN^%d}",
+				cacheKey, pool, table, len(r))
+			return
+		}
+	}
+
+	sess, err := this.mongoSession(ctx, pool, shardId, false)
+	if err != nil {
+		degrade, _, ex = mongoModeException(err)
+		return
 	}
 	defer sess.Recyle(&err)
 
@@ -312,15 +384,34 @@ func (this *FunServantImpl) MgFindAll(ctx *rpc.Context,
 		q.Sort(orderBy...)
 	}
 
-	var result []bson.M
-	err = q.All(&result)
-	if err == nil {
-		r = make([][]byte, len(result))
-		for i, v := range result {
-			r[i] = mongo.MarshalOut(v)
+	// iterate instead of q.All to fail before the result blows up heap
+	var (
+		iter     = q.Iter()
+		resLimit = this.newResultLimit()
+	)
+	r = make([][]byte, 0)
+	for {
+		var doc bson.M
+		if !iter.Next(&doc) {
+			break
 		}
-	} else {
+
+		r = append(r, mongo.MarshalOut(doc))
+		if ex = resLimit.add(len(r[len(r)-1])); ex != nil {
+			log.Error("Q=%s %s {pool^%s table^%s query^%v}: %s", IDENT,
+				ctx.String(), pool, table, bsonQuery, ex)
+			r = nil
+			break
+		}
+	}
+	if err = iter.Close(); err != nil {
 		ex = err
+		r = nil
+	}
+	if ex == nil && cacheKey != "" {
+		if cached, err := bson.Marshal(mongoCachedDocs{Docs: r}); err == nil {
+			this.mongoCacheSet(IDENT, cacheKey, cached)
+		}
 	}
 
 	profiler.do(IDENT, ctx,
@@ -336,7 +427,8 @@ func (this *FunServantImpl) MgFindAll(ctx *rpc.Context,
 
 func (this *FunServantImpl) MgUpdate(ctx *rpc.Context,
 	pool string, table string, shardId int32,
-	query []byte, change []byte) (r bool, ex error) {
+	query []byte, change []byte, cacheKey string) (r bool,
+	degrade *rpc.TMongoDegrade, readOnly *rpc.TMongoReadOnly, ex error) {
 	const IDENT = "mg.update"
 
 	if this.mg == nil {
@@ -352,10 +444,25 @@ func (this *FunServantImpl) MgUpdate(ctx *rpc.Context,
 		return
 	}
 
+	if svt, err := this.cachePeerByKey(ctx, cacheKey); err != nil {
+		ex = err
+		return
+	} else if svt != nil {
+		peer := svt.Addr()
+		r, degrade, readOnly, ex = svt.MgUpdate(ctx, pool, table, shardId,
+			query, change, cacheKey)
+		recyclePeer(svt, ex)
+
+		profiler.do(IDENT, ctx,
+			"P=%s {cache^%s pool^%s table^%s} {err^%v}",
+			peer, cacheKey, pool, table, ex)
+		return
+	}
+
 	// get mongodb session
-	sess, err := this.mongoSession(pool, shardId)
+	sess, err := this.mongoSession(ctx, pool, shardId, true)
 	if err != nil {
-		ex = err
+		degrade, readOnly, ex = mongoModeException(err)
 		return
 	}
 	defer sess.Recyle(&err)
@@ -372,6 +479,7 @@ func (this *FunServantImpl) MgUpdate(ctx *rpc.Context,
 	}
 
 	err = sess.DB().C(table).Update(bsonQuery, bsonChange)
+	this.mongoCacheDel(IDENT, cacheKey)
 	if err == nil {
 		r = true
 	} else {
@@ -391,14 +499,16 @@ func (this *FunServantImpl) MgUpdate(ctx *rpc.Context,
 
 func (this *FunServantImpl) MgUpdateId(ctx *rpc.Context,
 	pool string, table string, shardId int32,
-	id int32, change []byte) (r bool, ex error) {
+	id int32, change []byte) (r bool, degrade *rpc.TMongoDegrade,
+	readOnly *rpc.TMongoReadOnly, ex error) {
 	ex = ErrNotImplemented
 	return
 }
 
 func (this *FunServantImpl) MgUpsert(ctx *rpc.Context,
 	pool string, table string, shardId int32,
-	query []byte, change []byte) (r bool, ex error) {
+	query []byte, change []byte, cacheKey string) (r bool,
+	degrade *rpc.TMongoDegrade, readOnly *rpc.TMongoReadOnly, ex error) {
 	const IDENT = "mg.upsert"
 
 	if this.mg == nil {
@@ -414,10 +524,25 @@ func (this *FunServantImpl) MgUpsert(ctx *rpc.Context,
 		return
 	}
 
-	sess, err := this.mongoSession(pool, shardId)
-	if err != nil {
+	if svt, err := this.cachePeerByKey(ctx, cacheKey); err != nil {
 		ex = err
 		return
+	} else if svt != nil {
+		peer := svt.Addr()
+		r, degrade, readOnly, ex = svt.MgUpsert(ctx, pool, table, shardId,
+			query, change, cacheKey)
+		recyclePeer(svt, ex)
+
+		profiler.do(IDENT, ctx,
+			"P=%s {cache^%s pool^%s table^%s} {err^%v}",
+			peer, cacheKey, pool, table, ex)
+		return
+	}
+
+	sess, err := this.mongoSession(ctx, pool, shardId, true)
+	if err != nil {
+		degrade, readOnly, ex = mongoModeException(err)
+		return
 	}
 	defer sess.Recyle(&err)
 
@@ -433,6 +558,7 @@ func (this *FunServantImpl) MgUpsert(ctx *rpc.Context,
 	}
 
 	_, err = sess.DB().C(table).Upsert(bsonQuery, bsonChange)
+	this.mongoCacheDel(IDENT, cacheKey)
 	if err == nil {
 		r = true
 	} else {
@@ -451,14 +577,15 @@ func (this *FunServantImpl) MgUpsert(ctx *rpc.Context,
 
 func (this *FunServantImpl) MgUpsertId(ctx *rpc.Context,
 	pool string, table string, shardId int32,
-	id int32, change []byte) (r bool, ex error) {
+	id int32, change []byte) (r bool, degrade *rpc.TMongoDegrade,
+	readOnly *rpc.TMongoReadOnly, ex error) {
 	ex = ErrNotImplemented
 	return
 }
 
 func (this *FunServantImpl) MgCount(ctx *rpc.Context,
 	pool string, table string, shardId int32,
-	query []byte) (n int32, ex error) {
+	query []byte) (n int32, degrade *rpc.TMongoDegrade, ex error) {
 	const IDENT = "mg.count"
 
 	if this.mg == nil {
@@ -475,9 +602,9 @@ func (this *FunServantImpl) MgCount(ctx *rpc.Context,
 	}
 
 	// get mongodb session
-	sess, err := this.mongoSession(pool, shardId)
+	sess, err := this.mongoSession(ctx, pool, shardId, false)
 	if err != nil {
-		ex = err
+		degrade, _, ex = mongoModeException(err)
 		return
 	}
 	defer sess.Recyle(&err)
@@ -507,7 +634,8 @@ func (this *FunServantImpl) MgCount(ctx *rpc.Context,
 func (this *FunServantImpl) MgFindAndModify(ctx *rpc.Context,
 	pool string, table string, shardId int32,
 	query []byte, change []byte, upsert bool,
-	remove bool, returnNew bool) (r []byte, ex error) {
+	remove bool, returnNew bool, cacheKey string) (r []byte,
+	degrade *rpc.TMongoDegrade, readOnly *rpc.TMongoReadOnly, ex error) {
 	const IDENT = "mg.findAndModify"
 
 	if this.mg == nil {
@@ -523,10 +651,25 @@ func (this *FunServantImpl) MgFindAndModify(ctx *rpc.Context,
 		return
 	}
 
+	if svt, err := this.cachePeerByKey(ctx, cacheKey); err != nil {
+		ex = err
+		return
+	} else if svt != nil {
+		peer := svt.Addr()
+		r, degrade, readOnly, ex = svt.MgFindAndModify(ctx, pool, table, shardId,
+			query, change, upsert, remove, returnNew, cacheKey)
+		recyclePeer(svt, ex)
+
+		profiler.do(IDENT, ctx,
+			"P=%s {cache^%s pool^%s table^%s} {err^%v}",
+			peer, cacheKey, pool, table, ex)
+		return
+	}
+
 	// get mongodb session
-	sess, err := this.mongoSession(pool, shardId)
+	sess, err := this.mongoSession(ctx, pool, shardId, true)
 	if err != nil {
-		ex = err
+		degrade, readOnly, ex = mongoModeException(err)
 		return
 	}
 	defer sess.Recyle(&err)
@@ -547,6 +690,7 @@ func (this *FunServantImpl) MgFindAndModify(ctx *rpc.Context,
 		Apply(mgo.Change{Update: bsonChange,
 		Upsert: upsert, Remove: remove, ReturnNew: returnNew}, &doc)
 	r = mongo.MarshalOut(doc)
+	this.mongoCacheDel(IDENT, cacheKey)
 
 	profiler.do(IDENT, ctx,
 		"{pool^%s table^%s query^%v chg^%v} {err^%v updated^%d removed^%d r^%v}",
@@ -559,10 +703,279 @@ func (this *FunServantImpl) MgFindAndModify(ctx *rpc.Context,
 	return
 }
 
+func (this *FunServantImpl) MgAggregate(ctx *rpc.Context,
+	pool string, table string, shardId int32,
+	pipeline [][]byte, allowDisk bool) (r [][]byte,
+	degrade *rpc.TMongoDegrade, ex error) {
+	const IDENT = "mg.aggregate"
+
+	if this.mg == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	sess, err := this.mongoSession(ctx, pool, shardId, false)
+	if err != nil {
+		degrade, _, ex = mongoModeException(err)
+		return
+	}
+	defer sess.Recyle(&err)
+
+	bsonPipeline := make([]bson.D, len(pipeline))
+	for i, stage := range pipeline {
+		bsonPipeline[i], err = mongo.UnmarshalInD(stage)
+		if err != nil {
+			ex = err
+			return
+		}
+	}
+
+	var result []bson.M
+	result, err = sess.Aggregate(table, bsonPipeline, allowDisk)
+	if err == nil {
+		r = make([][]byte, len(result))
+		for i, v := range result {
+			r[i] = mongo.MarshalOut(v)
+		}
+	} else {
+		log.Error("Q=%s %s {pool^%s table^%s pipeline^%v}: %s", IDENT,
+			ctx.String(), pool, table, bsonPipeline, err)
+		ex = err
+	}
+
+	profiler.do(IDENT, ctx,
+		"{pool^%s table^%s pipeline^%v disk^%v} {err^%v rN^%d}",
+		pool, table,
+		bsonPipeline,
+		allowDisk,
+		ex,
+		len(r))
+
+	return
+}
+
+func (this *FunServantImpl) MgDistinct(ctx *rpc.Context,
+	pool string, table string, shardId int32,
+	key string, query []byte) (r []byte, degrade *rpc.TMongoDegrade, ex error) {
+	const IDENT = "mg.distinct"
+
+	if this.mg == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	sess, err := this.mongoSession(ctx, pool, shardId, false)
+	if err != nil {
+		degrade, _, ex = mongoModeException(err)
+		return
+	}
+	defer sess.Recyle(&err)
+
+	bsonQuery, err := mongo.UnmarshalIn(query)
+	if err != nil {
+		ex = err
+		return
+	}
+
+	var values []interface{}
+	values, err = sess.Distinct(table, key, bsonQuery)
+	if err == nil {
+		r = mongo.MarshalOut(bson.M{"values": values})
+	} else {
+		log.Error("Q=%s %s {pool^%s table^%s key^%s query^%v}: %s", IDENT,
+			ctx.String(), pool, table, key, bsonQuery, err)
+		ex = err
+	}
+
+	profiler.do(IDENT, ctx,
+		"{pool^%s table^%s key^%s query^%v} {err^%v rN^%d}",
+		pool, table,
+		key,
+		bsonQuery,
+		ex,
+		len(values))
+
+	return
+}
+
+func (this *FunServantImpl) MgBulkWrite(ctx *rpc.Context,
+	pool string, table string, shardId int32,
+	ops []*rpc.TMongoWriteOp, ordered bool) (r []*rpc.TMongoWriteResult, degrade *rpc.TMongoDegrade,
+	readOnly *rpc.TMongoReadOnly, ex error) {
+	const IDENT = "mg.bulkWrite"
+
+	if this.mg == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	sess, err := this.mongoSession(ctx, pool, shardId, true)
+	if err != nil {
+		degrade, readOnly, ex = mongoModeException(err)
+		return
+	}
+	defer sess.Recyle(&err)
+
+	writeOps := make([]mongo.WriteOp, len(ops))
+	for i, op := range ops {
+		writeOps[i].Kind = op.Op
+		writeOps[i].Multi = op.IsSetMulti() && *op.Multi
+		if op.IsSetDoc() {
+			if writeOps[i].Doc, ex = mongo.UnmarshalIn(op.Doc); ex != nil {
+				return
+			}
+		}
+		if op.IsSetQuery() {
+			if writeOps[i].Query, ex = mongo.UnmarshalIn(op.Query); ex != nil {
+				return
+			}
+		}
+		if op.IsSetChange() {
+			if writeOps[i].Change, ex = mongo.UnmarshalIn(op.Change); ex != nil {
+				return
+			}
+		}
+	}
+
+	var results []mongo.WriteResult
+	results, err = sess.BulkWrite(table, writeOps, ordered)
+	if err != nil {
+		// per-op results are still returned
+		log.Error("Q=%s %s {pool^%s table^%s opN^%d}: %s", IDENT,
+			ctx.String(), pool, table, len(ops), err)
+	}
+
+	failedN := 0
+	r = make([]*rpc.TMongoWriteResult, len(results))
+	for i, result := range results {
+		r[i] = rpc.NewTMongoWriteResult()
+		r[i].Matched = int64(result.Matched)
+		r[i].Modified = int64(result.Modified)
+		if result.UpsertedId != nil {
+			r[i].UpsertedId = mongo.MarshalOut(bson.M{"_id": result.UpsertedId})
+		}
+		r[i].ErrCode = int32(result.ErrCode)
+		if result.ErrCode != 0 {
+			r[i].ErrMsg = thrift.StringPtr(result.ErrMsg)
+			failedN++
+		}
+	}
+
+	profiler.do(IDENT, ctx,
+		"{pool^%s table^%s opN^%d ordered^%v} {err^%v failedN^%d}",
+		pool, table,
+		len(ops),
+		ordered,
+		err,
+		failedN)
+
+	return
+}
+
 func (this *FunServantImpl) MgFindId(ctx *rpc.Context,
 	pool string, table string, shardId int32,
-	id []byte) (r []byte, ex error) {
-	ex = ErrNotImplemented
+	id []byte, cacheKey string) (r []byte, degrade *rpc.TMongoDegrade,
+	miss *rpc.TMongoNotFound, ex error) {
+	const IDENT = "mg.findId"
+
+	if this.mg == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	if svt, err := this.cachePeerByKey(ctx, cacheKey); err != nil {
+		ex = err
+		return
+	} else if svt != nil {
+		peer := svt.Addr()
+		r, degrade, miss, ex = svt.MgFindId(ctx, pool, table, shardId,
+			id, cacheKey)
+		recyclePeer(svt, ex)
+
+		profiler.do(IDENT, ctx,
+			"P=%s {cache^%s pool^%s table^%s} {miss^%v err^%v}",
+			peer, cacheKey, pool, table, miss, ex)
+		return
+	}
+
+	if cached, present := this.mongoCacheGet(IDENT, cacheKey); present {
+		r = cached
+
+		profiler.do(IDENT, ctx, "{cache^%s pool^%s table^%s} {hit^true}",
+			cacheKey, pool, table)
+		return
+	}
+
+	bsonId, err := mongo.UnmarshalIn(id)
+	if err != nil {
+		ex = err
+		return
+	}
+
+	sess, err := this.mongoSession(ctx, pool, shardId, false)
+	if err != nil {
+		degrade, _, ex = mongoModeException(err)
+		return
+	}
+	defer sess.Recyle(&err)
+
+	var result bson.M
+	err = sess.DB().C(table).FindId(bsonId["_id"]).One(&result)
+	switch err {
+	case nil:
+		r = mongo.MarshalOut(result)
+		this.mongoCacheSet(IDENT, cacheKey, r)
+
+	case mgo.ErrNotFound:
+		miss = rpc.NewTMongoNotFound()
+		miss.Message = thrift.StringPtr(err.Error())
+
+	default:
+		ex = err
+		log.Error("Q=%s %s {pool^%s table^%s id^%v}: %s", IDENT,
+			ctx.String(), pool, table, bsonId, ex)
+	}
+
+	profiler.do(IDENT, ctx,
+		"{pool^%s table^%s id^%v} {miss^%v err^%v val^%v}",
+		pool, table,
+		bsonId,
+		miss,
+		ex,
+		result)
+
 	return
 }
 
@@ -574,9 +987,21 @@ func (this *mongoProtocolLogger) Output(calldepth int, s string) error {
 	return nil
 }
 
-func (this *FunServantImpl) mongoSession(pool string,
-	shardId int32) (*mongo.Session, error) {
-	sess, err := this.mg.Session(pool, shardId)
+// mongoSession fails fast if the pool is degraded or read only.
+// Reads follow the read preference of ctx if any.
+func (this *FunServantImpl) mongoSession(ctx *rpc.Context, pool string,
+	shardId int32, write bool) (*mongo.Session, error) {
+	if err := this.mg.Guard(pool, shardId, write); err != nil {
+		log.Warn("{pool^%s id^%d write^%v}: %s", pool, shardId, write, err)
+		return nil, err
+	}
+
+	var readPref string
+	if !write && ctx.IsSetReadPref() {
+		readPref = *ctx.ReadPref
+	}
+
+	sess, err := this.mg.Session(pool, shardId, readPref)
 	if err != nil {
 		log.Error("{pool^%s id^%d}: %s", pool, shardId, err)
 		return nil, err
@@ -584,3 +1009,78 @@ func (this *FunServantImpl) mongoSession(pool string,
 
 	return sess, err
 }
+
+// mongoModeException converts mode errors into thrift exceptions.
+func mongoModeException(err error) (degrade *rpc.TMongoDegrade,
+	readOnly *rpc.TMongoReadOnly, ex error) {
+	switch err {
+	case mongo.ErrDegraded:
+		degrade = rpc.NewTMongoDegrade()
+		degrade.Message = thrift.StringPtr(err.Error())
+
+	case mongo.ErrReadOnly, mongo.ErrShardMoving:
+		readOnly = rpc.NewTMongoReadOnly()
+		readOnly.Message = thrift.StringPtr(err.Error())
+
+	default:
+		ex = err
+	}
+
+	return
+}
+
+// mongo reads share the db cache store with mysql, namespaced to avoid
+// collision of cache keys.
+type mongoCachedDocs struct {
+	Docs [][]byte `bson:"docs"`
+}
+
+func (this *FunServantImpl) mongoCacheKey(cacheKey string) string {
+	if this.conf.Mysql.CacheKeyHash {
+		hashSum := sha1.Sum([]byte(cacheKey))
+		cacheKey = string(hashSum[:])
+	}
+
+	return "mg." + cacheKey
+}
+
+func (this *FunServantImpl) mongoCacheGet(ident string,
+	cacheKey string) ([]byte, bool) {
+	if cacheKey == "" || this.dbCacheStore == nil {
+		return nil, false
+	}
+
+	val, present := this.dbCacheStore.Get(this.mongoCacheKey(cacheKey))
+	if b, ok := val.([]byte); present && ok && b != nil {
+		log.Debug("Q=%s cache[%s] hit", ident, cacheKey)
+		this.dbCacheHits.Inc("hit", 1)
+		return b, true
+	}
+
+	return nil, false
+}
+
+func (this *FunServantImpl) mongoCacheSet(ident string, cacheKey string,
+	val []byte) {
+	if cacheKey == "" || this.dbCacheStore == nil {
+		return
+	}
+
+	this.dbCacheStore.Set(this.mongoCacheKey(cacheKey), val)
+
+	this.dbCacheHits.Inc("miss", 1)
+	log.Debug("Q=%s cache[%s] miss", ident, cacheKey)
+}
+
+// mongoCacheDel is called after a write whether it succeeds or not: the
+// write might have been applied even on a timeout.
+func (this *FunServantImpl) mongoCacheDel(ident string, cacheKey string) {
+	if cacheKey == "" || this.dbCacheStore == nil {
+		return
+	}
+
+	this.dbCacheStore.Del(this.mongoCacheKey(cacheKey))
+
+	this.dbCacheHits.Inc("kicked", 1)
+	log.Debug("Q=%s cache[%s] kicked", ident, cacheKey)
+}
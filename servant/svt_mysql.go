@@ -73,9 +73,13 @@ func (this *FunServantImpl) MyQueryShards(ctx *rpc.Context, pool string, table s
 	for i, arg := range args {
 		iargs[i] = arg
 	}
-	cols, rows, err := this.my.QueryShards(pool, table, sql, iargs)
+	resLimit := this.newResultLimit()
+	cols, rows, err := this.my.QueryShards(pool, table, sql, iargs,
+		resLimit.addRow)
 	if err != nil {
 		ex = err
+		log.Error("Q=%s %s %s[%s]: sql=%s args=(%v): %s", IDENT,
+			ctx.String(), pool, table, sql, args, ex)
 		return
 	}
 
@@ -169,6 +173,7 @@ func (this *FunServantImpl) MyQuery(ctx *rpc.Context, pool string, table string,
 					}
 				}
 
+				svt.Done(ex)
 				svt.Recycle() // NEVER forget about this
 			}
 		}
@@ -230,6 +235,7 @@ func (this *FunServantImpl) MyEvict(ctx *rpc.Context,
 				}
 			}
 
+			svt.Done(ex)
 			svt.Recycle()
 		}
 	}
@@ -383,6 +389,7 @@ func (this *FunServantImpl) doMySelect(r *rpc.MysqlResult,
 
 	r.Cols = cols
 	r.Rows = make([][]string, 0)
+	resLimit := this.newResultLimit()
 
 	if config.Engine.Servants.Mysql.AllowNullableColumns {
 		rawRowValues := make([]sql_.RawBytes, len(cols))
@@ -411,6 +418,9 @@ func (this *FunServantImpl) doMySelect(r *rpc.MysqlResult,
 			}
 
 			r.Rows = append(r.Rows, rowValues)
+			if ex = resLimit.addRow(rowValues); ex != nil {
+				break
+			}
 		}
 	} else {
 		rawRowValues := make([]string, len(cols))
@@ -430,11 +440,17 @@ func (this *FunServantImpl) doMySelect(r *rpc.MysqlResult,
 			}
 
 			r.Rows = append(r.Rows, rawRowValues)
+			if ex = resLimit.addRow(rawRowValues); ex != nil {
+				break
+			}
 		}
 	}
 
 	// check for errors after we’re done iterating over the rows
-	if ex = rows.Err(); ex != nil {
+	if ex == nil {
+		ex = rows.Err()
+	}
+	if ex != nil {
 		log.Error("Q=%s %s[%s]: sql=%s args=(%v): %s",
 			ident,
 			pool, table,
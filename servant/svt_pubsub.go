@@ -0,0 +1,80 @@
+package servant
+
+import (
+	"github.com/funkygao/fae/servant/gen-go/fun/rpc"
+	log "github.com/funkygao/log4go"
+	"github.com/funkygao/redigo/redis"
+	"time"
+)
+
+func (this *FunServantImpl) RdPublish(ctx *rpc.Context, channel string,
+	payload []byte) (r int64, ex error) {
+	const IDENT = "rd.publish"
+
+	if this.ps == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	r, ex = redis.Int64(this.rd.Call("PUBLISH", this.conf.Pubsub.Pool,
+		channel, payload))
+	if ex != nil {
+		log.Error("Q=%s %s {channel^%s}: %s", IDENT, ctx.String(),
+			channel, ex)
+	}
+
+	profiler.do(IDENT, ctx,
+		"{channel^%s payload^%d} {err^%v r^%d}",
+		channel, len(payload), ex, r)
+
+	return
+}
+
+func (this *FunServantImpl) RdPoll(ctx *rpc.Context, subscriberId string,
+	channel string, sinceOffset int64, max int32,
+	wait int32) (r *rpc.TPubsubPollResult, ex error) {
+	const IDENT = "rd.poll"
+
+	if this.ps == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	msgs, next, lost, ex := this.ps.Poll(subscriberId, channel, sinceOffset,
+		int(max), time.Duration(wait)*time.Millisecond)
+	if ex == nil {
+		r = rpc.NewTPubsubPollResult()
+		r.NextOffset = next
+		r.Lost = lost
+		r.Messages = make([]*rpc.TPubsubMessage, len(msgs))
+		for i, msg := range msgs {
+			r.Messages[i] = &rpc.TPubsubMessage{Offset: msg.Offset,
+				Channel: msg.Channel, Payload: msg.Payload}
+		}
+	} else {
+		log.Error("Q=%s %s {subscriber^%s channel^%s since^%d}: %s", IDENT,
+			ctx.String(), subscriberId, channel, sinceOffset, ex)
+	}
+
+	profiler.do(IDENT, ctx,
+		"{subscriber^%s channel^%s since^%d max^%d wait^%d} {err^%v next^%d msgN^%d lost^%v}",
+		subscriberId, channel, sinceOffset, max, wait, ex, next, len(msgs), lost)
+
+	return
+}
@@ -2,9 +2,12 @@ package servant
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/funkygao/fae/servant/gen-go/fun/rpc"
+	redis_ "github.com/funkygao/fae/servant/redis"
 	log "github.com/funkygao/log4go"
 	"github.com/funkygao/redigo/redis"
+	"github.com/funkygao/thrift/lib/go/thrift"
 	"strconv"
 )
 
@@ -20,7 +23,7 @@ func (this *FunServantImpl) RdCall(ctx *rpc.Context, cmd string,
 		return
 	}
 
-	r, ex = this.callRedis(cmd, pool, keysAndArgs)
+	r, ex = this.callRedis(ctx, cmd, pool, keysAndArgs)
 
 	profiler.do(IDENT, ctx,
 		"{cmd^%s pool^%s key^%s args^%+v} {r^%s}",
@@ -29,15 +32,172 @@ func (this *FunServantImpl) RdCall(ctx *rpc.Context, cmd string,
 	return
 }
 
-func (this *FunServantImpl) callRedis(cmd string, pool string,
-	keysAndArgs []string) (r string, ex error) {
+func (this *FunServantImpl) RdCallTyped(ctx *rpc.Context, cmd string,
+	pool string, keysAndArgs []string) (r *rpc.TRedisReply, ex error) {
+	const IDENT = "rd.call.typed"
+
+	if this.rd == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
 	var val interface{}
-	// cannot use args (type []string) as type []interface {}
-	iargs := make([]interface{}, len(keysAndArgs))
-	for i, v := range keysAndArgs {
-		iargs[i] = v
+	val, ex = this.redisCall(ctx, cmd, pool, keysAndArgs)
+	switch {
+	case ex == nil:
+		r = redisReply(val)
+
+	case ex == redis_.ErrKeyNotExist:
+		// GET of non-existent key is a nil bulk, not an error
+		r = redisReply(nil)
+		ex = nil
+
+	case redis_.IsReplyError(ex):
+		r = redisReply(ex)
+		ex = nil
+
+	default:
+		log.Error("Q=%s %s {cmd^%s pool^%s args^%+v}: %s", IDENT, ctx.String(),
+			cmd, pool, keysAndArgs, ex)
+	}
+
+	profiler.do(IDENT, ctx,
+		"{cmd^%s pool^%s args^%+v} {err^%v r^%s}",
+		cmd, pool, keysAndArgs, ex, r)
+
+	return
+}
+
+func (this *FunServantImpl) RdPipeline(ctx *rpc.Context, pool string,
+	cmds []*rpc.TRedisCommand) (r []*rpc.TRedisReply, ex error) {
+	const IDENT = "rd.pipeline"
+
+	if this.rd == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	redisCmds := make([]redis_.Command, len(cmds))
+	for i, cmd := range cmds {
+		redisCmds[i] = redis_.Command{Name: cmd.Cmd,
+			KeysAndArgs: redisArgs(cmd.KeysAndArgs)}
+	}
+
+	var replies []redis_.Reply
+	replies, ex = this.rd.Pipeline(pool, redisCmds)
+	if ex == nil {
+		r = make([]*rpc.TRedisReply, len(replies))
+		for i, reply := range replies {
+			r[i] = redisReplyWithErr(reply.Val, reply.Err)
+		}
+	} else {
+		log.Error("Q=%s %s {pool^%s cmdN^%d}: %s", IDENT, ctx.String(),
+			pool, len(cmds), ex)
+	}
+
+	profiler.do(IDENT, ctx,
+		"{pool^%s cmds^%+v} {err^%v rN^%d}",
+		pool, cmds, ex, len(r))
+
+	return
+}
+
+func (this *FunServantImpl) RdEval(ctx *rpc.Context, pool string,
+	script string, keys []string, args []string) (r *rpc.TRedisReply, ex error) {
+	const IDENT = "rd.eval"
+
+	if this.rd == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	var val interface{}
+	val, ex = this.rd.Eval(pool, script, keys, redisArgs(args)...)
+	if ex == nil || redis_.IsReplyError(ex) {
+		r = redisReplyWithErr(val, ex)
+		ex = nil
+	} else {
+		log.Error("Q=%s %s {pool^%s keys^%+v}: %s", IDENT, ctx.String(),
+			pool, keys, ex)
+	}
+
+	profiler.do(IDENT, ctx,
+		"{pool^%s script^%s keys^%+v args^%+v} {err^%v r^%s}",
+		pool, script, keys, args, ex, r)
+
+	return
+}
+
+func (this *FunServantImpl) RdMulti(ctx *rpc.Context, pool string,
+	cmds []*rpc.TRedisCommand) (r []*rpc.TRedisReply, ex error) {
+	const IDENT = "rd.multi"
+
+	if this.rd == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
 	}
-	if val, ex = this.rd.Call(cmd, pool, iargs...); ex == nil && val != nil {
+
+	redisCmds := make([]redis_.Command, len(cmds))
+	for i, cmd := range cmds {
+		redisCmds[i] = redis_.Command{Name: cmd.Cmd,
+			KeysAndArgs: redisArgs(cmd.KeysAndArgs)}
+	}
+
+	var replies []interface{}
+	replies, ex = this.rd.Multi(pool, redisCmds)
+	if ex == nil {
+		r = make([]*rpc.TRedisReply, len(replies))
+		for i, reply := range replies {
+			r[i] = redisReply(reply)
+		}
+	} else {
+		log.Error("Q=%s %s {pool^%s cmdN^%d}: %s", IDENT, ctx.String(),
+			pool, len(cmds), ex)
+	}
+
+	profiler.do(IDENT, ctx,
+		"{pool^%s cmds^%+v} {err^%v rN^%d}",
+		pool, cmds, ex, len(r))
+
+	return
+}
+
+func (this *FunServantImpl) callRedis(ctx *rpc.Context, cmd string, pool string,
+	keysAndArgs []string) (r string, ex error) {
+	var val interface{}
+	if val, ex = this.redisCall(ctx, cmd, pool, keysAndArgs); ex == nil && val != nil {
 		switch val := val.(type) {
 		case []byte:
 			r = string(val)
@@ -66,3 +226,76 @@ func (this *FunServantImpl) callRedis(cmd string, pool string,
 
 	return
 }
+
+// redisCall reads from replicas if the caller can live with stale data.
+func (this *FunServantImpl) redisCall(ctx *rpc.Context, cmd string, pool string,
+	keysAndArgs []string) (interface{}, error) {
+	if ctx.IsSetStaleOk() && *ctx.StaleOk {
+		return this.rd.CallReplica(cmd, pool, redisArgs(keysAndArgs)...)
+	}
+
+	return this.rd.Call(cmd, pool, redisArgs(keysAndArgs)...)
+}
+
+// cannot use args (type []string) as type []interface {}
+func redisArgs(keysAndArgs []string) []interface{} {
+	iargs := make([]interface{}, len(keysAndArgs))
+	for i, v := range keysAndArgs {
+		iargs[i] = v
+	}
+	return iargs
+}
+
+// redisReply converts a redigo reply into thrift union without losing
+// the RESP structure:
+// error                   redis.Error
+// integer                 int64
+// simple string           string
+// bulk string             []byte or nil if value not present.
+// array                   []interface{} or nil if value not present
+func redisReply(val interface{}) *rpc.TRedisReply {
+	r := rpc.NewTRedisReply()
+	switch val := val.(type) {
+	case nil:
+		r.IsNil = thrift.BoolPtr(true)
+
+	case redis.Error:
+		r.ErrorMsg = thrift.StringPtr(val.Error())
+
+	case string:
+		r.Status = thrift.StringPtr(val)
+
+	case int64:
+		r.Integer = thrift.Int64Ptr(val)
+
+	case []byte:
+		r.Bulk = val
+
+	case []interface{}:
+		r.Elements = make([]*rpc.TRedisReply, len(val))
+		for i, v := range val {
+			r.Elements[i] = redisReply(v)
+		}
+
+	default:
+		log.Error("redis unknown reply type: %T", val)
+		r.ErrorMsg = thrift.StringPtr(fmt.Sprintf("unknown reply type: %T", val))
+	}
+
+	return r
+}
+
+// per command error within a batch is carried by the reply itself
+func redisReplyWithErr(val interface{}, err error) *rpc.TRedisReply {
+	if err == nil {
+		return redisReply(val)
+	}
+
+	if redis_.IsReplyError(err) {
+		return redisReply(err)
+	}
+
+	r := rpc.NewTRedisReply()
+	r.ErrorMsg = thrift.StringPtr(err.Error())
+	return r
+}
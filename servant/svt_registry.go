@@ -0,0 +1,103 @@
+package servant
+
+import (
+	"github.com/funkygao/fae/servant/gen-go/fun/rpc"
+	log "github.com/funkygao/log4go"
+	"time"
+)
+
+func (this *FunServantImpl) RegistryRegister(ctx *rpc.Context, service string,
+	addr string, meta map[string]string, ttl int32) (r bool, ex error) {
+	const IDENT = "registry.register"
+	if this.reg == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	ex = this.reg.Register(service, addr, meta, time.Duration(ttl)*time.Second)
+	if ex == nil {
+		r = true
+	} else {
+		log.Error("Q=%s %s {service^%s addr^%s}: %s", IDENT, ctx.String(),
+			service, addr, ex)
+	}
+
+	profiler.do(IDENT, ctx, "{service^%s addr^%s meta^%+v ttl^%d} {r^%v err^%v}",
+		service, addr, meta, ttl, r, ex)
+	return
+}
+
+func (this *FunServantImpl) RegistryDeregister(ctx *rpc.Context,
+	service string, addr string) (r bool, ex error) {
+	const IDENT = "registry.deregister"
+	if this.reg == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	r, ex = this.reg.Deregister(service, addr)
+	if ex != nil {
+		log.Error("Q=%s %s {service^%s addr^%s}: %s", IDENT, ctx.String(),
+			service, addr, ex)
+	}
+
+	profiler.do(IDENT, ctx, "{service^%s addr^%s} {r^%v err^%v}",
+		service, addr, r, ex)
+	return
+}
+
+func (this *FunServantImpl) RegistryEndpoints(ctx *rpc.Context,
+	service string, lastVersion int64,
+	wait int32) (r *rpc.TServiceEndpoints, ex error) {
+	const IDENT = "registry.endpoints"
+	if this.reg == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	timeout := time.Duration(wait) * time.Millisecond
+	if timeout > this.conf.ZkMaxWatchWait {
+		timeout = this.conf.ZkMaxWatchWait
+	}
+
+	endpoints, version, ex := this.reg.Endpoints(service, lastVersion, timeout)
+	if ex == nil {
+		r = rpc.NewTServiceEndpoints()
+		r.Version = version
+		r.Endpoints = make([]*rpc.TServiceEndpoint, len(endpoints))
+		for i, ep := range endpoints {
+			r.Endpoints[i] = &rpc.TServiceEndpoint{Addr: ep.Addr, Meta: ep.Meta}
+		}
+	} else {
+		log.Error("Q=%s %s {service^%s}: %s", IDENT, ctx.String(),
+			service, ex)
+	}
+
+	profiler.do(IDENT, ctx, "{service^%s v^%d wait^%d} {v^%d n^%d err^%v}",
+		service, lastVersion, wait, version, len(endpoints), ex)
+	return
+}
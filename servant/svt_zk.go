@@ -1,13 +1,20 @@
 package servant
 
 import (
-	"github.com/funkygao/etclib"
 	"github.com/funkygao/fae/servant/gen-go/fun/rpc"
+	"github.com/funkygao/fae/servant/zk"
+	log "github.com/funkygao/log4go"
+	"github.com/funkygao/thrift/lib/go/thrift"
+	"time"
 )
 
 func (this *FunServantImpl) ZkCreate(ctx *rpc.Context, path string,
 	data string) (r bool, ex error) {
 	const IDENT = "zk.create"
+	if this.zk == nil {
+		ex = ErrServantNotStarted
+		return
+	}
 
 	profiler, err := this.getSession(ctx).startProfiler()
 	if err != nil {
@@ -17,19 +24,181 @@ func (this *FunServantImpl) ZkCreate(ctx *rpc.Context, path string,
 
 	svtStats.inc(IDENT)
 
-	// TODO always persistent?
-	if ex = etclib.Create(path, data, 0); ex == nil {
+	if _, ex = this.zk.Create(path, []byte(data), 0); ex == nil {
 		r = true
-	} 
+	}
 
 	profiler.do(IDENT, ctx, "{path^%s data^%s} {r^%v err^%v}",
-		path, string(data), r, ex)
+		path, data, r, ex)
+	return
+}
+
+func (this *FunServantImpl) ZkCreateNode(ctx *rpc.Context, path string,
+	data string, flags int32) (r string, exists *rpc.TZkNodeExists,
+	noParent *rpc.TZkNoNode, ex error) {
+	const IDENT = "zk.createNode"
+	if this.zk == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	sess := this.getSession(ctx)
+	profiler, err := sess.startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	if flags&zk.FlagEphemeral == 0 {
+		r, err = this.zk.Create(path, []byte(data), flags)
+	} else if !this.ephemerals.reserve() {
+		err = ErrTooManyEphemerals
+	} else {
+		if r, err = this.zk.Create(path, []byte(data), flags); err == nil {
+			err = this.trackEphemeral(sess, r)
+		}
+		this.ephemerals.release()
+	}
+	if noParent, _, exists, ex = zkException(err); ex != nil {
+		log.Error("Q=%s %s {path^%s flags^%d}: %s", IDENT, ctx.String(),
+			path, flags, ex)
+	}
+
+	profiler.do(IDENT, ctx, "{path^%s data^%s flags^%d} {r^%s err^%v}",
+		path, data, flags, r, err)
+	return
+}
+
+func (this *FunServantImpl) ZkGet(ctx *rpc.Context,
+	path string) (r *rpc.TZkData, noNode *rpc.TZkNoNode, ex error) {
+	const IDENT = "zk.get"
+	if this.zk == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	data, version, err := this.zk.Get(path)
+	if noNode, _, _, ex = zkException(err); ex != nil {
+		log.Error("Q=%s %s {path^%s}: %s", IDENT, ctx.String(), path, ex)
+	} else if noNode == nil {
+		r = rpc.NewTZkData()
+		r.Data = string(data)
+		r.Version = version
+	}
+
+	profiler.do(IDENT, ctx, "{path^%s} {v^%d r^%s err^%v}",
+		path, version, string(data), err)
+	return
+}
+
+func (this *FunServantImpl) ZkSet(ctx *rpc.Context, path string,
+	data string, version int32) (r int32, noNode *rpc.TZkNoNode,
+	badVersion *rpc.TZkBadVersion, ex error) {
+	const IDENT = "zk.set"
+	if this.zk == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	r, err = this.zk.Set(path, []byte(data), version)
+	if noNode, badVersion, _, ex = zkException(err); ex != nil {
+		log.Error("Q=%s %s {path^%s v^%d}: %s", IDENT, ctx.String(),
+			path, version, ex)
+	}
+
+	profiler.do(IDENT, ctx, "{path^%s data^%s v^%d} {r^%d err^%v}",
+		path, data, version, r, err)
+	return
+}
+
+func (this *FunServantImpl) ZkExists(ctx *rpc.Context,
+	path string) (r bool, ex error) {
+	const IDENT = "zk.exists"
+	if this.zk == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	r, _, ex = this.zk.Exists(path)
+	if ex != nil {
+		log.Error("Q=%s %s {path^%s}: %s", IDENT, ctx.String(), path, ex)
+	}
+
+	profiler.do(IDENT, ctx, "{path^%s} {r^%v err^%v}", path, r, ex)
+	return
+}
+
+func (this *FunServantImpl) ZkWatch(ctx *rpc.Context, path string,
+	lastVersion int32, timeout int32) (r *rpc.TZkWatchResult, ex error) {
+	const IDENT = "zk.watch"
+	if this.zk == nil {
+		ex = ErrServantNotStarted
+		return
+	}
+
+	profiler, err := this.getSession(ctx).startProfiler()
+	if err != nil {
+		ex = err
+		return
+	}
+
+	svtStats.inc(IDENT)
+
+	wait := time.Duration(timeout) * time.Millisecond
+	if wait > this.conf.ZkMaxWatchWait {
+		wait = this.conf.ZkMaxWatchWait
+	}
+
+	data, version, changed, ex := this.zk.Watch(path, lastVersion, wait)
+	if ex == nil {
+		r = rpc.NewTZkWatchResult()
+		r.Changed = changed
+		r.Exists = version != zk.NoVersion
+		r.Data = string(data)
+		r.Version = version
+	} else {
+		log.Error("Q=%s %s {path^%s v^%d}: %s", IDENT, ctx.String(),
+			path, lastVersion, ex)
+	}
+
+	profiler.do(IDENT, ctx, "{path^%s v^%d timeout^%d} {changed^%v v^%d err^%v}",
+		path, lastVersion, timeout, changed, version, ex)
 	return
 }
 
 func (this *FunServantImpl) ZkChildren(ctx *rpc.Context,
 	path string) (r []string, ex error) {
 	const IDENT = "zk.children"
+	if this.zk == nil {
+		ex = ErrServantNotStarted
+		return
+	}
 
 	profiler, err := this.getSession(ctx).startProfiler()
 	if err != nil {
@@ -38,7 +207,7 @@ func (this *FunServantImpl) ZkChildren(ctx *rpc.Context,
 	}
 
 	svtStats.inc(IDENT)
-	r, ex = etclib.Children(path)
+	r, ex = this.zk.Children(path)
 
 	profiler.do(IDENT, ctx, "{path^%s} {r^%+v err^%v}",
 		path, r, ex)
@@ -48,6 +217,10 @@ func (this *FunServantImpl) ZkChildren(ctx *rpc.Context,
 func (this *FunServantImpl) ZkDel(ctx *rpc.Context,
 	path string) (r bool, ex error) {
 	const IDENT = "zk.del"
+	if this.zk == nil {
+		ex = ErrServantNotStarted
+		return
+	}
 
 	profiler, err := this.getSession(ctx).startProfiler()
 	if err != nil {
@@ -56,11 +229,61 @@ func (this *FunServantImpl) ZkDel(ctx *rpc.Context,
 	}
 
 	svtStats.inc(IDENT)
-	if ex = etclib.Delete(path); ex == nil {
+	if ex = this.zk.Delete(path, zk.NoVersion); ex == nil {
 		r = true
-	} 
+		this.ephemerals.forget(path)
+	}
 
 	profiler.do(IDENT, ctx, "{path^%s} {r^%v err^%v}",
 		path, r, ex)
 	return
 }
+
+// zkException converts zk errors into declared thrift exceptions.
+func zkException(err error) (noNode *rpc.TZkNoNode,
+	badVersion *rpc.TZkBadVersion, exists *rpc.TZkNodeExists, ex error) {
+	switch err {
+	case nil:
+
+	case zk.ErrNoNode:
+		noNode = rpc.NewTZkNoNode()
+		noNode.Message = thrift.StringPtr(err.Error())
+
+	case zk.ErrBadVersion:
+		badVersion = rpc.NewTZkBadVersion()
+		badVersion.Message = thrift.StringPtr(err.Error())
+
+	case zk.ErrNodeExists:
+		exists = rpc.NewTZkNodeExists()
+		exists.Message = thrift.StringPtr(err.Error())
+
+	default:
+		ex = err
+	}
+
+	return
+}
+
+// trackEphemeral ties a just created ephemeral node to the session, the
+// node is deleted if it can't be tracked.
+func (this *FunServantImpl) trackEphemeral(sess *session, path string) error {
+	czxid, err := this.zk.Czxid(path)
+	if err != nil {
+		this.zk.Delete(path, zk.NoVersion)
+		return err
+	}
+
+	this.ephemerals.add(sess, path, czxid)
+	return nil
+}
+
+// removeEphemeral deletes an ephemeral node whose session is gone, unless
+// it has been recreated by others.
+func (this *FunServantImpl) removeEphemeral(path string, czxid int64) error {
+	if err := this.zk.DeleteCreated(path, czxid); err != nil &&
+		err != zk.ErrNoNode {
+		return err
+	}
+
+	return nil
+}
@@ -11,14 +11,47 @@ exception TMongoNotFound {
     11: optional string message
 }
 
+/**
+ * Mongodb pool or shard is degraded by operator or its circuit breaker
+ * is open, client should fall back to cache.
+ */
 exception TMongoDegrade {
     11: optional string message
 }
 
+/**
+ * Mongodb pool or shard is read only, e,g. during maintenance.
+ */
 exception TMongoReadOnly {
     11: optional string message
 }
 
+exception TCouchbaseNotFound {
+    11: optional string message
+}
+
+/**
+ * The couchbase item was changed by others since its cas was read.
+ */
+exception TCouchbaseCasMismatch {
+    11: optional string message
+}
+
+exception TZkNoNode {
+    11: optional string message
+}
+
+/**
+ * The zk node was changed by others since its version was read.
+ */
+exception TZkBadVersion {
+    11: optional string message
+}
+
+exception TZkNodeExists {
+    11: optional string message
+}
+
 struct TMemcacheData {
     1: required binary data
     2: required i32 flags
@@ -29,6 +62,145 @@ struct TCouchbaseData {
     2: required binary data
 }
 
+struct TCouchbaseCasData {
+    1: required binary data
+    2: required i64 cas
+}
+
+/**
+ * Durability requirement of a couchbase write.
+ * The write blocks until it is met, or fails after durability_timeout
+ * while the mutation itself is not rolled back.
+ */
+struct TCouchbaseDurability {
+    /** number of nodes including master the item is persisted to */
+    1: optional i32 persistTo
+
+    /** number of replicas the item is replicated to */
+    2: optional i32 replicateTo
+}
+
+/**
+ * A redis reply that keeps the full RESP structure.
+ *
+ * Exactly one field is set: status for simple string, errorMsg for
+ * error reply, integer, bulk for bulk string, isNil for nil bulk or
+ * nil multi-bulk, elements for (maybe nested) multi-bulk.
+ */
+union TRedisReply {
+    1: string status
+    2: string errorMsg
+    3: i64 integer
+    4: binary bulk
+    5: bool isNil
+    6: list<TRedisReply> elements
+}
+
+struct TZkData {
+    1: required string data
+
+    /** pass it to zk_set for compare and swap, or to zk_watch */
+    2: required i32 version
+}
+
+struct TZkWatchResult {
+    /** false if timeout without change */
+    1: required bool changed
+    2: required bool exists
+
+    /** current data and version, version is -1 if the node is absent */
+    3: required string data
+    4: required i32 version
+}
+
+struct TServiceEndpoint {
+    1: required string addr
+    2: optional map<string, string> meta
+}
+
+struct TServiceEndpoints {
+    1: required list<TServiceEndpoint> endpoints
+
+    /** pass it as lastVersion of the next registry_endpoints */
+    2: required i64 version
+}
+
+struct TRedisCommand {
+    1: required string cmd
+    2: required list<string> keysAndArgs
+}
+
+struct TPubsubMessage {
+    1: required i64 offset
+    2: required string channel
+    3: required binary payload
+}
+
+struct TPubsubPollResult {
+    1: required list<TPubsubMessage> messages
+
+    /**
+     * Pass it as sinceOffset of the next poll.
+     */
+    2: required i64 nextOffset
+
+    /**
+     * Some messages were dropped because the subscriber polled too slow.
+     */
+    3: required bool lost
+}
+
+/**
+ * A single op of mg_bulk_write.
+ */
+struct TMongoWriteOp {
+    /** insert | update | upsert | delete */
+    1: required string op
+
+    /** insert only */
+    2: optional binary doc
+
+    /** update, upsert and delete */
+    3: optional binary query
+    4: optional binary change
+
+    /** update or delete all matching docs instead of the 1st */
+    5: optional bool multi
+}
+
+/**
+ * Result of a single op of mg_bulk_write.
+ */
+struct TMongoWriteResult {
+    1: required i64 matched
+    2: required i64 modified
+
+    /** bson encoded {"_id": id} if a doc is upserted */
+    3: optional binary upsertedId
+
+    /**
+     * 0 means ok, e,g. 11000 for duplicate key.
+     * -1: network error or bad op
+     * -2: skipped because an earlier op failed in ordered mode
+     */
+    4: required i32 errCode
+    5: optional string errMsg
+}
+
+/**
+ * A batch of docs fetched from a server side mongodb cursor.
+ */
+struct TMongoCursorBatch {
+    /**
+     * Pass it to mg_cursor_next to fetch the next batch.
+     * 0 means the cursor is exhausted and already closed.
+     */
+    1: required i64 cursorId
+
+    /** bson encoded docs */
+    2: required list<binary> docs
+}
+
 struct MysqlResult {
     1:required i64 rowsAffected
     2:required i64 lastInsertId
@@ -36,6 +208,19 @@ struct MysqlResult {
     4:required list<list<string>> rows
 }
 
+/**
+ * A batch of rows fetched from a server side mysql cursor.
+ */
+struct MysqlCursorBatch {
+    /**
+     * Pass it to my_cursor_next to fetch the next batch.
+     * 0 means the cursor is exhausted and already closed.
+     */
+    1: required i64 cursorId
+    2: required list<string> cols
+    3: required list<list<string>> rows
+}
+
 struct MysqlMergeResult {
     1:required bool ok
     2:required string newVal
@@ -70,6 +255,22 @@ struct Context {
      * and I will be the final servant in the chain
      */
     4:optional bool sticky
+
+    /**
+     * If set, read only redis cmds may be served by replicas
+     * which can lag behind the master.
+     */
+    5:optional bool staleOk
+
+    /**
+     * Override mongodb read preference of the pool for reads:
+     * primary | primaryPreferred | secondary | nearest | monotonic
+     *
+     * e,g. reporting queries can be pushed to secondaries by monotonic.
+     * The driver only approximates them: primaryPreferred is monotonic,
+     * secondary and nearest read from any member.
+     */
+    6:optional string readPref
 }
 
 /**
@@ -85,12 +286,73 @@ service FunServant {
     // zk section
     //=================
 
+    /**
+     * Create a persistent zk node.
+     */
     bool zk_create(
         1: required Context ctx,
         2: required string path,
         3: required string data
     ),
 
+    /**
+     * Create a zk node with flags.
+     *
+     * An ephemeral node is deleted once the session(ctx.rid) makes no
+     * call for zk_ephemeral_idle_timeout, or if fae itself dies.
+     *
+     * @param i32 flags - bitmask of 1:ephemeral, 2:sequential, default 0
+     * @return string - the actual path, sequential node has a suffix
+     */
+    string zk_create_node(
+        1: required Context ctx,
+        2: required string path,
+        3: required string data,
+        4: i32 flags
+    ) throws (
+        1: TZkNodeExists exists,
+        2: TZkNoNode noParent
+    ),
+
+    TZkData zk_get(
+        1: required Context ctx,
+        2: required string path
+    ) throws (
+        1: TZkNoNode noNode
+    ),
+
+    /**
+     * @param i32 version - compare and swap, -1 for any version
+     * @return i32 - the new version
+     */
+    i32 zk_set(
+        1: required Context ctx,
+        2: required string path,
+        3: required string data,
+        4: required i32 version
+    ) throws (
+        1: TZkNoNode noNode,
+        2: TZkBadVersion badVersion
+    ),
+
+    bool zk_exists(
+        1: required Context ctx,
+        2: required string path
+    ),
+
+    /**
+     * Long poll till version of the node differs from lastVersion.
+     *
+     * @param i32 lastVersion - from zk_get or last zk_watch, -1 if absent
+     * @param i32 timeout - max milliseconds to wait
+     */
+    TZkWatchResult zk_watch(
+        1: required Context ctx,
+        2: required string path,
+        3: required i32 lastVersion,
+        4: required i32 timeout
+    ),
+
     list<string> zk_children(
         1: required Context ctx,
         2: required string path
@@ -101,6 +363,75 @@ service FunServant {
         2: required string path
     ),
 
+    //=================
+    // election section
+    //=================
+
+    /**
+     * Join the election or renew the lease, faed resigns the candidate
+     * if it does not campaign again within ttl.
+     *
+     * @param i32 ttl - lease in seconds, capped by election_max_ttl
+     * @return bool - whether the candidate is the leader now
+     */
+    bool election_campaign(
+        1: required Context ctx,
+        2: required string name,
+        3: required string candidateId,
+        4: required i32 ttl
+    ),
+
+    /**
+     * @return string - candidateId of the leader, empty if none
+     */
+    string election_leader(
+        1: required Context ctx,
+        2: required string name
+    ),
+
+    bool election_resign(
+        1: required Context ctx,
+        2: required string name,
+        3: required string candidateId
+    ),
+
+    //=================
+    // registry section
+    //=================
+
+    /**
+     * Register an endpoint of the service or renew its lease, faed
+     * deregisters it if it does not register again within ttl.
+     *
+     * @param i32 ttl - lease in seconds, capped by registry_max_ttl
+     */
+    bool registry_register(
+        1: required Context ctx,
+        2: required string service,
+        3: required string addr,
+        4: map<string, string> meta,
+        5: required i32 ttl
+    ),
+
+    bool registry_deregister(
+        1: required Context ctx,
+        2: required string service,
+        3: required string addr
+    ),
+
+    /**
+     * Healthy endpoints of the service, served from local cache.
+     *
+     * @param i64 lastVersion - if it is current, wait for change
+     * @param i32 wait - max milliseconds to wait, 0 to return at once
+     */
+    TServiceEndpoints registry_endpoints(
+        1: required Context ctx,
+        2: required string service,
+        3: required i64 lastVersion,
+        4: required i32 wait
+    ),
+
     /**
      * Just for QPS throughput testing.
      */
@@ -201,6 +532,95 @@ service FunServant {
         4: required list<string> keysAndArgs
     ),
 
+    /**
+     * Same as rd_call, but the reply is not flattened into a string.
+     *
+     * e,g. HGETALL, ZRANGE WITHSCORES, SCAN and EVAL results can be
+     * decoded by client without losing nested arrays, nils and errors.
+     */
+    TRedisReply rd_call_typed(
+        1: required Context ctx, 
+        2: required string cmd,
+        3: required string pool,
+        4: required list<string> keysAndArgs
+    ),
+
+    /**
+     * Pipeline a batch of commands within a single RPC.
+     *
+     * Commands are grouped by target redis server, each group is sent
+     * as one pipeline and groups run in parallel.
+     *
+     * @return list<TRedisReply> - replies in the same order of cmds.
+     */
+    list<TRedisReply> rd_pipeline(
+        1: required Context ctx, 
+        2: required string pool,
+        3: required list<TRedisCommand> cmds
+    ),
+
+    /**
+     * Run a lua script on the redis server that owns all the keys.
+     *
+     * Routed by keys instead of the 1st arg, and all keys must be on
+     * the same server.
+     * EVALSHA is always tried first and transparently falls back to
+     * EVAL on NOSCRIPT.
+     */
+    TRedisReply rd_eval(
+        1: required Context ctx, 
+        2: required string pool,
+        3: required string script,
+        4: required list<string> keys,
+        5: required list<string> args
+    ),
+
+    /**
+     * Atomically run cmds inside MULTI/EXEC on a single connection.
+     *
+     * The 1st arg of each cmd is the key, and all keys must be on the
+     * same server.
+     *
+     * @return list<TRedisReply> - EXEC replies in the same order of cmds.
+     */
+    list<TRedisReply> rd_multi(
+        1: required Context ctx, 
+        2: required string pool,
+        3: required list<TRedisCommand> cmds
+    ),
+
+    /**
+     * Publish a message to the pubsub pool.
+     *
+     * @return i64 - number of redis subscribers that received it.
+     */
+    i64 rd_publish(
+        1: required Context ctx, 
+        2: required string channel,
+        3: required binary payload
+    ),
+
+    /**
+     * Long poll messages of a configured channel or pattern.
+     *
+     * Messages are buffered per subscriber since its first poll of the
+     * channel, and subscribers idle too long are dropped.
+     *
+     * @param string subscriberId - e,g. uid
+     * @param string channel - channel name or pattern as configured
+     * @param i64 sinceOffset - negative means only messages after now
+     * @param i32 max - max number of messages returned
+     * @param i32 wait - max milliseconds to wait if no message yet
+     */
+    TPubsubPollResult rd_poll(
+        1: required Context ctx, 
+        2: required string subscriberId,
+        3: required string channel,
+        4: required i64 sinceOffset,
+        5: required i32 max,
+        6: required i32 wait
+    ),
+
     //=================
     // memcache section
     //=================
@@ -293,6 +713,14 @@ service FunServant {
     // all bson codec
     //=================
 
+    /**
+     * Reads of mg_find_one, mg_find_all and mg_find_id can be cached by
+     * a non-empty cacheKey: the call is routed to the peer that owns the
+     * key and the result is kept in the same cache store of mysql.
+     * Writes carrying the same cacheKey evict it.
+     *
+     * A miss is never cached.
+     */
     binary mg_find_one(
         1: required Context ctx, 
         2: string pool,
@@ -300,11 +728,17 @@ service FunServant {
         4: i32 shardId,
         /** where condition */
         5: binary query,
-        6: binary fields
+        6: binary fields,
+        7: string cacheKey
     ) throws (
-        1: TMongoNotFound miss
+        1: TMongoNotFound miss,
+        2: TMongoDegrade degrade
     ),
 
+    /**
+     * Fails if the result exceeds max_result_rows or max_result_bytes,
+     * use mg_cursor_open for large result sets instead.
+     */
     list<binary> mg_find_all(
         1: required Context ctx, 
         2: string pool,
@@ -314,15 +748,25 @@ service FunServant {
         6: binary fields,
         7: i32 limit,
         8: i32 skip,
-        9: list<string> orderBy
+        9: list<string> orderBy,
+        10: string cacheKey
+    ) throws (
+        1: TMongoDegrade degrade
     ),
 
+    /**
+     * @param binary id - bson encoded {"_id": id}
+     */
     binary mg_find_id(
         1: required Context ctx,
         2: string pool,
         3: string table,
         4: i32 shardId,
-        5: binary id
+        5: binary id,
+        6: string cacheKey
+    ) throws (
+        1: TMongoDegrade degrade,
+        2: TMongoNotFound miss
     ),
 
     i32 mg_count(
@@ -331,6 +775,8 @@ service FunServant {
         3: string table,
         4: i32 shardId,
         5: binary query
+    ) throws (
+        1: TMongoDegrade degrade
     ),
 
     bool mg_update(
@@ -339,7 +785,11 @@ service FunServant {
         3: string table,
         4: i32 shardId,
         5: binary query,
-        6: binary change
+        6: binary change,
+        7: string cacheKey
+    ) throws (
+        1: TMongoDegrade degrade,
+        2: TMongoReadOnly readOnly
     ),
 
     bool mg_update_id(
@@ -349,6 +799,9 @@ service FunServant {
         4: i32 shardId,
         5: i32 id,
         6: binary change
+    ) throws (
+        1: TMongoDegrade degrade,
+        2: TMongoReadOnly readOnly
     ),
 
     bool mg_upsert(
@@ -357,7 +810,11 @@ service FunServant {
         3: string table,
         4: i32 shardId,
         5: binary query,
-        6: binary change
+        6: binary change,
+        7: string cacheKey
+    ) throws (
+        1: TMongoDegrade degrade,
+        2: TMongoReadOnly readOnly
     ),
 
     bool mg_upsert_id(
@@ -367,6 +824,9 @@ service FunServant {
         4: i32 shardId,
         5: i32 id,
         6: binary change
+    ) throws (
+        1: TMongoDegrade degrade,
+        2: TMongoReadOnly readOnly
     ),
 
     bool mg_insert(
@@ -375,6 +835,9 @@ service FunServant {
         3: string table,
         4: i32 shardId,
         5: binary doc
+    ) throws (
+        1: TMongoDegrade degrade,
+        2: TMongoReadOnly readOnly
     ),
 
     bool mg_inserts(
@@ -383,6 +846,9 @@ service FunServant {
         3: string table,
         4: i32 shardId,
         5: list<binary> docs
+    ) throws (
+        1: TMongoDegrade degrade,
+        2: TMongoReadOnly readOnly
     ),
 
     bool mg_delete(
@@ -390,7 +856,11 @@ service FunServant {
         2: string pool,
         3: string table,
         4: i32 shardId,
-        5: binary query
+        5: binary query,
+        6: string cacheKey
+    ) throws (
+        1: TMongoDegrade degrade,
+        2: TMongoReadOnly readOnly
     ),
 
     binary mg_find_and_modify(
@@ -402,7 +872,102 @@ service FunServant {
         6: binary change,
         7: bool upsert,
         8: bool remove,
-        9: bool returnNew
+        9: bool returnNew,
+        10: string cacheKey
+    ) throws (
+        1: TMongoDegrade degrade,
+        2: TMongoReadOnly readOnly
+    ),
+
+    /**
+     * Run an aggregation pipeline.
+     *
+     * Result is capped by max_result_docs of mongodb config, exceeding
+     * it is an error instead of truncated result.
+     *
+     * @param list<binary> pipeline - bson encoded stages
+     * @param bool allowDisk - allow stages to write temp files
+     */
+    list<binary> mg_aggregate(
+        1: required Context ctx,
+        2: string pool,
+        3: string table,
+        4: i32 shardId,
+        5: list<binary> pipeline,
+        6: bool allowDisk
+    ) throws (
+        1: TMongoDegrade degrade
+    ),
+
+    /**
+     * Distinct values of a field.
+     *
+     * Result is capped by max_result_docs of mongodb config.
+     *
+     * @return binary - bson encoded {"values": [...]}
+     */
+    binary mg_distinct(
+        1: required Context ctx,
+        2: string pool,
+        3: string table,
+        4: i32 shardId,
+        5: string key,
+        6: binary query
+    ) throws (
+        1: TMongoDegrade degrade
+    ),
+
+    /**
+     * Run a mixed list of insert/update/upsert/delete ops.
+     *
+     * In ordered mode ops after the 1st failure are skipped, else all
+     * ops are tried.
+     *
+     * @return list<TMongoWriteResult> - in the same order of ops, so that
+     *     caller can retry only the failed ones.
+     */
+    list<TMongoWriteResult> mg_bulk_write(
+        1: required Context ctx,
+        2: string pool,
+        3: string table,
+        4: i32 shardId,
+        5: list<TMongoWriteOp> ops,
+        6: bool ordered
+    ) throws (
+        1: TMongoDegrade degrade,
+        2: TMongoReadOnly readOnly
+    ),
+
+    /**
+     * Open a server side cursor and fetch the 1st batch.
+     *
+     * Cursors are bound to ctx.rid, and closed after being idle for
+     * cursor_idle_timeout. Always close a cursor that is not exhausted.
+     *
+     * @param i32 batchSize - 0 means cursor_batch_size of config
+     */
+    TMongoCursorBatch mg_cursor_open(
+        1: required Context ctx,
+        2: string pool,
+        3: string table,
+        4: i32 shardId,
+        5: binary query,
+        6: binary fields,
+        7: list<string> orderBy,
+        8: i32 batchSize
+    ) throws (
+        1: TMongoDegrade degrade
+    ),
+
+    TMongoCursorBatch mg_cursor_next(
+        1: required Context ctx,
+        2: i64 cursorId,
+        3: i32 batchSize
+    ),
+
+    void mg_cursor_close(
+        1: required Context ctx,
+        2: i64 cursorId
     ),
 
     //=================
@@ -419,6 +984,10 @@ service FunServant {
         7: list<string> cacheKey
     ),
 
+    /**
+     * Same as mg_find_all, a SELECT fails if the result exceeds
+     * max_result_rows or max_result_bytes.
+     */
     MysqlResult my_query(
         1: required Context ctx,
         2: string pool,
@@ -456,6 +1025,33 @@ service FunServant {
         8: string jsonValue
     ),
 
+    /**
+     * Open a server side cursor for a SELECT and fetch the 1st batch.
+     *
+     * Same as mg_cursor_open, the underlying conn is held until the
+     * cursor is exhausted, closed or expired.
+     */
+    MysqlCursorBatch my_cursor_open(
+        1: required Context ctx,
+        2: string pool,
+        3: string table,
+        4: i64 hintId,
+        5: string sql,
+        6: list<string> argv,
+        7: i32 batchSize
+    ),
+
+    MysqlCursorBatch my_cursor_next(
+        1: required Context ctx,
+        2: i64 cursorId,
+        3: i32 batchSize
+    ),
+
+    void my_cursor_close(
+        1: required Context ctx,
+        2: i64 cursorId
+    ),
+
     /** 
      * Manually evict a mysql cache by cacheKey.
      */
@@ -487,6 +1083,7 @@ service FunServant {
         3: string key,
         4: binary val,
         5: i32 expire,
+        6: optional TCouchbaseDurability durability
     ),
 
     void cb_set(
@@ -495,6 +1092,102 @@ service FunServant {
         3: string key,
         4: binary val,
         5: i32 expire,
+        6: optional TCouchbaseDurability durability
+    ),
+
+    void cb_replace(
+        1: Context ctx,
+        2: string bucket,
+        3: string key,
+        4: binary val,
+        5: i32 expire,
+        6: optional TCouchbaseDurability durability
+    ) throws (
+        1: TCouchbaseNotFound miss
+    ),
+
+    /**
+     * Set multiple items concurrently.
+     *
+     * @return map<string, string> - error message of failed keys
+     */
+    map<string, string> cb_set_multi(
+        1: Context ctx,
+        2: string bucket,
+        3: map<string, binary> items,
+        4: i32 expire,
+        5: optional TCouchbaseDurability durability
+    ),
+
+    /**
+     * Get an item with its cas for a later cb_cas.
+     */
+    TCouchbaseCasData cb_gets_cas(
+        1: Context ctx,
+        2: string bucket,
+        3: string key
+    ) throws (
+        1: TCouchbaseNotFound miss
+    ),
+
+    /**
+     * Optimistic concurrency: set only if the item is not changed since
+     * cas was read, cas must be positive.
+     *
+     * @return i64 - new cas of the item
+     */
+    i64 cb_cas(
+        1: Context ctx,
+        2: string bucket,
+        3: string key,
+        4: binary val,
+        5: i32 expire,
+        6: i64 cas,
+        7: optional TCouchbaseDurability durability
+    ) throws (
+        1: TCouchbaseNotFound miss,
+        2: TCouchbaseCasMismatch casMismatch
+    ),
+
+    /**
+     * Atomic counter, the item is created with initial if absent.
+     * Negative delta decrements, initial must not be negative.
+     *
+     * @return i64 - value after incr
+     */
+    i64 cb_incr(
+        1: Context ctx,
+        2: string bucket,
+        3: string key,
+        4: i64 delta,
+        5: i64 initial,
+        6: i32 expire,
+        7: optional TCouchbaseDurability durability
+    ),
+
+    /**
+     * Same as cb_incr, but never goes below 0.
+     */
+    i64 cb_decr(
+        1: Context ctx,
+        2: string bucket,
+        3: string key,
+        4: i64 delta,
+        5: i64 initial,
+        6: i32 expire,
+        7: optional TCouchbaseDurability durability
+    ),
+
+    /**
+     * Reset expiry of an existing item.
+     */
+    void cb_touch(
+        1: Context ctx,
+        2: string bucket,
+        3: string key,
+        4: i32 expire
+    ) throws (
+        1: TCouchbaseNotFound miss
     ),
 
     TCouchbaseData cb_get(
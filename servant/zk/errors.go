@@ -0,0 +1,33 @@
+package zk
+
+import (
+	"errors"
+	gozk "github.com/samuel/go-zookeeper/zk"
+)
+
+var (
+	ErrNoNode       = errors.New("zk: node does not exist")
+	ErrNodeExists   = errors.New("zk: node already exists")
+	ErrBadVersion   = errors.New("zk: version conflict")
+	ErrNotEmpty     = errors.New("zk: node has children")
+	ErrInvalidFlags = errors.New("zk: invalid create flags")
+)
+
+// mapError converts zk errors that callers need to tell apart.
+func mapError(err error) error {
+	switch err {
+	case gozk.ErrNoNode:
+		return ErrNoNode
+
+	case gozk.ErrNodeExists:
+		return ErrNodeExists
+
+	case gozk.ErrBadVersion:
+		return ErrBadVersion
+
+	case gozk.ErrNotEmpty:
+		return ErrNotEmpty
+	}
+
+	return err
+}
@@ -0,0 +1,248 @@
+// Package zk is the coordination servant on zookeeper.
+//
+// etclib only exposes what fae itself needs for service discovery, so
+// version aware reads/writes, ephemeral nodes and watches go through a
+// zk session of our own.
+//
+// Ephemeral nodes live as long as that session, i,e. the fae process,
+// tying them to shorter lived RPC sessions is up to the caller.
+package zk
+
+import (
+	log "github.com/funkygao/log4go"
+	gozk "github.com/samuel/go-zookeeper/zk"
+	"strings"
+	"time"
+)
+
+const (
+	// flags of Create, same as zk
+	FlagEphemeral = gozk.FlagEphemeral
+	FlagSequence  = gozk.FlagSequence
+
+	// version of an absent node in Watch, and any version in Set/Delete
+	NoVersion = -1
+)
+
+type Client struct {
+	conn *gozk.Conn
+	acl  []gozk.ACL
+}
+
+func New(servers []string, sessionTimeout time.Duration) (*Client, error) {
+	conn, events, err := gozk.Connect(servers, sessionTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	this := &Client{conn: conn, acl: gozk.WorldACL(gozk.PermAll)}
+	go this.watchSession(events)
+	return this, nil
+}
+
+func (this *Client) watchSession(events <-chan gozk.Event) {
+	for evt := range events {
+		switch evt.State {
+		case gozk.StateExpired:
+			// conn will reestablish a new session, ephemerals are gone
+			log.Warn("zk session expired, ephemeral nodes lost")
+
+		case gozk.StateDisconnected:
+			log.Warn("zk disconnected")
+
+		case gozk.StateHasSession:
+			log.Info("zk session established")
+		}
+	}
+}
+
+func (this *Client) Close() {
+	this.conn.Close()
+}
+
+func (this *Client) Get(path string) (data []byte, version int32, err error) {
+	data, stat, err := this.conn.Get(path)
+	if err != nil {
+		return nil, 0, mapError(err)
+	}
+
+	return data, stat.Version, nil
+}
+
+// Set is a compare and swap if version is not NoVersion, it returns the
+// new version.
+func (this *Client) Set(path string, data []byte,
+	version int32) (newVersion int32, err error) {
+	stat, err := this.conn.Set(path, data, version)
+	if err != nil {
+		return 0, mapError(err)
+	}
+
+	return stat.Version, nil
+}
+
+func (this *Client) Exists(path string) (exists bool, version int32,
+	err error) {
+	exists, stat, err := this.conn.Exists(path)
+	if err != nil {
+		return false, 0, mapError(err)
+	}
+
+	version = NoVersion
+	if exists {
+		version = stat.Version
+	}
+	return
+}
+
+// Create returns the actual path, which differs from path for sequential
+// nodes.
+func (this *Client) Create(path string, data []byte,
+	flags int32) (string, error) {
+	if flags&^(FlagEphemeral|FlagSequence) != 0 {
+		return "", ErrInvalidFlags
+	}
+
+	actualPath, err := this.conn.Create(path, data, flags, this.acl)
+	return actualPath, mapError(err)
+}
+
+func (this *Client) Children(path string) ([]string, error) {
+	children, _, err := this.conn.Children(path)
+	return children, mapError(err)
+}
+
+// ChildrenW returns children and a chan that fires once on change of
+// them or loss of the watch.
+func (this *Client) ChildrenW(path string) ([]string, <-chan struct{},
+	error) {
+	children, _, events, err := this.conn.ChildrenW(path)
+	if err != nil {
+		return nil, nil, mapError(err)
+	}
+
+	changed := make(chan struct{}, 1)
+	go func() {
+		<-events
+		changed <- struct{}{}
+	}()
+	return children, changed, nil
+}
+
+// ExistsW tells if the node exists, and returns a chan that fires once
+// on its creation, deletion, data change or loss of the watch.
+func (this *Client) ExistsW(path string) (bool, <-chan struct{}, error) {
+	exists, _, events, err := this.conn.ExistsW(path)
+	if err != nil {
+		return false, nil, mapError(err)
+	}
+
+	changed := make(chan struct{}, 1)
+	go func() {
+		<-events
+		changed <- struct{}{}
+	}()
+	return exists, changed, nil
+}
+
+func (this *Client) Delete(path string, version int32) error {
+	return mapError(this.conn.Delete(path, version))
+}
+
+// Czxid returns id of the transaction that created the node, which tells
+// the node from another one created later at the same path.
+func (this *Client) Czxid(path string) (int64, error) {
+	exists, stat, err := this.conn.Exists(path)
+	if err != nil {
+		return 0, mapError(err)
+	}
+	if !exists {
+		return 0, ErrNoNode
+	}
+
+	return stat.Czxid, nil
+}
+
+// DeleteCreated deletes the node only if it is still the one created by
+// transaction czxid, otherwise ErrNoNode.
+func (this *Client) DeleteCreated(path string, czxid int64) error {
+	for {
+		exists, stat, err := this.conn.Exists(path)
+		if err != nil {
+			return mapError(err)
+		}
+		if !exists || stat.Czxid != czxid {
+			return ErrNoNode
+		}
+
+		err = mapError(this.conn.Delete(path, stat.Version))
+		if err != ErrBadVersion {
+			return err
+		}
+
+		// set by others in between, check again
+	}
+}
+
+// Watch blocks till version of the node differs from lastVersion or
+// timeout, absent node is of NoVersion.
+//
+// A node deleted and recreated between 2 calls is of the same version,
+// so is not noticed.
+func (this *Client) Watch(path string, lastVersion int32,
+	timeout time.Duration) (data []byte, version int32, changed bool,
+	err error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		exists, stat, events, e := this.conn.ExistsW(path)
+		if e != nil {
+			err = mapError(e)
+			return
+		}
+
+		version = NoVersion
+		if exists {
+			version = stat.Version
+		}
+
+		if version != lastVersion {
+			if !exists {
+				return nil, version, true, nil
+			}
+
+			data, version, err = this.Get(path)
+			if err == ErrNoNode {
+				// deleted right after ExistsW
+				continue
+			}
+			return data, version, err == nil, err
+		}
+
+		wait := deadline.Sub(time.Now())
+		if wait <= 0 {
+			return
+		}
+
+		// zk watches can't be cancelled, a pending one just fires into
+		// its buffered chan later
+		select {
+		case <-events:
+		case <-time.After(wait):
+			return
+		}
+	}
+}
+
+// CreateAll creates path and its missing parents as persistent nodes.
+func (this *Client) CreateAll(path string) error {
+	p := ""
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		p += "/" + name
+		if _, err := this.Create(p, nil, 0); err != nil &&
+			err != ErrNodeExists {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,167 @@
+// Package zktest is an in memory zk for tests of packages built on zk,
+// with just enough semantics of nodes, sequences and watches.
+package zktest
+
+import (
+	"fmt"
+	"github.com/funkygao/fae/servant/zk"
+	"path"
+	"strings"
+	"sync"
+)
+
+type Store struct {
+	sync.Mutex
+	seq      int
+	zxid     int64
+	nodes    map[string][]byte
+	czxids   map[string]int64
+	watchers map[string][]chan struct{}
+}
+
+func NewStore() *Store {
+	return &Store{nodes: make(map[string][]byte),
+		czxids:   make(map[string]int64),
+		watchers: make(map[string][]chan struct{})}
+}
+
+// fire triggers watches of the node and children watches of its parent.
+// Caller MUST hold the lock.
+func (this *Store) fire(p string) {
+	for _, node := range []string{p, path.Dir(p)} {
+		for _, ch := range this.watchers[node] {
+			ch <- struct{}{}
+		}
+		delete(this.watchers, node)
+	}
+}
+
+// watch MUST be called with the lock held.
+func (this *Store) watch(p string) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	this.watchers[p] = append(this.watchers[p], ch)
+	return ch
+}
+
+func (this *Store) CreateAll(p string) error {
+	this.Lock()
+	defer this.Unlock()
+	for p != "/" {
+		if _, present := this.nodes[p]; !present {
+			this.zxid++
+			this.nodes[p], this.czxids[p] = nil, this.zxid
+			this.fire(p)
+		}
+		p = path.Dir(p)
+	}
+	return nil
+}
+
+func (this *Store) Create(p string, data []byte,
+	flags int32) (string, error) {
+	this.Lock()
+	defer this.Unlock()
+	if _, present := this.nodes[path.Dir(p)]; !present {
+		return "", zk.ErrNoNode
+	}
+	if flags&zk.FlagSequence != 0 {
+		this.seq++
+		p = fmt.Sprintf("%s%010d", p, this.seq)
+	}
+	if _, present := this.nodes[p]; present {
+		return "", zk.ErrNodeExists
+	}
+	this.zxid++
+	this.nodes[p], this.czxids[p] = data, this.zxid
+	this.fire(p)
+	return p, nil
+}
+
+func (this *Store) Get(p string) ([]byte, int32, error) {
+	this.Lock()
+	defer this.Unlock()
+	data, present := this.nodes[p]
+	if !present {
+		return nil, 0, zk.ErrNoNode
+	}
+	return data, 0, nil
+}
+
+func (this *Store) Exists(p string) (bool, int32, error) {
+	this.Lock()
+	defer this.Unlock()
+	_, present := this.nodes[p]
+	return present, 0, nil
+}
+
+func (this *Store) ExistsW(p string) (bool, <-chan struct{}, error) {
+	this.Lock()
+	defer this.Unlock()
+	_, present := this.nodes[p]
+	return present, this.watch(p), nil
+}
+
+func (this *Store) Children(p string) ([]string, error) {
+	this.Lock()
+	defer this.Unlock()
+	return this.children(p)
+}
+
+func (this *Store) ChildrenW(p string) ([]string, <-chan struct{},
+	error) {
+	this.Lock()
+	defer this.Unlock()
+	children, err := this.children(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	return children, this.watch(p), nil
+}
+
+// children MUST be called with the lock held.
+func (this *Store) children(p string) ([]string, error) {
+	if _, present := this.nodes[p]; !present {
+		return nil, zk.ErrNoNode
+	}
+	children := make([]string, 0)
+	for node := range this.nodes {
+		if path.Dir(node) == p && node != p {
+			children = append(children, strings.TrimPrefix(node, p+"/"))
+		}
+	}
+	return children, nil
+}
+
+func (this *Store) Delete(p string, version int32) error {
+	this.Lock()
+	defer this.Unlock()
+	if _, present := this.nodes[p]; !present {
+		return zk.ErrNoNode
+	}
+	delete(this.nodes, p)
+	delete(this.czxids, p)
+	this.fire(p)
+	return nil
+}
+
+func (this *Store) Czxid(p string) (int64, error) {
+	this.Lock()
+	defer this.Unlock()
+	czxid, present := this.czxids[p]
+	if !present {
+		return 0, zk.ErrNoNode
+	}
+	return czxid, nil
+}
+
+func (this *Store) DeleteCreated(p string, czxid int64) error {
+	this.Lock()
+	defer this.Unlock()
+	if this.czxids[p] != czxid || czxid == 0 {
+		return zk.ErrNoNode
+	}
+	delete(this.nodes, p)
+	delete(this.czxids, p)
+	this.fire(p)
+	return nil
+}